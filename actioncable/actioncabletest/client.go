@@ -0,0 +1,479 @@
+package actioncabletest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+
+	"github.com/sargassum-world/godest/actioncable"
+	"github.com/sargassum-world/godest/marshaling"
+)
+
+// Messages
+
+// Action Cable server message types. Data messages have no type.
+const (
+	TypeWelcome             = "welcome"
+	TypePing                = "ping"
+	TypeConfirmSubscription = "confirm_subscription"
+	TypeRejectSubscription  = "reject_subscription"
+	TypeDisconnect          = "disconnect"
+	// Server message types of the extended protocol.
+	TypeConfirmHistory = "confirm_history"
+	TypeRejectHistory  = "reject_history"
+	TypePresence       = "presence"
+)
+
+// Action Cable client commands.
+const (
+	CommandSubscribe   = "subscribe"
+	CommandUnsubscribe = "unsubscribe"
+	CommandMessage     = "message"
+	// Client commands of the extended protocol.
+	CommandHistory  = "history"
+	CommandJoin     = "join"
+	CommandLeave    = "leave"
+	CommandPresence = "presence"
+)
+
+// Presence message types of the extended protocol.
+const (
+	PresenceJoin  = "join"
+	PresenceLeave = "leave"
+	PresenceInfo  = "info"
+	PresenceError = "error"
+)
+
+// Command is an Action Cable client-to-server command.
+type Command struct {
+	Command    string                      `json:"command"`
+	Identifier string                      `json:"identifier"`
+	Data       string                      `json:"data,omitempty"`
+	History    *actioncable.HistoryRequest `json:"history,omitempty"`
+	Presence   *actioncable.PresenceRecord `json:"presence,omitempty"`
+}
+
+// Message is an Action Cable server-to-client message, with the fields of all types of messages.
+type Message struct {
+	Type       string `json:"type,omitempty"`
+	Identifier string `json:"identifier,omitempty"`
+	// Message is the payload of a data message, or the timestamp of a ping message.
+	Message   any    `json:"message,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Reconnect bool   `json:"reconnect,omitempty"`
+	// SID is the session ID of a welcome message of the extended protocol.
+	SID string `json:"sid,omitempty"`
+	// StreamID, Epoch, and Offset identify the stream and position of a data message of the extended
+	// protocol.
+	StreamID string `json:"stream_id,omitempty"`
+	Epoch    string `json:"epoch,omitempty"`
+	Offset   uint64 `json:"offset,omitempty"`
+}
+
+// Position returns the position of a data message of the extended protocol in the history of its
+// stream.
+func (m Message) Position() actioncable.StreamPosition {
+	return actioncable.StreamPosition{Epoch: m.Epoch, Offset: m.Offset}
+}
+
+// PresenceMessage is the payload of a presence message of the extended protocol, with the fields
+// of all types of presence messages.
+type PresenceMessage struct {
+	Type    string                       `json:"type"`
+	ID      string                       `json:"id,omitempty"`
+	Info    any                          `json:"info,omitempty"`
+	Total   int                          `json:"total,omitempty"`
+	Records []actioncable.PresenceRecord `json:"records,omitempty"`
+}
+
+// Presence returns the payload of a presence message.
+func (m Message) Presence() (PresenceMessage, error) {
+	if m.Type != TypePresence {
+		return PresenceMessage{}, errors.Errorf("expected presence message but received %s", m)
+	}
+	// The payload was decoded generically, so we re-encode it to decode it into a PresenceMessage
+	encoded, err := json.Marshal(m.Message)
+	if err != nil {
+		return PresenceMessage{}, errors.Wrap(err, "couldn't encode presence message payload")
+	}
+	var presence PresenceMessage
+	if err = json.Unmarshal(encoded, &presence); err != nil {
+		return PresenceMessage{}, errors.Wrap(err, "couldn't decode presence message payload")
+	}
+	return presence, nil
+}
+
+// String returns a concise representation of the message for error messages.
+func (m Message) String() string {
+	typ := m.Type
+	if typ == "" {
+		typ = "data"
+	}
+	switch {
+	case m.Type == TypeDisconnect:
+		return fmt.Sprintf("%s(reason=%q, reconnect=%t)", typ, m.Reason, m.Reconnect)
+	case m.Identifier != "":
+		return fmt.Sprintf("%s(%s)", typ, m.Identifier)
+	default:
+		return typ
+	}
+}
+
+// Text returns the payload of a data message as a string.
+func (m Message) Text() (string, error) {
+	switch payload := m.Message.(type) {
+	case string:
+		return payload, nil
+	case []byte:
+		return string(payload), nil
+	default:
+		return "", errors.Errorf("message payload has unexpected type %T", m.Message)
+	}
+}
+
+// Client
+
+// Client is an Action Cable client for testing servers.
+type Client struct {
+	wsc         *websocket.Conn
+	subprotocol string
+	marshaler   marshaling.Marshaler
+	messageType int
+	received    chan Message
+	sid         string
+
+	// readErr is the error which stopped the reading of messages; it may only be read after received
+	// is closed
+	readErr error
+	writeMu sync.Mutex
+}
+
+// Dial opens an Action Cable connection to the WebSocket URL with the subprotocol, which must be
+// one of the subprotocols from [actioncable.SupportedSubprotocols]. The Client should be closed
+// when the test finishes.
+func Dial(ctx context.Context, url, subprotocol string) (*Client, error) {
+	c := &Client{
+		subprotocol: subprotocol,
+		received:    make(chan Message),
+	}
+	switch subprotocol {
+	default:
+		return nil, errors.Errorf("unsupported subprotocol %s", subprotocol)
+	case actioncable.ActionCableV1JSONSubprotocol, actioncable.ActionCableV1ExtJSONSubprotocol:
+		c.marshaler = marshaling.JSON{}
+		c.messageType = websocket.TextMessage
+	case actioncable.ActionCableV1MsgpackSubprotocol:
+		c.marshaler = marshaling.MessagePack{}
+		c.messageType = websocket.BinaryMessage
+	}
+
+	dialer := websocket.Dialer{
+		Subprotocols: []string{subprotocol},
+	}
+	wsc, res, err := dialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't dial %s", url)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		return nil, errors.Errorf("unexpected response status %d", res.StatusCode)
+	}
+	if negotiated := wsc.Subprotocol(); negotiated != subprotocol {
+		_ = wsc.Close()
+		return nil, errors.Errorf(
+			"server negotiated subprotocol %q instead of %s", negotiated, subprotocol,
+		)
+	}
+	c.wsc = wsc
+	go c.readAll()
+	return c, nil
+}
+
+// readAll reads all messages from the server into the received channel, until reading fails.
+func (c *Client) readAll() {
+	defer close(c.received)
+	for {
+		messageType, marshaled, err := c.wsc.ReadMessage()
+		if err != nil {
+			c.readErr = err
+			return
+		}
+		if messageType != c.messageType {
+			c.readErr = errors.Errorf(
+				"unexpected websocket message type %d (expected %d)", messageType, c.messageType,
+			)
+			return
+		}
+		var message Message
+		if err = c.marshaler.Unmarshal(marshaled, &message); err != nil {
+			c.readErr = errors.Wrapf(err, "couldn't unmarshal server message %q", marshaled)
+			return
+		}
+		c.received <- message
+	}
+}
+
+// Subprotocol returns the subprotocol of the connection.
+func (c *Client) Subprotocol() string {
+	return c.subprotocol
+}
+
+// Extended returns whether the connection uses the extended protocol.
+func (c *Client) Extended() bool {
+	return c.subprotocol == actioncable.ActionCableV1ExtJSONSubprotocol
+}
+
+// SessionID returns the session ID from the server's welcome message, which is only sent with the
+// extended protocol. It's empty until [Client.ExpectWelcome] returns.
+func (c *Client) SessionID() string {
+	return c.sid
+}
+
+// LocalAddr returns the client's address for the connection.
+func (c *Client) LocalAddr() string {
+	return c.wsc.LocalAddr().String()
+}
+
+// Close closes the connection without waiting for the server to respond.
+func (c *Client) Close() error {
+	return errors.Wrap(c.wsc.Close(), "couldn't close websocket")
+}
+
+// Sending
+
+// Send sends the command to the server.
+func (c *Client) Send(command Command) error {
+	marshaled, err := c.marshaler.Marshal(command)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't marshal %s command", command.Command)
+	}
+	return c.SendRaw(marshaled)
+}
+
+// SendRaw sends the already-marshaled data to the server, e.g. to test the handling of malformed
+// commands.
+func (c *Client) SendRaw(marshaled []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	return errors.Wrap(
+		c.wsc.WriteMessage(c.messageType, marshaled), "couldn't write websocket message",
+	)
+}
+
+// Subscribe sends a subscribe command for the identifier, without waiting for the server's
+// response.
+func (c *Client) Subscribe(identifier string) error {
+	return c.Send(Command{Command: CommandSubscribe, Identifier: identifier})
+}
+
+// Unsubscribe sends an unsubscribe command for the identifier.
+func (c *Client) Unsubscribe(identifier string) error {
+	return c.Send(Command{Command: CommandUnsubscribe, Identifier: identifier})
+}
+
+// Perform sends an action command for the identifier with the data, which is JSON-encoded as in
+// the Action Cable client in the browser.
+func (c *Client) Perform(identifier string, data any) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "couldn't encode action data")
+	}
+	return c.Send(Command{Command: CommandMessage, Identifier: identifier, Data: string(encoded)})
+}
+
+// SubscribeWithHistory sends a subscribe command for the identifier with a history request, for
+// the extended protocol, without waiting for the server's response.
+func (c *Client) SubscribeWithHistory(
+	identifier string, request actioncable.HistoryRequest,
+) error {
+	return c.Send(Command{Command: CommandSubscribe, Identifier: identifier, History: &request})
+}
+
+// History sends a history command for the identifier, for the extended protocol, without waiting
+// for the server's response.
+func (c *Client) History(identifier string, request actioncable.HistoryRequest) error {
+	return c.Send(Command{Command: CommandHistory, Identifier: identifier, History: &request})
+}
+
+// Join sends a join command for the identifier with the presence record, for the extended
+// protocol.
+func (c *Client) Join(identifier string, record actioncable.PresenceRecord) error {
+	return c.Send(Command{Command: CommandJoin, Identifier: identifier, Presence: &record})
+}
+
+// Leave sends a leave command for the identifier, for the extended protocol.
+func (c *Client) Leave(identifier string) error {
+	return c.Send(Command{Command: CommandLeave, Identifier: identifier})
+}
+
+// RequestPresence sends a presence command for the identifier, for the extended protocol, without
+// waiting for the server's response.
+func (c *Client) RequestPresence(identifier string) error {
+	return c.Send(Command{Command: CommandPresence, Identifier: identifier})
+}
+
+// Receiving
+
+// Next returns the next message from the server, including ping messages.
+func (c *Client) Next(ctx context.Context) (Message, error) {
+	select {
+	case <-ctx.Done():
+		return Message{}, errors.Wrap(ctx.Err(), "timed out waiting for server message")
+	case message, ok := <-c.received:
+		if !ok {
+			return Message{}, errors.Wrap(c.readErr, "connection closed while waiting for message")
+		}
+		return message, nil
+	}
+}
+
+// NextNonPing returns the next message from the server which is not a ping message.
+func (c *Client) NextNonPing(ctx context.Context) (Message, error) {
+	for {
+		message, err := c.Next(ctx)
+		if err != nil || message.Type != TypePing {
+			return message, err
+		}
+	}
+}
+
+// Expect returns the next message from the server which is not a ping message, and returns an error
+// if it doesn't have the type (or, if the identifier isn't empty, the subscription identifier).
+// Data messages are expected with an empty type.
+func (c *Client) Expect(ctx context.Context, typ, identifier string) (Message, error) {
+	message, err := c.NextNonPing(ctx)
+	if err != nil {
+		return Message{}, err
+	}
+	if message.Type != typ || (identifier != "" && message.Identifier != identifier) {
+		expected := Message{Type: typ, Identifier: identifier}
+		return message, errors.Errorf("expected %s but received %s", expected, message)
+	}
+	return message, nil
+}
+
+// ExpectWelcome waits for the welcome message which completes the Action Cable handshake.
+// With the extended protocol, the welcome message must have a session ID.
+func (c *Client) ExpectWelcome(ctx context.Context) error {
+	message, err := c.Expect(ctx, TypeWelcome, "")
+	if err != nil {
+		return err
+	}
+	if c.Extended() && message.SID == "" {
+		return errors.New("welcome message of extended protocol has no session ID")
+	}
+	c.sid = message.SID
+	return nil
+}
+
+// ExpectPing waits for a ping message, and checks that its timestamp is a recent Unix time.
+func (c *Client) ExpectPing(ctx context.Context) (time.Time, error) {
+	message, err := c.Next(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if message.Type != TypePing {
+		return time.Time{}, errors.Errorf("expected ping but received %s", message)
+	}
+	var timestamp int64
+	switch t := message.Message.(type) {
+	case string:
+		if timestamp, err = strconv.ParseInt(t, 10, 64); err != nil {
+			return time.Time{}, errors.Wrapf(err, "couldn't parse ping timestamp %s", t)
+		}
+	default:
+		return time.Time{}, errors.Errorf("ping timestamp has unexpected type %T", t)
+	}
+	pinged := time.Unix(timestamp, 0)
+	const maxSkew = time.Minute
+	if skew := time.Since(pinged); skew > maxSkew || skew < -maxSkew {
+		return time.Time{}, errors.Errorf("ping timestamp %s is not recent", pinged)
+	}
+	return pinged, nil
+}
+
+// SubscribeAndConfirm sends a subscribe command for the identifier and waits for the server to
+// confirm the subscription.
+func (c *Client) SubscribeAndConfirm(ctx context.Context, identifier string) error {
+	if err := c.Subscribe(identifier); err != nil {
+		return err
+	}
+	_, err := c.Expect(ctx, TypeConfirmSubscription, identifier)
+	return err
+}
+
+// ExpectData waits for a data message for the identifier, and returns its payload as a string.
+func (c *Client) ExpectData(ctx context.Context, identifier string) (string, error) {
+	message, err := c.Expect(ctx, "", identifier)
+	if err != nil {
+		return "", err
+	}
+	return message.Text()
+}
+
+// ExpectPositionedData waits for a data message for the identifier, and returns its payload as a
+// string, together with its position; it returns an error if the message has no position.
+func (c *Client) ExpectPositionedData(
+	ctx context.Context, identifier string,
+) (string, actioncable.StreamPosition, error) {
+	message, err := c.Expect(ctx, "", identifier)
+	if err != nil {
+		return "", actioncable.StreamPosition{}, err
+	}
+	if message.Epoch == "" || message.Offset == 0 {
+		return "", actioncable.StreamPosition{}, errors.Errorf(
+			"data message for %s has no stream position", identifier,
+		)
+	}
+	text, err := message.Text()
+	return text, message.Position(), err
+}
+
+// ExpectPresence waits for a presence message for the identifier, and returns its payload.
+func (c *Client) ExpectPresence(ctx context.Context, identifier string) (PresenceMessage, error) {
+	message, err := c.Expect(ctx, TypePresence, identifier)
+	if err != nil {
+		return PresenceMessage{}, err
+	}
+	return message.Presence()
+}
+
+// ExpectDisconnect waits for a disconnect message, and then for the server to close the
+// connection.
+func (c *Client) ExpectDisconnect(ctx context.Context) (Message, error) {
+	message, err := c.Expect(ctx, TypeDisconnect, "")
+	if err != nil {
+		return message, err
+	}
+	return message, c.ExpectClosed(ctx)
+}
+
+// ExpectClosed waits for the server to close the connection, discarding any ping messages; it
+// returns an error if any other message is received.
+func (c *Client) ExpectClosed(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "timed out waiting for connection to close")
+		case message, ok := <-c.received:
+			if !ok {
+				if websocket.IsCloseError(
+					c.readErr, websocket.CloseGoingAway, websocket.CloseNormalClosure,
+				) {
+					return nil
+				}
+				return errors.Wrap(c.readErr, "connection closed abnormally")
+			}
+			if message.Type != TypePing {
+				return errors.Errorf("expected connection to close but received %s", message)
+			}
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package actioncabletest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/sargassum-world/godest/actioncable"
+)
+
+// Conformance cases
+
+// ConformanceConfig describes the channels of the server under test, for the conformance cases.
+type ConformanceConfig struct {
+	// Identifier is a subscription identifier which the server should accept.
+	Identifier string
+	// RejectedIdentifier is a subscription identifier which the server should reject, e.g. because
+	// it fails a signature check by an [actioncable.Signer].
+	RejectedIdentifier string
+}
+
+// ConformanceCase is a check of one behavior of an Action Cable server, run with a new connection
+// for which the handshake was already completed.
+type ConformanceCase struct {
+	Name string
+	// Timeout limits the duration of the case; if it's zero, [DefaultConformanceTimeout] is used.
+	Timeout time.Duration
+	Run     func(ctx context.Context, s *Server, c *Client) error
+}
+
+// DefaultConformanceTimeout is the default time limit for each conformance case.
+const DefaultConformanceTimeout = 5 * time.Second
+
+// NewConformanceCases returns the conformance cases for the Action Cable protocol as implemented by
+// the actioncable package, for a server whose channels are described by the config.
+func NewConformanceCases(config ConformanceConfig) []ConformanceCase {
+	id, rejectedID := config.Identifier, config.RejectedIdentifier
+	return []ConformanceCase{
+		{
+			Name: "pings are sent periodically",
+			// Action Cable pings are sent every 3 seconds
+			Timeout: 2 * DefaultConformanceTimeout,
+			Run: func(ctx context.Context, s *Server, c *Client) error {
+				first, err := c.ExpectPing(ctx)
+				if err != nil {
+					return err
+				}
+				second, err := c.ExpectPing(ctx)
+				if err != nil {
+					return err
+				}
+				if second.Before(first) {
+					return errors.Errorf("ping timestamp %s is before previous %s", second, first)
+				}
+				return nil
+			},
+		},
+		{
+			Name: "subscription is confirmed",
+			Run: func(ctx context.Context, s *Server, c *Client) error {
+				return c.SubscribeAndConfirm(ctx, id)
+			},
+		},
+		{
+			Name: "repeated subscription is confirmed again",
+			Run: func(ctx context.Context, s *Server, c *Client) error {
+				if err := c.SubscribeAndConfirm(ctx, id); err != nil {
+					return err
+				}
+				return c.SubscribeAndConfirm(ctx, id)
+			},
+		},
+		{
+			Name: "resubscription after unsubscription is confirmed",
+			Run: func(ctx context.Context, s *Server, c *Client) error {
+				if err := c.SubscribeAndConfirm(ctx, id); err != nil {
+					return err
+				}
+				if err := c.Unsubscribe(id); err != nil {
+					return err
+				}
+				return c.SubscribeAndConfirm(ctx, id)
+			},
+		},
+		{
+			Name: "unsubscription without subscription is ignored",
+			Run: func(ctx context.Context, s *Server, c *Client) error {
+				if err := c.Unsubscribe(rejectedID); err != nil {
+					return err
+				}
+				return c.SubscribeAndConfirm(ctx, id)
+			},
+		},
+		{
+			Name: "rejected subscription is followed by disconnection",
+			Run: func(ctx context.Context, s *Server, c *Client) error {
+				if err := c.Subscribe(rejectedID); err != nil {
+					return err
+				}
+				if _, err := c.Expect(ctx, TypeRejectSubscription, rejectedID); err != nil {
+					return err
+				}
+				return expectDisconnect(ctx, c, false)
+			},
+		},
+		{
+			Name: "action without subscription causes disconnection",
+			Run: func(ctx context.Context, s *Server, c *Client) error {
+				if err := c.Perform(rejectedID, map[string]string{"action": "test"}); err != nil {
+					return err
+				}
+				return expectDisconnect(ctx, c, false)
+			},
+		},
+		{
+			Name: "unknown command causes disconnection",
+			Run: func(ctx context.Context, s *Server, c *Client) error {
+				if err := c.Send(Command{Command: "unknown", Identifier: id}); err != nil {
+					return err
+				}
+				return expectDisconnect(ctx, c, false)
+			},
+		},
+		{
+			Name: "malformed command causes disconnection",
+			Run: func(ctx context.Context, s *Server, c *Client) error {
+				if err := c.SendRaw([]byte("{")); err != nil {
+					return err
+				}
+				return expectDisconnect(ctx, c, false)
+			},
+		},
+		{
+			Name: "server disconnection sends reason and reconnect flag",
+			Run: func(ctx context.Context, s *Server, c *Client) error {
+				if err := c.SubscribeAndConfirm(ctx, id); err != nil {
+					return err
+				}
+				if s.Disconnect(c, actioncable.DisconnectReasonServerRestart, true) != 1 {
+					return errors.New("couldn't find server end of connection to disconnect")
+				}
+				message, err := c.ExpectDisconnect(ctx)
+				if err != nil {
+					return err
+				}
+				if message.Reason != actioncable.DisconnectReasonServerRestart || !message.Reconnect {
+					return errors.Errorf(
+						"expected disconnect with reason %s and reconnection allowed, but received %s",
+						actioncable.DisconnectReasonServerRestart, message,
+					)
+				}
+				return nil
+			},
+		},
+		{
+			Name: "extended commands require the extended protocol",
+			Run: func(ctx context.Context, s *Server, c *Client) error {
+				if err := c.History(rejectedID, actioncable.HistoryRequest{}); err != nil {
+					return err
+				}
+				if !c.Extended() {
+					return expectDisconnect(ctx, c, false)
+				}
+				// History for a subscription which doesn't exist is rejected without disconnection
+				if _, err := c.Expect(ctx, TypeRejectHistory, rejectedID); err != nil {
+					return err
+				}
+				return c.SubscribeAndConfirm(ctx, id)
+			},
+		},
+		{
+			Name: "history requested with subscription is answered after confirmation",
+			Run: func(ctx context.Context, s *Server, c *Client) error {
+				if err := c.SubscribeWithHistory(id, actioncable.HistoryRequest{
+					Since: time.Now().Unix(),
+				}); err != nil {
+					return err
+				}
+				if _, err := c.Expect(ctx, TypeConfirmSubscription, id); err != nil {
+					return err
+				}
+				if !c.Extended() {
+					// The history request is ignored
+					return nil
+				}
+				// Whether history is available depends on the server, so either answer is allowed
+				message, err := c.NextNonPing(ctx)
+				if err != nil {
+					return err
+				}
+				if (message.Type != TypeConfirmHistory && message.Type != TypeRejectHistory) ||
+					message.Identifier != id {
+					return errors.Errorf("expected history answer for %s but received %s", id, message)
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// expectDisconnect waits for a disconnect message with the reconnect flag, and for the server to
+// close the connection.
+func expectDisconnect(ctx context.Context, c *Client, reconnect bool) error {
+	message, err := c.ExpectDisconnect(ctx)
+	if err != nil {
+		return err
+	}
+	if message.Reconnect != reconnect {
+		return errors.Errorf("expected reconnect flag %t but received %s", reconnect, message)
+	}
+	return nil
+}
+
+// Conformance checking
+
+// ConformanceFailure is the failure of a conformance case with a subprotocol.
+type ConformanceFailure struct {
+	Case        string
+	Subprotocol string
+	Err         error
+}
+
+// ConformanceError lists the failures of conformance cases.
+type ConformanceError struct {
+	Failures []ConformanceFailure
+}
+
+func (e *ConformanceError) Error() string {
+	lines := make([]string, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		lines = append(lines, fmt.Sprintf(
+			"%s (%s): %s", failure.Case, failure.Subprotocol, failure.Err,
+		))
+	}
+	return fmt.Sprintf(
+		"%d conformance case(s) failed:\n%s", len(e.Failures), strings.Join(lines, "\n"),
+	)
+}
+
+// CheckConformance runs each conformance case against the server with each of the subprotocols
+// (or all subprotocols supported by the actioncable package, if none are specified), over a new
+// connection for each run. Cases are run concurrently. It returns a [*ConformanceError] listing all
+// failed runs, if any failed.
+func CheckConformance(
+	ctx context.Context, s *Server, cases []ConformanceCase, subprotocols ...string,
+) error {
+	if len(subprotocols) == 0 {
+		subprotocols = actioncable.SupportedSubprotocols()
+	}
+
+	failures := make(chan ConformanceFailure)
+	for _, subprotocol := range subprotocols {
+		for _, cc := range cases {
+			go func() {
+				failures <- ConformanceFailure{
+					Case:        cc.Name,
+					Subprotocol: subprotocol,
+					Err:         runConformanceCase(ctx, s, cc, subprotocol),
+				}
+			}()
+		}
+	}
+
+	var cerr ConformanceError
+	for range len(subprotocols) * len(cases) {
+		if failure := <-failures; failure.Err != nil {
+			cerr.Failures = append(cerr.Failures, failure)
+		}
+	}
+	if len(cerr.Failures) > 0 {
+		return &cerr
+	}
+	return nil
+}
+
+// runConformanceCase runs the conformance case over a new connection with the subprotocol.
+func runConformanceCase(
+	ctx context.Context, s *Server, cc ConformanceCase, subprotocol string,
+) error {
+	timeout := cc.Timeout
+	if timeout == 0 {
+		timeout = DefaultConformanceTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	c, err := Dial(ctx, s.WebSocketURL(), subprotocol)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	if err = c.ExpectWelcome(ctx); err != nil {
+		return errors.Wrap(err, "handshake failed")
+	}
+	return cc.Run(ctx, s, c)
+}
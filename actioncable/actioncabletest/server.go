@@ -0,0 +1,87 @@
+// Package actioncabletest provides utilities for end-to-end testing of Action Cable servers: a
+// [Server] which serves Action Cable connections over WebSockets from an httptest server, a
+// [Client] which speaks the actioncable-v1-json, actioncable-v1-msgpack, and
+// actioncable-v1-ext-json subprotocols, and a table-driven suite of protocol conformance checks
+// which can be run against the app's own channel factories.
+package actioncabletest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/sargassum-world/godest/actioncable"
+)
+
+// HandlerMaker creates an [actioncable.Handler] for a new Action Cable connection.
+type HandlerMaker func(r *http.Request) actioncable.Handler
+
+// NewChannelsHandlerMaker creates a [HandlerMaker] which creates an [actioncable.ChannelDispatcher]
+// with the channel factories and subscription identifier checkers for each connection.
+func NewChannelsHandlerMaker(
+	factories map[string]actioncable.ChannelFactory, checkers ...actioncable.IdentifierChecker,
+) HandlerMaker {
+	return func(r *http.Request) actioncable.Handler {
+		return actioncable.NewChannelDispatcher(
+			factories, make(map[string]actioncable.Channel), checkers...,
+		)
+	}
+}
+
+// Server is an httptest server which serves Action Cable connections over WebSockets. Every
+// connection is added to the Server's [actioncable.ConnRegistry] with the client's address as the
+// session ID, so that tests can disconnect connections from the server.
+type Server struct {
+	*httptest.Server
+	registry *actioncable.ConnRegistry
+}
+
+// NewServer starts and returns a new Server which creates a handler for each connection with the
+// handler maker. The Server should be closed when the test finishes.
+func NewServer(newHandler HandlerMaker, opts ...actioncable.ConnOption) *Server {
+	s := &Server{
+		registry: actioncable.NewConnRegistry(),
+	}
+	upgrader := websocket.Upgrader{
+		Subprotocols: actioncable.SupportedSubprotocols(),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wsc, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader already responded with an error
+			return
+		}
+		conn, err := actioncable.Upgrade(
+			wsc, newHandler(r), append(slices.Clip(opts), actioncable.WithConnRegistry(
+				s.registry, actioncable.ConnIdentity{SessionID: r.RemoteAddr},
+			))...,
+		)
+		if err != nil {
+			_ = wsc.Close()
+			return
+		}
+		// The error is sent to the client in the disconnect message, so tests can check it there
+		_ = conn.Close(conn.Serve(r.Context()))
+	}))
+	return s
+}
+
+// WebSocketURL returns the URL for opening WebSocket connections to the Server.
+func (s *Server) WebSocketURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+// Disconnect disconnects the server's end of the client's connection, with the Action Cable
+// disconnect reason and reconnect flag. It returns the number of connections disconnected, which is
+// zero if the server's end is no longer served.
+func (s *Server) Disconnect(c *Client, reason string, allowReconnect bool) int {
+	return s.registry.DisconnectSession(c.LocalAddr(), reason, allowReconnect)
+}
+
+// Registry returns the registry of the Server's connections.
+func (s *Server) Registry() *actioncable.ConnRegistry {
+	return s.registry
+}
@@ -15,6 +15,15 @@ type Channel interface {
 	Perform(data string) error
 }
 
+// HistoryChannel is a Channel which can send the messages which a subscriber missed on the
+// channel's streams, for the extended protocol.
+type HistoryChannel interface {
+	Channel
+	// History sends the messages requested by the client (e.g. in an Action Cable history command)
+	// over the [Subscription]. It returns an error if the channel can't send all of those messages.
+	History(ctx context.Context, sub *Subscription, request HistoryRequest) error
+}
+
 // ChannelFactory creates a Channel from an Action Cable subscription identifier.
 type ChannelFactory func(identifier string) (Channel, error)
 
@@ -97,3 +106,18 @@ func (d *ChannelDispatcher) HandleAction(ctx context.Context, identifier, data s
 	}
 	return channel.Perform(data)
 }
+
+// HandleHistory dispatches Action Cable history requests to the appropriate channels.
+func (d *ChannelDispatcher) HandleHistory(
+	ctx context.Context, sub *Subscription, request HistoryRequest,
+) error {
+	channel, ok := d.channels[sub.Identifier()]
+	if !ok {
+		return errors.Errorf("no preexisting subscription on %s", sub.Identifier())
+	}
+	hc, ok := channel.(HistoryChannel)
+	if !ok {
+		return errors.Errorf("channel for %s doesn't provide history", sub.Identifier())
+	}
+	return hc.History(ctx, sub, request)
+}
@@ -0,0 +1,46 @@
+package actioncable_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sargassum-world/godest/actioncable"
+	"github.com/sargassum-world/godest/actioncable/actioncabletest"
+)
+
+// echoChannel is a trivial channel which accepts every subscription and ignores every action.
+type echoChannel struct{}
+
+func (echoChannel) Subscribe(ctx context.Context, sub *actioncable.Subscription) error {
+	return nil
+}
+
+func (echoChannel) Perform(data string) error {
+	return nil
+}
+
+func TestConformance(t *testing.T) {
+	s := actioncabletest.NewServer(actioncabletest.NewChannelsHandlerMaker(
+		map[string]actioncable.ChannelFactory{
+			"EchoChannel": func(identifier string) (actioncable.Channel, error) {
+				return echoChannel{}, nil
+			},
+		},
+	))
+	t.Cleanup(s.Close)
+
+	cases := actioncabletest.NewConformanceCases(actioncabletest.ConformanceConfig{
+		Identifier:         `{"channel":"EchoChannel"}`,
+		RejectedIdentifier: `{"channel":"UnknownChannel"}`,
+	})
+	for _, subprotocol := range actioncable.SupportedSubprotocols() {
+		t.Run(subprotocol, func(t *testing.T) {
+			t.Parallel()
+			if err := actioncabletest.CheckConformance(
+				context.Background(), s, cases, subprotocol,
+			); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -4,7 +4,10 @@ package actioncable
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -19,10 +22,16 @@ import (
 const (
 	ActionCableV1JSONSubprotocol    = "actioncable-v1-json"
 	ActionCableV1MsgpackSubprotocol = "actioncable-v1-msgpack"
+	// ActionCableV1ExtJSONSubprotocol is the subprotocol for the extended protocol of AnyCable
+	// (https://docs.anycable.io/misc/action_cable_protocol?id=action-cable-extended-protocol),
+	// which adds stream positions for data messages, history commands for clients to receive the
+	// messages they missed (e.g. while reconnecting), and presence commands.
+	ActionCableV1ExtJSONSubprotocol = "actioncable-v1-ext-json"
 )
 
 func SupportedSubprotocols() []string {
 	return []string{
+		ActionCableV1ExtJSONSubprotocol,
 		ActionCableV1JSONSubprotocol,
 		ActionCableV1MsgpackSubprotocol,
 	}
@@ -56,18 +65,38 @@ type Handler interface {
 	HandleAction(ctx context.Context, identifier, data string) error
 }
 
+// HistoryHandler is a Handler which also handles Action Cable history requests of the extended
+// protocol, for subscriptions which it previously handled. Errors are reported to the client with
+// a reject_history message, without closing the connection.
+type HistoryHandler interface {
+	Handler
+	HandleHistory(ctx context.Context, sub *Subscription, request HistoryRequest) error
+}
+
 // Conn
 
 // Conn represents a server-side Action Cable connection.
 type Conn struct {
+	id              string
 	wsc             *websocket.Conn
 	toClient        chan serverMessage
 	h               Handler
-	unsubscribers   map[string]func()
 	sanitizeError   ErrorSanitizer
 	subprotocol     string
 	marshaledWSType int
 	marshaler       marshaling.Marshaler
+	ext             bool
+	identity        ConnIdentity
+	registry        *ConnRegistry
+	presence        *Presence
+
+	// mu protects the fields below, which can be accessed from outside the Serve method
+	mu            sync.Mutex
+	unsubscribers map[string]func()
+	subs          map[string]*Subscription
+	cancelServe   context.CancelCauseFunc
+	disconnecting *DisconnectError
+	done          chan struct{}
 }
 
 // ConnOption modifies a [Conn]. For use with [Upgrade].
@@ -100,6 +129,7 @@ func Upgrade(wsc *websocket.Conn, handler Handler, opts ...ConnOption) (conn *Co
 	subprotocol := wsc.Subprotocol()
 	var marshaler marshaling.Marshaler
 	var messageType int
+	ext := false
 
 	switch subprotocol {
 	default:
@@ -114,17 +144,25 @@ func Upgrade(wsc *websocket.Conn, handler Handler, opts ...ConnOption) (conn *Co
 	case ActionCableV1MsgpackSubprotocol:
 		messageType = websocket.BinaryMessage
 		marshaler = marshaling.MessagePack{}
+	case ActionCableV1ExtJSONSubprotocol:
+		messageType = websocket.TextMessage
+		marshaler = marshaling.JSON{}
+		ext = true
 	}
 	// TODO: check wsc for its subprotocol so we can handle different encodings
 	conn = &Conn{
+		id:              rand.Text(),
 		wsc:             wsc,
 		toClient:        make(chan serverMessage),
 		h:               handler,
 		sanitizeError:   defaultErrorSanitizer,
 		unsubscribers:   make(map[string]func()),
+		subs:            make(map[string]*Subscription),
 		subprotocol:     subprotocol,
 		marshaledWSType: messageType,
 		marshaler:       marshaler,
+		ext:             ext,
+		done:            make(chan struct{}),
 	}
 	for _, opt := range opts {
 		opt(conn)
@@ -132,33 +170,121 @@ func Upgrade(wsc *websocket.Conn, handler Handler, opts ...ConnOption) (conn *Co
 	return conn, nil
 }
 
+// ID returns the randomly-generated ID of the Conn, which is sent to the client as the session ID
+// in the welcome message of the extended protocol.
+func (c *Conn) ID() string {
+	return c.id
+}
+
+// Extended returns whether the Conn uses the extended protocol.
+func (c *Conn) Extended() bool {
+	return c.ext
+}
+
+// Identity returns the identity with which the Conn was added to a [ConnRegistry], if any.
+func (c *Conn) Identity() ConnIdentity {
+	return c.identity
+}
+
+// Subscriptions returns the sorted identifiers of the Conn's current subscriptions.
+func (c *Conn) Subscriptions() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	identifiers := make([]string, 0, len(c.unsubscribers))
+	for identifier := range c.unsubscribers {
+		identifiers = append(identifiers, identifier)
+	}
+	slices.Sort(identifiers)
+	return identifiers
+}
+
+// hasSubscription checks whether the Conn has a subscription with the identifier.
+func (c *Conn) hasSubscription(identifier string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.unsubscribers[identifier]
+	return ok
+}
+
 // Closing
 
+// Action Cable disconnect reasons, as used by Rails.
+const (
+	DisconnectReasonUnauthorized   = "unauthorized"
+	DisconnectReasonInvalidRequest = "invalid_request"
+	DisconnectReasonServerRestart  = "server_restart"
+	DisconnectReasonRemote         = "remote"
+)
+
+// DisconnectError is returned by the Serve method of a Conn which was disconnected by the server
+// with the Conn's Disconnect method. When it's passed to the Conn's Close method, the reason and
+// reconnect flag are sent to the client in the Action Cable disconnect message.
+type DisconnectError struct {
+	Reason    string
+	Reconnect bool
+}
+
+func (e *DisconnectError) Error() string {
+	return fmt.Sprintf("server disconnected connection (reason: %s)", e.Reason)
+}
+
+// Disconnect stops the Conn's Serve method (or makes it return immediately, if it hasn't started
+// yet), which then returns a [DisconnectError] with the reason and reconnect flag, e.g. so that the
+// connections of a user who logged out can be closed by other goroutines. The Conn should then be
+// closed with that error, as usual.
+func (c *Conn) Disconnect(reason string, allowReconnect bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.disconnecting != nil {
+		return
+	}
+	c.disconnecting = &DisconnectError{Reason: reason, Reconnect: allowReconnect}
+	if c.cancelServe != nil {
+		c.cancelServe(c.disconnecting)
+	}
+}
+
 // disconnect cancels all subscriptions and sends an Action Cable disconnect message.
-func (c *Conn) disconnect(serr error, allowReconnect bool) {
-	// Because c.unsubscribers isn't protected by a mutex, the Close method should only be called
-	// after the Serve method has completed.
-	for _, unsubscriber := range c.unsubscribers {
+func (c *Conn) disconnect(reason string, allowReconnect bool) {
+	c.mu.Lock()
+	identifiers := make([]string, 0, len(c.unsubscribers))
+	for identifier, unsubscriber := range c.unsubscribers {
 		unsubscriber()
+		identifiers = append(identifiers, identifier)
 	}
 	c.unsubscribers = make(map[string]func())
+	c.subs = make(map[string]*Subscription)
+	c.mu.Unlock()
+	if c.presence != nil {
+		c.presence.unwatchAll(context.Background(), c, identifiers)
+	}
 	// We leave the toClient channel open because Subscriptions can send into it, and the sendAll
 	// method doesn't need to detect whether toClient is closed; Subscriptions can just detect that
 	// the connection is done if there's no receiver on the channel.
 
 	// We send close messages only as a courtesy; they may fail if the client already closed the
 	// websocket connection by going away, so we don't care about such errors; we need to call the
-	// websocket's Close method regardless.
-	_ = c.writeAsMarshaled(newDisconnect(c.sanitizeError(serr), allowReconnect))
+	// websocket's Close method regardless. Because the websocket connection doesn't support
+	// concurrent writers, the Close method should only be called after the Serve method has
+	// completed.
+	_ = c.writeAsMarshaled(newDisconnect(reason, allowReconnect))
 }
 
 // Close cancels all subscriptions, sends an Action Cable disconnect message and WebSocket close
 // control message (which can be interrupted by canceling the provided context), and closes the
-// WebSocket connection. The Conn should not be used after being closed.
+// WebSocket connection. If the error is a [DisconnectError], its reason and reconnect flag are sent
+// in the disconnect message; otherwise, the reason is the error as sanitized by the Conn's error
+// sanitizer, and reconnection is not allowed. The Conn should not be used after being closed.
 func (c *Conn) Close(err error) error {
-	// TODO: allow reconnection if we're closing because the server is going down
 	// TODO: test whether Close behaves correctly when we're shutting down the HTTP server
-	c.disconnect(err, false)
+	if de := (*DisconnectError)(nil); errors.As(err, &de) {
+		c.disconnect(de.Reason, de.Reconnect)
+	} else {
+		c.disconnect(c.sanitizeError(err), false)
+	}
 	// We send close messages only as a courtesy; they may fail if the client already closed the
 	// websocket connection by going away, so we don't care about such errors; we need to call the
 	// websocket's Close method regardless.
@@ -168,7 +294,8 @@ func (c *Conn) Close(err error) error {
 		time.Now().Add(wsWriteWait),
 	)
 
-	close(c.toClient)
+	// The toClient channel is left open, since other goroutines (e.g. broadcasts through a
+	// ConnRegistry) may still try to send into it; they detect that the Conn is done instead.
 	return errors.Wrap(c.wsc.Close(), "couldn't close websocket")
 }
 
@@ -177,27 +304,116 @@ func (c *Conn) Close(err error) error {
 // wsPongWait is the WebSocket connection read timeout duration.
 const wsPongWait = 60 * time.Second
 
-// subscribe processes an Action Cable subscribe command.
-func (c *Conn) subscribe(ctx context.Context, identifier string) error {
-	if _, ok := c.unsubscribers[identifier]; ok {
+// subscribe processes an Action Cable subscribe command. For the extended protocol, the command
+// may also carry a history request, which is processed after the subscription is confirmed.
+func (c *Conn) subscribe(ctx context.Context, identifier string, history *HistoryRequest) error {
+	if c.hasSubscription(identifier) {
 		// the subscriber already has a subscription, so just confirm it again.
 		c.toClient <- newSubscriptionConfirmation(identifier)
+		if c.ext && history != nil {
+			return c.history(ctx, identifier, *history)
+		}
 		return nil
 	}
 
 	cctx, cancel := context.WithCancel(ctx)
-	if err := c.h.HandleSubscription(
-		cctx, &Subscription{
-			identifier: identifier,
-			toClient:   c.toClient,
-		},
-	); err != nil {
+	sub := &Subscription{
+		identifier: identifier,
+		toClient:   c.toClient,
+		ext:        c.ext,
+	}
+	if err := c.h.HandleSubscription(cctx, sub); err != nil {
 		c.toClient <- newSubscriptionRejection(identifier)
 		cancel()
 		return errors.Wrap(err, "subscribe command handler encountered error")
 	}
+	c.mu.Lock()
 	c.unsubscribers[identifier] = cancel
+	c.subs[identifier] = sub
+	c.mu.Unlock()
+	if c.ext && c.presence != nil {
+		c.presence.watch(c, identifier)
+	}
 	c.toClient <- newSubscriptionConfirmation(identifier)
+	if c.ext && history != nil {
+		return c.history(ctx, identifier, *history)
+	}
+	return nil
+}
+
+// unsubscribe processes an Action Cable unsubscribe command.
+func (c *Conn) unsubscribe(ctx context.Context, identifier string) {
+	c.mu.Lock()
+	unsubscriber, ok := c.unsubscribers[identifier]
+	delete(c.unsubscribers, identifier)
+	delete(c.subs, identifier)
+	c.mu.Unlock()
+	if !ok || unsubscriber == nil {
+		return
+	}
+	if c.presence != nil {
+		c.presence.unwatch(ctx, c, identifier)
+	}
+	unsubscriber()
+}
+
+// history processes an Action Cable history request of the extended protocol. Because history may
+// be unavailable under ordinary conditions (e.g. if the client was disconnected for too long), a
+// failed history request is only reported to the client with a reject_history message.
+func (c *Conn) history(ctx context.Context, identifier string, request HistoryRequest) error {
+	c.mu.Lock()
+	sub, ok := c.subs[identifier]
+	c.mu.Unlock()
+	hh, isHistoryHandler := c.h.(HistoryHandler)
+	if !ok || !isHistoryHandler {
+		return c.send(ctx, newHistoryRejection(identifier))
+	}
+	if err := hh.HandleHistory(ctx, sub, request); err != nil {
+		return c.send(ctx, newHistoryRejection(identifier))
+	}
+	return c.send(ctx, newHistoryConfirmation(identifier))
+}
+
+// receivePresence processes an Action Cable join, leave, or presence command of the extended
+// protocol. Failed commands are only reported to the client with a presence error message.
+func (c *Conn) receivePresence(ctx context.Context, command clientMessage) error {
+	if c.presence == nil || !c.hasSubscription(command.Identifier) {
+		return c.send(ctx, newPresenceError(command.Identifier))
+	}
+	switch command.Command {
+	case joinCommand:
+		if command.Presence == nil {
+			return c.send(ctx, newPresenceError(command.Identifier))
+		}
+		if err := c.presence.join(ctx, c, command.Identifier, *command.Presence); err != nil {
+			return c.send(ctx, newPresenceError(command.Identifier))
+		}
+	case leaveCommand:
+		c.presence.leave(ctx, c, command.Identifier)
+	case presenceCommand:
+		return c.send(
+			ctx, newPresenceInfo(command.Identifier, c.presence.Records(command.Identifier)),
+		)
+	}
+	return nil
+}
+
+// receiveExtended processes an Action Cable command of the extended protocol.
+func (c *Conn) receiveExtended(ctx context.Context, command clientMessage) error {
+	if !c.ext {
+		return errors.Errorf("command %s requires the extended protocol", command.Command)
+	}
+	switch command.Command {
+	case historyCommand:
+		if command.History == nil {
+			return c.send(ctx, newHistoryRejection(command.Identifier))
+		}
+		return c.history(ctx, command.Identifier, *command.History)
+	case joinCommand, leaveCommand, presenceCommand:
+		return c.receivePresence(ctx, command)
+	case pongCommand:
+		// The client only acknowledges a ping, which needs no response
+	}
 	return nil
 }
 
@@ -207,18 +423,15 @@ func (c *Conn) receive(ctx context.Context, command clientMessage) error {
 	default:
 		return errors.Errorf("unknown command %s", command.Command)
 	case subscribeCommand:
-		return c.subscribe(ctx, command.Identifier)
+		return c.subscribe(ctx, command.Identifier, command.History)
 	case unsubscribeCommand:
-		unsubscriber, ok := c.unsubscribers[command.Identifier]
-		if !ok || unsubscriber == nil {
-			return nil
-		}
-		unsubscriber()
-		delete(c.unsubscribers, command.Identifier)
+		c.unsubscribe(ctx, command.Identifier)
 	case actionCommand:
 		if err := c.h.HandleAction(ctx, command.Identifier, command.Data); err != nil {
 			return errors.Wrap(err, "action command handler encountered error")
 		}
+	case historyCommand, joinCommand, leaveCommand, presenceCommand, pongCommand:
+		return c.receiveExtended(ctx, command)
 	}
 	return nil
 }
@@ -265,7 +478,10 @@ func (c *Conn) receiveAll(ctx context.Context) (err error) {
 			// We wait for received to be closed to avoid a data race where the goroutine for ReadJSON
 			// would set err after this select case has already returned an error. We ignore any error
 			// from ReadJSON (e.g. broken pipe resulting from browser tab closure, which also cancels the
-			// context) because we don't care about reading data after the context is canceled.
+			// context) because we don't care about reading data after the context is canceled. We
+			// expire the read deadline so that we don't have to wait for the client to send a message
+			// (e.g. when the server disconnects the Conn).
+			_ = c.wsc.SetReadDeadline(time.Now())
 			<-received
 			return ctx.Err()
 		case <-received:
@@ -329,7 +545,11 @@ func (c *Conn) sendAll(ctx context.Context) (err error) {
 	if err = c.resetWriteDeadline(); err != nil {
 		return err
 	}
-	if err = c.writeAsMarshaled(newWelcome()); err != nil {
+	welcome := newWelcome("")
+	if c.ext {
+		welcome = newWelcome(c.id)
+	}
+	if err = c.writeAsMarshaled(welcome); err != nil {
 		return errors.Wrap(err, "couldn't send welcome message for Action Cable handshake")
 	}
 
@@ -356,10 +576,9 @@ func (c *Conn) sendAll(ctx context.Context) (err error) {
 				return filterNormalClose(err, errors.Wrap(err, "couldn't send Action Cable ping"))
 			}
 		case message := <-c.toClient:
-			if err = ctx.Err(); err != nil {
-				// Context was also canceled, it should have priority
-				return err
-			}
+			// We write the message even if the context was also canceled, because the message was
+			// already dequeued and may need to precede the disconnect message (e.g. a subscription
+			// rejection whose error is what canceled the context)
 			if err = c.writeAsMarshaled(message); err != nil {
 				return filterNormalClose(err, errors.Wrap(
 					err, "couldn't send Action Cable server message for client",
@@ -371,16 +590,38 @@ func (c *Conn) sendAll(ctx context.Context) (err error) {
 
 // Serving
 
-// Serve processes all WebSocket ping/pong messages and Action Cable messages.
+// Serve processes all WebSocket ping/pong messages and Action Cable messages. If the Conn was
+// added to a [ConnRegistry], it's registered for as long as Serve is running.
 func (c *Conn) Serve(ctx context.Context) (err error) {
-	eg, egctx := errgroup.WithContext(ctx)
+	sctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	c.mu.Lock()
+	c.cancelServe = cancel
+	if c.disconnecting != nil {
+		cancel(c.disconnecting)
+	}
+	c.mu.Unlock()
+	defer close(c.done)
+	if c.registry != nil {
+		// The Conn is removed from the registry before it's marked as done, so that lookups don't
+		// return Conns which are already done; Conns looked up earlier detect that they're done when
+		// sending
+		c.registry.add(c)
+		defer c.registry.remove(c)
+	}
+
+	eg, egctx := errgroup.WithContext(sctx)
 	eg.Go(func() error {
 		return c.receiveAll(egctx)
 	})
 	eg.Go(func() error {
 		return c.sendAll(egctx)
 	})
-	if err = eg.Wait(); err != nil {
+	err = eg.Wait()
+	if de := (*DisconnectError)(nil); errors.As(context.Cause(sctx), &de) {
+		return de
+	}
+	if err != nil {
 		if isNormalClose(err) {
 			return nil
 		}
@@ -388,3 +629,16 @@ func (c *Conn) Serve(ctx context.Context) (err error) {
 	}
 	return nil
 }
+
+// send enqueues the message for sending to the client, blocking until the message is added to the
+// queue or the Conn is done serving.
+func (c *Conn) send(ctx context.Context, message serverMessage) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.done:
+		return errors.New("connection is no longer served")
+	case c.toClient <- message:
+		return nil
+	}
+}
@@ -14,11 +14,41 @@ const (
 	actionCommand      = "message"
 )
 
+// Client-to-server commands of the extended protocol
+// (https://docs.anycable.io/misc/action_cable_protocol?id=action-cable-extended-protocol).
+const (
+	historyCommand  = "history"
+	joinCommand     = "join"
+	leaveCommand    = "leave"
+	presenceCommand = "presence"
+	pongCommand     = "pong"
+)
+
 // clientMessage represents generic client-to-server messages.
 type clientMessage struct {
-	Command    string `json:"command"`
-	Identifier string `json:"identifier"`
-	Data       string `json:"data,omitempty"`
+	Command    string          `json:"command"`
+	Identifier string          `json:"identifier"`
+	Data       string          `json:"data,omitempty"`
+	History    *HistoryRequest `json:"history,omitempty"`
+	Presence   *PresenceRecord `json:"presence,omitempty"`
+}
+
+// StreamPosition identifies the position of a message in the history of a stream, for the
+// extended protocol. Offsets are only comparable between positions with the same epoch.
+type StreamPosition struct {
+	Epoch  string `json:"epoch"`
+	Offset uint64 `json:"offset"`
+}
+
+// HistoryRequest represents the request of a client (in a history command, or in a subscribe
+// command) for the messages it missed on the streams of a subscription, for the extended protocol.
+type HistoryRequest struct {
+	// Since is the Unix timestamp (in seconds) after which the client missed messages, for streams
+	// without a position in Streams.
+	Since int64 `json:"since,omitempty"`
+	// Streams are the positions of the last messages the client received on the streams, by stream
+	// ID.
+	Streams map[string]StreamPosition `json:"streams,omitempty"`
 }
 
 // Server Messages
@@ -28,12 +58,20 @@ type serverMessage struct {
 	Type       string `json:"type,omitempty"`
 	Identifier string `json:"identifier,omitempty"`
 	Message    any    `json:"message,omitempty"`
+	// SID is the session ID sent in welcome messages of the extended protocol.
+	SID string `json:"sid,omitempty"`
+	// StreamID, Epoch, and Offset identify the stream and position of a data message, for the
+	// extended protocol.
+	StreamID string `json:"stream_id,omitempty"`
+	Epoch    string `json:"epoch,omitempty"`
+	Offset   uint64 `json:"offset,omitempty"`
 }
 
-// newWelcome creates an Action Cable welcome message.
-func newWelcome() serverMessage {
+// newWelcome creates an Action Cable welcome message, with the session ID if it's not empty.
+func newWelcome(sid string) serverMessage {
 	return serverMessage{
 		Type: "welcome",
+		SID:  sid,
 	}
 }
 
@@ -73,6 +111,87 @@ func newData[Payload DataPayload](identifier string, message Payload) serverMess
 	}
 }
 
+// newPositionedData creates an Action Cable data message with the stream ID and position of the
+// message, for the extended protocol.
+func newPositionedData[Payload DataPayload](
+	identifier string, message Payload, streamID string, position StreamPosition,
+) serverMessage {
+	return serverMessage{
+		Identifier: identifier,
+		Message:    message,
+		StreamID:   streamID,
+		Epoch:      position.Epoch,
+		Offset:     position.Offset,
+	}
+}
+
+// newHistoryConfirmation creates an Action Cable confirm_history message.
+func newHistoryConfirmation(identifier string) serverMessage {
+	return serverMessage{
+		Type:       "confirm_history",
+		Identifier: identifier,
+	}
+}
+
+// newHistoryRejection creates an Action Cable reject_history message.
+func newHistoryRejection(identifier string) serverMessage {
+	return serverMessage{
+		Type:       "reject_history",
+		Identifier: identifier,
+	}
+}
+
+// Presence event types.
+const (
+	presenceJoinType  = "join"
+	presenceLeaveType = "leave"
+	presenceInfoType  = "info"
+	presenceErrorType = "error"
+)
+
+// presenceEvent represents the message of a presence message about a presence record which joined
+// or left a subscription's stream, or about a failed presence command.
+type presenceEvent struct {
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+	Info any    `json:"info,omitempty"`
+}
+
+// presenceInfo represents the message of a presence message with all presence records of a
+// subscription's stream.
+type presenceInfo struct {
+	Type    string           `json:"type"`
+	Total   int              `json:"total"`
+	Records []PresenceRecord `json:"records"`
+}
+
+// newPresenceEvent creates an Action Cable presence message for the record joining or leaving.
+func newPresenceEvent(identifier, eventType string, record PresenceRecord) serverMessage {
+	return serverMessage{
+		Type:       "presence",
+		Identifier: identifier,
+		Message:    presenceEvent{Type: eventType, ID: record.ID, Info: record.Info},
+	}
+}
+
+// newPresenceInfo creates an Action Cable presence message with the records.
+func newPresenceInfo(identifier string, records []PresenceRecord) serverMessage {
+	return serverMessage{
+		Type:       "presence",
+		Identifier: identifier,
+		Message:    presenceInfo{Type: presenceInfoType, Total: len(records), Records: records},
+	}
+}
+
+// newPresenceError creates an Action Cable presence message for a failed presence command.
+func newPresenceError(identifier string) serverMessage {
+	return serverMessage{
+		Type:       "presence",
+		Identifier: identifier,
+		Message:    presenceEvent{Type: presenceErrorType},
+	}
+}
+
 // disconnectMessage represents a server-to-client disconnect message.
 type disconnectMessage struct {
 	Type      string `json:"type"`
@@ -0,0 +1,273 @@
+package actioncable
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PresenceRecord represents a client which joined the presence set of a subscription's stream, for
+// the extended protocol.
+type PresenceRecord struct {
+	// ID identifies the client in the presence set (e.g. the ID of the user), so that multiple
+	// connections of the same client (e.g. in multiple browser tabs) are represented by one record.
+	ID string `json:"id"`
+	// Info is arbitrary public information about the client (e.g. the user's display name).
+	Info any `json:"info,omitempty"`
+}
+
+// PresenceChecker returns an error if the client of a Conn with the identity may not join the
+// presence set of the subscription's stream with the record, e.g. because the record's ID isn't the
+// ID of the user identified by the Conn.
+type PresenceChecker func(identity ConnIdentity, identifier string, record PresenceRecord) error
+
+// Presence keeps track of which clients are present on each Action Cable subscription identifier,
+// for Conns using the extended protocol: clients join and leave the presence set of a subscription
+// with join and leave commands, and all Conns subscribed with the identifier are sent presence
+// messages when a record joins or leaves. A record leaves when its last Conn leaves, unsubscribes,
+// or is closed. Presence sets are kept in memory, so they only include the Conns of the process.
+// Presence messages are sent on a best-effort basis: a Conn which doesn't accept a presence message
+// within a time limit (e.g. because its client is too slow) misses it, and concurrent joins and
+// leaves may reach different Conns in different orders; clients can send a presence command to
+// get the current presence set.
+type Presence struct {
+	checkers []PresenceChecker
+
+	// mu protects the presence sets
+	mu      sync.Mutex
+	streams map[string]*presenceStream
+}
+
+// presenceSendTimeout limits how long each Conn can block the sending of a presence message.
+const presenceSendTimeout = 5 * time.Second
+
+// presenceNotification is a presence message for the Conns which were watching a presence set
+// when the message was created.
+type presenceNotification struct {
+	watchers []*Conn
+	message  serverMessage
+}
+
+// presenceStream is the presence set of a subscription identifier.
+type presenceStream struct {
+	// watchers are the Conns subscribed with the identifier, which receive presence messages
+	watchers map[*Conn]struct{}
+	// members are the records in the presence set, by ID
+	members map[string]*presenceMember
+	// joined are the IDs of the records joined by Conns
+	joined map[*Conn]string
+}
+
+// presenceMember is a record in a presence set, with the Conns which joined with the record.
+type presenceMember struct {
+	info  any
+	conns map[*Conn]struct{}
+}
+
+// NewPresence creates a new instance of Presence, which checks records joined by clients with the
+// specified checkers.
+func NewPresence(checkers ...PresenceChecker) *Presence {
+	return &Presence{
+		checkers: checkers,
+		streams:  make(map[string]*presenceStream),
+	}
+}
+
+// WithPresence creates a [ConnOption] to keep track of the Conn's presence in the presence sets of
+// its subscriptions, if the Conn uses the extended protocol.
+func WithPresence(p *Presence) ConnOption {
+	return func(c *Conn) {
+		c.presence = p
+	}
+}
+
+// Records returns the records in the presence set of the subscription identifier, sorted by ID.
+func (p *Presence) Records(identifier string) []PresenceRecord {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ps, ok := p.streams[identifier]
+	if !ok {
+		return []PresenceRecord{}
+	}
+	records := make([]PresenceRecord, 0, len(ps.members))
+	for id, member := range ps.members {
+		records = append(records, PresenceRecord{ID: id, Info: member.info})
+	}
+	slices.SortFunc(records, func(a, b PresenceRecord) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return records
+}
+
+// newNotification creates a presence message for all Conns currently subscribed with the
+// identifier. The mutex must be held.
+func newNotification(ps *presenceStream, message serverMessage) presenceNotification {
+	watchers := make([]*Conn, 0, len(ps.watchers))
+	for c := range ps.watchers {
+		watchers = append(watchers, c)
+	}
+	return presenceNotification{watchers: watchers, message: message}
+}
+
+// notify enqueues the messages, in order, for sending to their Conns. The mutex must not be held,
+// so that a slow Conn doesn't block other presence commands; instead, each Conn is given a limited
+// time to accept each message.
+func notify(ctx context.Context, notifications ...presenceNotification) {
+	ctx, cancel := context.WithTimeout(ctx, presenceSendTimeout)
+	defer cancel()
+
+	for _, n := range notifications {
+		wg := sync.WaitGroup{}
+		for _, c := range n.watchers {
+			wg.Add(1)
+			go func(c *Conn) {
+				defer wg.Done()
+				// If the Conn is no longer served, it doesn't need the message; and if the context was
+				// canceled or timed out, we can't send it anymore
+				_ = c.send(ctx, n.message)
+			}(c)
+		}
+		wg.Wait()
+	}
+}
+
+// watch adds the Conn to the watchers of the subscription identifier's presence set.
+func (p *Presence) watch(c *Conn, identifier string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ps, ok := p.streams[identifier]
+	if !ok {
+		ps = &presenceStream{
+			watchers: make(map[*Conn]struct{}),
+			members:  make(map[string]*presenceMember),
+			joined:   make(map[*Conn]string),
+		}
+		p.streams[identifier] = ps
+	}
+	ps.watchers[c] = struct{}{}
+}
+
+// unwatch makes the Conn leave the presence set of the subscription identifier (if it joined the
+// presence set), and removes the Conn from the presence set's watchers.
+func (p *Presence) unwatch(ctx context.Context, c *Conn, identifier string) {
+	p.mu.Lock()
+	ps, ok := p.streams[identifier]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+	delete(ps.watchers, c)
+	notifications := p.leaveLocked(ps, c, identifier)
+	if len(ps.watchers) == 0 && len(ps.members) == 0 {
+		delete(p.streams, identifier)
+	}
+	p.mu.Unlock()
+
+	notify(ctx, notifications...)
+}
+
+// unwatchAll makes the Conn leave the presence sets of all subscription identifiers.
+func (p *Presence) unwatchAll(ctx context.Context, c *Conn, identifiers []string) {
+	for _, identifier := range identifiers {
+		p.unwatch(ctx, c, identifier)
+	}
+}
+
+// join adds the Conn to the presence set of the subscription identifier with the record. If the
+// record's ID has no other Conns in the presence set, a join presence message is sent to all Conns
+// subscribed with the identifier.
+func (p *Presence) join(
+	ctx context.Context, c *Conn, identifier string, record PresenceRecord,
+) error {
+	if record.ID == "" {
+		return errors.New("presence record has no id")
+	}
+	for _, checker := range p.checkers {
+		if err := checker(c.identity, identifier, record); err != nil {
+			return errors.Wrapf(err, "presence record %s for %s failed check", record.ID, identifier)
+		}
+	}
+
+	notifications, err := p.addMember(c, identifier, record)
+	if err != nil {
+		return err
+	}
+	notify(ctx, notifications...)
+	return nil
+}
+
+// addMember adds the Conn to the presence set, returning the presence messages to send. The mutex
+// must not be held; it's held only while the presence set is changed.
+func (p *Presence) addMember(
+	c *Conn, identifier string, record PresenceRecord,
+) (notifications []presenceNotification, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ps, ok := p.streams[identifier]
+	if !ok {
+		return nil, errors.Errorf("no preexisting subscription on %s", identifier)
+	}
+	if _, ok := ps.watchers[c]; !ok {
+		return nil, errors.Errorf("no preexisting subscription on %s", identifier)
+	}
+	if id, ok := ps.joined[c]; ok {
+		if id == record.ID {
+			return nil, nil
+		}
+		notifications = p.leaveLocked(ps, c, identifier)
+	}
+
+	ps.joined[c] = record.ID
+	if member, ok := ps.members[record.ID]; ok {
+		member.conns[c] = struct{}{}
+		return notifications, nil
+	}
+	ps.members[record.ID] = &presenceMember{
+		info:  record.Info,
+		conns: map[*Conn]struct{}{c: {}},
+	}
+	return append(notifications, newNotification(
+		ps, newPresenceEvent(identifier, presenceJoinType, record),
+	)), nil
+}
+
+// leave removes the Conn from the presence set of the subscription identifier.
+func (p *Presence) leave(ctx context.Context, c *Conn, identifier string) {
+	p.mu.Lock()
+	var notifications []presenceNotification
+	if ps, ok := p.streams[identifier]; ok {
+		notifications = p.leaveLocked(ps, c, identifier)
+	}
+	p.mu.Unlock()
+
+	notify(ctx, notifications...)
+}
+
+// leaveLocked removes the Conn from the presence set. If the Conn was the last Conn of its record,
+// it returns a leave presence message for all Conns subscribed with the identifier. The mutex must
+// be held.
+func (p *Presence) leaveLocked(
+	ps *presenceStream, c *Conn, identifier string,
+) []presenceNotification {
+	id, ok := ps.joined[c]
+	if !ok {
+		return nil
+	}
+	delete(ps.joined, c)
+	member := ps.members[id]
+	delete(member.conns, c)
+	if len(member.conns) > 0 {
+		return nil
+	}
+	delete(ps.members, id)
+	return []presenceNotification{newNotification(
+		ps, newPresenceEvent(identifier, presenceLeaveType, PresenceRecord{ID: id}),
+	)}
+}
@@ -0,0 +1,196 @@
+package actioncable
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ConnIdentity identifies the client of a Conn or SSEConn, for looking it up in a [ConnRegistry].
+type ConnIdentity struct {
+	// SessionID is the ID of the client's session, e.g. so that all Conns of a session can be
+	// disconnected when the user logs out.
+	SessionID string
+	// Identifiers are additional identifiers of the client, like the connection identifiers of Rails
+	// Action Cable (e.g. "user" for the ID of the user, so that all Conns of a user can be
+	// disconnected when the user is banned).
+	Identifiers map[string]string
+}
+
+// RegisteredConn is a server-side Action Cable connection which can be kept track of by a
+// [ConnRegistry]: either a WebSocket [Conn] or a Server-Sent Events [SSEConn].
+type RegisteredConn interface {
+	// ID returns the randomly-generated ID of the connection.
+	ID() string
+	// Identity returns the identity with which the connection was added to a ConnRegistry.
+	Identity() ConnIdentity
+	// Subscriptions returns the sorted identifiers of the connection's current subscriptions.
+	Subscriptions() []string
+	// Disconnect makes the connection send an Action Cable disconnect message with the reason and
+	// reconnect flag, and then stop being served.
+	Disconnect(reason string, allowReconnect bool)
+
+	hasSubscription(identifier string) bool
+	send(ctx context.Context, message serverMessage) error
+}
+
+// ConnRegistry keeps track of Conns and SSEConns while they're being served, so that they can be
+// looked up, disconnected, and sent messages by other goroutines than the ones serving them.
+type ConnRegistry struct {
+	mu       sync.RWMutex
+	conns    map[RegisteredConn]struct{}
+	sessions map[string]map[RegisteredConn]struct{}
+}
+
+// NewConnRegistry creates a new instance of ConnRegistry.
+func NewConnRegistry() *ConnRegistry {
+	return &ConnRegistry{
+		conns:    make(map[RegisteredConn]struct{}),
+		sessions: make(map[string]map[RegisteredConn]struct{}),
+	}
+}
+
+// WithConnRegistry is a ConnOption to add the Conn to the registry with the identity, while the
+// Conn is being served.
+func WithConnRegistry(r *ConnRegistry, identity ConnIdentity) ConnOption {
+	return func(c *Conn) {
+		c.registry = r
+		c.identity = identity
+	}
+}
+
+// add starts keeping track of the connection.
+func (r *ConnRegistry) add(c RegisteredConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.conns[c] = struct{}{}
+	sessionID := c.Identity().SessionID
+	if sessionID == "" {
+		return
+	}
+	if _, ok := r.sessions[sessionID]; !ok {
+		r.sessions[sessionID] = make(map[RegisteredConn]struct{})
+	}
+	r.sessions[sessionID][c] = struct{}{}
+}
+
+// remove stops keeping track of the connection.
+func (r *ConnRegistry) remove(c RegisteredConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.conns, c)
+	sessionID := c.Identity().SessionID
+	delete(r.sessions[sessionID], c)
+	if len(r.sessions[sessionID]) == 0 {
+		delete(r.sessions, sessionID)
+	}
+}
+
+// Lookup
+
+// Conns returns all connections currently being served.
+func (r *ConnRegistry) Conns() []RegisteredConn {
+	return r.filter(func(RegisteredConn) bool { return true })
+}
+
+// SessionConns returns all connections currently being served for the session.
+func (r *ConnRegistry) SessionConns(sessionID string) []RegisteredConn {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	conns := make([]RegisteredConn, 0, len(r.sessions[sessionID]))
+	for c := range r.sessions[sessionID] {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// IdentifiedConns returns all connections currently being served whose identity has the
+// identifier with the value.
+func (r *ConnRegistry) IdentifiedConns(key, value string) []RegisteredConn {
+	return r.filter(func(c RegisteredConn) bool {
+		identifier, ok := c.Identity().Identifiers[key]
+		return ok && identifier == value
+	})
+}
+
+// SubscribedConns returns all connections currently being served which have a subscription with
+// the Action Cable subscription identifier.
+func (r *ConnRegistry) SubscribedConns(identifier string) []RegisteredConn {
+	return r.filter(func(c RegisteredConn) bool {
+		return c.hasSubscription(identifier)
+	})
+}
+
+// filter returns all connections currently being served which satisfy the predicate.
+func (r *ConnRegistry) filter(predicate func(c RegisteredConn) bool) []RegisteredConn {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	conns := make([]RegisteredConn, 0, len(r.conns))
+	for c := range r.conns {
+		if predicate(c) {
+			conns = append(conns, c)
+		}
+	}
+	return conns
+}
+
+// Disconnection
+
+// disconnectAll disconnects the connections and returns the number of connections disconnected.
+func disconnectAll(conns []RegisteredConn, reason string, allowReconnect bool) int {
+	for _, c := range conns {
+		c.Disconnect(reason, allowReconnect)
+	}
+	return len(conns)
+}
+
+// DisconnectSession disconnects all connections currently being served for the session (e.g. when
+// the user logs out), whether they're WebSocket Conns or SSEConns, with the Action Cable disconnect
+// reason and reconnect flag. It returns the number of connections disconnected.
+func (r *ConnRegistry) DisconnectSession(sessionID, reason string, allowReconnect bool) int {
+	return disconnectAll(r.SessionConns(sessionID), reason, allowReconnect)
+}
+
+// DisconnectIdentified disconnects all connections currently being served whose identity has the
+// identifier with the value (e.g. when a user is banned), with the Action Cable disconnect reason
+// and reconnect flag. It returns the number of connections disconnected.
+func (r *ConnRegistry) DisconnectIdentified(
+	key, value, reason string, allowReconnect bool,
+) int {
+	return disconnectAll(r.IdentifiedConns(key, value), reason, allowReconnect)
+}
+
+// DisconnectAll disconnects all connections currently being served (e.g. with reconnection
+// allowed, when the server is restarting), with the Action Cable disconnect reason and reconnect
+// flag. It returns the number of connections disconnected.
+func (r *ConnRegistry) DisconnectAll(reason string, allowReconnect bool) int {
+	return disconnectAll(r.Conns(), reason, allowReconnect)
+}
+
+// Broadcasting
+
+// Broadcast enqueues the message for sending to every connection currently being served which has
+// a subscription with the Action Cable subscription identifier, blocking until the message is added
+// to each connection's queue (or the connection is done being served). It returns the number of
+// connections which the message was enqueued for.
+func (r *ConnRegistry) Broadcast(ctx context.Context, identifier, message string) (int, error) {
+	sent := 0
+	for _, c := range r.SubscribedConns(identifier) {
+		if err := c.send(ctx, newData(identifier, message)); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return sent, errors.Wrapf(
+					ctxErr, "couldn't broadcast to all subscribers of %s", identifier,
+				)
+			}
+			// The connection stopped being served, so it no longer needs the message
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
@@ -0,0 +1,423 @@
+package actioncable
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/sargassum-world/godest/marshaling"
+)
+
+// Server-Sent Events
+
+// SSEContentType is the MIME type of Server-Sent Events streams.
+const SSEContentType = "text/event-stream"
+
+// SSEConn represents a server-side Action Cable connection which sends Action Cable server messages
+// to the client as Server-Sent Events, as an alternative to WebSockets (e.g. for clients behind
+// proxies which break WebSocket connections). Since Server-Sent Events only go from the server to
+// the client, the client sends its Action Cable commands in separate requests (e.g. ordinary POST
+// requests), which are passed to the SSEConn's methods for commands (e.g. by
+// [SSEConns.HandleCommand]). Every event's data is an Action Cable server message, encoded in the
+// same JSON format as for the actioncable-v1-json WebSocket subprotocol; the welcome message also
+// has a "sid" field with the ID of the SSEConn.
+type SSEConn struct {
+	id        string
+	identity  ConnIdentity
+	h         Handler
+	toClient  chan serverMessage
+	marshaler marshaling.Marshaler
+	conns     *SSEConns
+	registry  *ConnRegistry
+
+	// commandMu serializes the processing of commands, since commands can arrive concurrently in
+	// separate requests but handlers (e.g. ChannelDispatcher) expect commands to arrive one at a time,
+	// as they do over a WebSocket connection
+	commandMu sync.Mutex
+
+	// mu protects the fields below, which are accessed by the handlers of commands from the client
+	mu            sync.Mutex
+	unsubscribers map[string]func()
+	serveCtx      context.Context
+	cancelServe   context.CancelCauseFunc
+	disconnecting *DisconnectError
+	done          chan struct{}
+}
+
+// ID returns the randomly-generated ID of the SSEConn, which the client uses to identify the
+// SSEConn when sending commands.
+func (c *SSEConn) ID() string {
+	return c.id
+}
+
+// SessionID returns the ID of the session with which the SSEConn was created.
+func (c *SSEConn) SessionID() string {
+	return c.identity.SessionID
+}
+
+// Identity returns the identity of the SSEConn, which has the ID of the session with which the
+// SSEConn was created and any identifiers with which it was added to a [ConnRegistry].
+func (c *SSEConn) Identity() ConnIdentity {
+	return c.identity
+}
+
+// Subscriptions returns the sorted identifiers of the SSEConn's current subscriptions.
+func (c *SSEConn) Subscriptions() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	identifiers := make([]string, 0, len(c.unsubscribers))
+	for identifier := range c.unsubscribers {
+		identifiers = append(identifiers, identifier)
+	}
+	slices.Sort(identifiers)
+	return identifiers
+}
+
+// hasSubscription checks whether the SSEConn has a subscription with the identifier.
+func (c *SSEConn) hasSubscription(identifier string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.unsubscribers[identifier]
+	return ok
+}
+
+// Commands
+
+// send enqueues the message for sending to the client, blocking until the message is added to the
+// queue, the context is canceled, or the SSEConn is done serving.
+func (c *SSEConn) send(ctx context.Context, message serverMessage) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.done:
+		return errors.New("server-sent events stream is no longer served")
+	case c.toClient <- message:
+		return nil
+	}
+}
+
+// Subscribe processes an Action Cable subscribe command for the identifier. The subscription lasts
+// until it's canceled by an unsubscribe command or until the SSEConn is done serving, so the
+// context only needs to last as long as the request which carried the command.
+func (c *SSEConn) Subscribe(ctx context.Context, identifier string) error {
+	c.commandMu.Lock()
+	defer c.commandMu.Unlock()
+
+	c.mu.Lock()
+	serveCtx := c.serveCtx
+	_, subscribed := c.unsubscribers[identifier]
+	c.mu.Unlock()
+	if serveCtx == nil {
+		return errors.New("server-sent events stream is not yet served")
+	}
+	if subscribed {
+		// the subscriber already has a subscription, so just confirm it again.
+		return c.send(ctx, newSubscriptionConfirmation(identifier))
+	}
+
+	sctx, cancel := context.WithCancel(serveCtx)
+	if err := c.h.HandleSubscription(
+		sctx, &Subscription{
+			identifier: identifier,
+			toClient:   c.toClient,
+		},
+	); err != nil {
+		cancel()
+		if serr := c.send(ctx, newSubscriptionRejection(identifier)); serr != nil {
+			return serr
+		}
+		return errors.Wrap(err, "subscribe command handler encountered error")
+	}
+	c.mu.Lock()
+	c.unsubscribers[identifier] = cancel
+	c.mu.Unlock()
+	return c.send(ctx, newSubscriptionConfirmation(identifier))
+}
+
+// Unsubscribe processes an Action Cable unsubscribe command for the identifier.
+func (c *SSEConn) Unsubscribe(identifier string) {
+	c.commandMu.Lock()
+	defer c.commandMu.Unlock()
+
+	c.mu.Lock()
+	unsubscriber, ok := c.unsubscribers[identifier]
+	delete(c.unsubscribers, identifier)
+	c.mu.Unlock()
+	if ok && unsubscriber != nil {
+		unsubscriber()
+	}
+}
+
+// Perform processes an Action Cable action command for the identifier.
+func (c *SSEConn) Perform(ctx context.Context, identifier, data string) error {
+	c.commandMu.Lock()
+	defer c.commandMu.Unlock()
+
+	return errors.Wrap(
+		c.h.HandleAction(ctx, identifier, data), "action command handler encountered error",
+	)
+}
+
+// ReceiveCommand processes a JSON-encoded Action Cable command, in the same format as commands sent
+// over the actioncable-v1-json WebSocket subprotocol (e.g. the body of a POST request).
+func (c *SSEConn) ReceiveCommand(ctx context.Context, marshaled []byte) error {
+	var command clientMessage
+	if err := c.marshaler.Unmarshal(marshaled, &command); err != nil {
+		return errors.Wrap(err, "couldn't unmarshal client command")
+	}
+	switch command.Command {
+	default:
+		return errors.Errorf("unknown command %s", command.Command)
+	case subscribeCommand:
+		return c.Subscribe(ctx, command.Identifier)
+	case unsubscribeCommand:
+		c.Unsubscribe(command.Identifier)
+		return nil
+	case actionCommand:
+		return c.Perform(ctx, command.Identifier, command.Data)
+	}
+}
+
+// Serving
+
+// Disconnect stops the SSEConn's Serve method (or makes it return immediately, if it hasn't
+// started yet), which then sends an Action Cable disconnect message with the reason and reconnect
+// flag and returns a [DisconnectError].
+func (c *SSEConn) Disconnect(reason string, allowReconnect bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.disconnecting != nil {
+		return
+	}
+	c.disconnecting = &DisconnectError{Reason: reason, Reconnect: allowReconnect}
+	if c.cancelServe != nil {
+		c.cancelServe(c.disconnecting)
+	}
+}
+
+// writeEvent writes the value as the marshaled data of a Server-Sent Event, and flushes it to the
+// client.
+func (c *SSEConn) writeEvent(w http.ResponseWriter, v any) error {
+	marshaled, err := c.marshaler.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "couldn't marshal data to write as server-sent event")
+	}
+	// JSON-encoded values don't contain newlines, so they fit on one data line
+	if _, err = fmt.Fprintf(w, "data: %s\n\n", marshaled); err != nil {
+		return errors.Wrap(err, "couldn't write server-sent event")
+	}
+	return errors.Wrap(
+		http.NewResponseController(w).Flush(), "couldn't flush server-sent event",
+	)
+}
+
+// Serve writes the Action Cable server messages for the SSEConn as a stream of Server-Sent Events
+// to the response, until the context is canceled (e.g. because the client closed the request) or
+// the SSEConn is disconnected. If the SSEConn was added to a [ConnRegistry], it's registered for as
+// long as Serve is running. When Serve returns, all subscriptions are canceled and the SSEConn is
+// removed from its [SSEConns]; so Serve should only be called once.
+func (c *SSEConn) Serve(ctx context.Context, w http.ResponseWriter) (err error) {
+	sctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	c.mu.Lock()
+	c.serveCtx = sctx
+	c.cancelServe = cancel
+	if c.disconnecting != nil {
+		cancel(c.disconnecting)
+	}
+	c.mu.Unlock()
+	if c.registry != nil {
+		c.registry.add(c)
+	}
+	defer func() {
+		// As with Conns, the SSEConn is removed from the registry before it's marked as done
+		if c.registry != nil {
+			c.registry.remove(c)
+		}
+		close(c.done)
+		c.cancelAll()
+		c.conns.remove(c)
+	}()
+
+	w.Header().Set("Content-Type", SSEContentType)
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.Header().Set("X-Accel-Buffering", "no") // prevent buffering by reverse proxies such as nginx
+	w.WriteHeader(http.StatusOK)
+	if err = c.writeEvent(w, newWelcome(c.id)); err != nil {
+		return errors.Wrap(err, "couldn't send welcome message for Action Cable handshake")
+	}
+
+	if err = c.sendAll(sctx, w); err == nil || !errors.Is(err, context.Canceled) {
+		return err
+	}
+	if de := (*DisconnectError)(nil); errors.As(context.Cause(sctx), &de) {
+		// We send the disconnect message only as a courtesy, so we don't care if it fails
+		_ = c.writeEvent(w, newDisconnect(de.Reason, de.Reconnect))
+		return de
+	}
+	return err
+}
+
+// sendAll sends all Action Cable pings and Action Cable messages from the toClient queue.
+func (c *SSEConn) sendAll(ctx context.Context, w http.ResponseWriter) error {
+	const cablePingPeriod = 3 * time.Second
+	cablePingTicker := time.NewTicker(cablePingPeriod)
+	defer cablePingTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-cablePingTicker.C:
+			if err := ctx.Err(); err != nil {
+				// Context was also canceled, it should have priority
+				return err
+			}
+			if err := c.writeEvent(w, newPing(time.Now())); err != nil {
+				return errors.Wrap(err, "couldn't send Action Cable ping")
+			}
+		case message := <-c.toClient:
+			// As with Conns, we write the message even if the context was also canceled, because the
+			// message was already dequeued and may need to precede the disconnect message (e.g. a
+			// subscription rejection whose error is what canceled the context)
+			if err := c.writeEvent(w, message); err != nil {
+				return errors.Wrap(err, "couldn't send Action Cable server message for client")
+			}
+		}
+	}
+}
+
+// cancelAll cancels all subscriptions.
+func (c *SSEConn) cancelAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, unsubscriber := range c.unsubscribers {
+		unsubscriber()
+	}
+	c.unsubscribers = make(map[string]func())
+}
+
+// SSEConns
+
+// SSEConns keeps track of SSEConns by their IDs, so that the handlers of requests carrying commands
+// from clients can look up the SSEConns which the commands are for. To disconnect SSEConns (e.g.
+// when the user logs out), add them to a [ConnRegistry] with [WithSSEConnRegistry].
+type SSEConns struct {
+	mu    sync.RWMutex
+	conns map[string]*SSEConn
+}
+
+// NewSSEConns creates a new instance of SSEConns.
+func NewSSEConns() *SSEConns {
+	return &SSEConns{
+		conns: make(map[string]*SSEConn),
+	}
+}
+
+// SSEConnOption modifies an [SSEConn]. For use with [SSEConns.New].
+type SSEConnOption func(conn *SSEConn)
+
+// WithSSEConnRegistry is an SSEConnOption to add the SSEConn to the registry, while the SSEConn is
+// being served, with an identity which has the SSEConn's session ID and the identifiers. Then the
+// SSEConn is disconnected together with the session's WebSocket Conns by
+// [ConnRegistry.DisconnectSession], and it receives messages from [ConnRegistry.Broadcast].
+func WithSSEConnRegistry(r *ConnRegistry, identifiers map[string]string) SSEConnOption {
+	return func(c *SSEConn) {
+		c.registry = r
+		c.identity.Identifiers = identifiers
+	}
+}
+
+// New creates an SSEConn for the session, which will be kept track of until its Serve method
+// returns. The handler is used in the same way as for WebSocket Action Cable connections, so a
+// [ChannelDispatcher] (e.g. with subscription identifier checks by a [Signer]) can be used for
+// SSEConns too.
+func (s *SSEConns) New(handler Handler, sessionID string, opts ...SSEConnOption) *SSEConn {
+	conn := &SSEConn{
+		id:            rand.Text(),
+		identity:      ConnIdentity{SessionID: sessionID},
+		h:             handler,
+		toClient:      make(chan serverMessage),
+		marshaler:     marshaling.JSON{},
+		conns:         s,
+		unsubscribers: make(map[string]func()),
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(conn)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.conns[conn.id] = conn
+	return conn
+}
+
+// Get looks up the SSEConn with the ID. Because SSEConn IDs are sent to clients, Get returns an
+// error if the SSEConn was created for a different session than the one with the provided session
+// ID, so that clients can't send commands for other sessions' SSEConns.
+func (s *SSEConns) Get(id, sessionID string) (*SSEConn, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	conn, ok := s.conns[id]
+	if !ok || conn.identity.SessionID != sessionID {
+		return nil, errors.Errorf("server-sent events stream %s not found", id)
+	}
+	return conn, nil
+}
+
+// SSEConnIDParam is the URL query parameter in which clients send the ID of their SSEConn (from the
+// "sid" field of its welcome message) with each command request handled by
+// [SSEConns.HandleCommand].
+const SSEConnIDParam = "sid"
+
+// maxSSECommandSize is the maximum size of the body of a command request.
+const maxSSECommandSize = 1 << 16
+
+// HandleCommand processes the JSON-encoded Action Cable command in the body of the request (e.g. a
+// POST request which the client sends to an endpoint next to its Server-Sent Events stream), for
+// the SSEConn of the session whose ID is in the request's [SSEConnIDParam] query parameter. The app
+// is responsible for routing command requests to HandleCommand, for looking up the session of the
+// request, and for protecting the endpoint against cross-site request forgery (as for any other
+// POST endpoint). HandleCommand responds with 204 No Content if the command was processed, 404 Not
+// Found if the SSEConn doesn't exist, and 400 Bad Request if the command couldn't be processed; it
+// returns any error, e.g. for logging.
+func (s *SSEConns) HandleCommand(w http.ResponseWriter, r *http.Request, sessionID string) error {
+	conn, err := s.Get(r.URL.Query().Get(SSEConnIDParam), sessionID)
+	if err != nil {
+		http.Error(w, "server-sent events stream not found", http.StatusNotFound)
+		return err
+	}
+	command, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSSECommandSize))
+	if err != nil {
+		http.Error(w, "couldn't read client command", http.StatusBadRequest)
+		return errors.Wrap(err, "couldn't read client command")
+	}
+	if err = conn.ReceiveCommand(r.Context(), command); err != nil {
+		http.Error(w, "couldn't process client command", http.StatusBadRequest)
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// remove stops keeping track of the SSEConn.
+func (s *SSEConns) remove(c *SSEConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, c.id)
+}
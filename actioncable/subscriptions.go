@@ -8,6 +8,9 @@ import (
 type Subscription struct {
 	identifier string
 	toClient   chan<- serverMessage
+	// ext is whether the subscriber's connection uses the extended protocol, so that data messages
+	// can carry the stream ID and position of each message
+	ext bool
 }
 
 // Identifier returns the Action Cable subscription identifier.
@@ -15,6 +18,12 @@ func (s *Subscription) Identifier() string {
 	return s.identifier
 }
 
+// Extended returns whether the subscriber's connection uses the extended protocol, which supports
+// stream positions, history, and presence.
+func (s *Subscription) Extended() bool {
+	return s.ext
+}
+
 // SendText enqueues the string message for sending to the subscription's subscriber, blocking until
 // the message is added to the queue.
 func (s *Subscription) SendText(ctx context.Context, message string) error {
@@ -39,6 +48,30 @@ func (s *Subscription) SendText(ctx context.Context, message string) error {
 	}
 }
 
+// SendTextAt enqueues the string message for sending to the subscription's subscriber like
+// [Subscription.SendText], but with the ID of the stream which the message was broadcast on and the
+// position of the message in the stream's history, so that a subscriber using the extended protocol
+// can request the messages it missed after that position when it reconnects. For subscribers not
+// using the extended protocol, the stream ID and position are omitted.
+func (s *Subscription) SendTextAt(
+	ctx context.Context, message, streamID string, position StreamPosition,
+) error {
+	m := newData(s.identifier, message)
+	if s.ext && position.Epoch != "" {
+		m = newPositionedData(s.identifier, message, streamID, position)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case s.toClient <- m:
+		if err := ctx.Err(); err != nil {
+			// Context was also canceled and it should have priority
+			return err
+		}
+		return nil
+	}
+}
+
 // SendBytes enqueues the string message for sending to the subscription's subscriber, blocking
 // until the message is added to the queue.
 func (s *Subscription) SendBytes(ctx context.Context, message []byte) error {
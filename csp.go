@@ -0,0 +1,309 @@
+package godest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// CSP sources
+
+const (
+	CSPSelf         = "'self'"
+	CSPNone         = "'none'"
+	CSPUnsafeInline = "'unsafe-inline'"
+	CSPUnsafeEval   = "'unsafe-eval'"
+)
+
+// CSP directives
+
+const (
+	CSPDefaultSrc = "default-src"
+	CSPScriptSrc  = "script-src"
+	CSPStyleSrc   = "style-src"
+	CSPImgSrc     = "img-src"
+	CSPMediaSrc   = "media-src"
+	CSPFontSrc    = "font-src"
+	CSPConnectSrc = "connect-src"
+	CSPReportURI  = "report-uri"
+	CSPReportTo   = "report-to"
+)
+
+// assetOriginSource determines the CSP source expression for assets served under the URL prefix,
+// which may either be a path on the app's own origin or an absolute URL (e.g. for a CDN).
+func assetOriginSource(urlPrefix string) (string, error) {
+	u, err := url.Parse(urlPrefix)
+	if err != nil {
+		return "", errors.Wrapf(err, "couldn't parse asset URL prefix %s", urlPrefix)
+	}
+	if u.Host == "" {
+		return CSPSelf, nil
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+// CSP nonces
+
+type cspNonceContextKey struct{}
+
+const cspNonceSize = 16
+
+func newCSPNonce() (string, error) {
+	nonce := make([]byte, cspNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.Wrap(err, "couldn't generate CSP nonce")
+	}
+	return base64.StdEncoding.EncodeToString(nonce), nil
+}
+
+// CSPNonce returns the per-request CSP nonce which was added to the request context by the CSP
+// middleware, or an empty string if there is no nonce.
+func CSPNonce(ctx context.Context) string {
+	nonce, ok := ctx.Value(cspNonceContextKey{}).(string)
+	if !ok {
+		return ""
+	}
+	return nonce
+}
+
+// CSPFuncs returns template functions for using CSP nonces in templates. The cspNonce function
+// takes the [RenderDataMeta] of the page and returns its nonce, e.g.
+// `<script nonce="{{cspNonce .Meta}}">`.
+func CSPFuncs() template.FuncMap {
+	return template.FuncMap{
+		"cspNonce": func(meta RenderDataMeta) string {
+			return meta.CSPNonce
+		},
+	}
+}
+
+// CSP Builder
+
+// CSPBuilder composes a Content-Security-Policy header from its directives and their sources.
+type CSPBuilder struct {
+	directives  []string
+	sources     map[string][]string
+	nonced      []string
+	reportGroup string
+	reportURL   string
+	reportOnly  bool
+}
+
+// CSPOption modifies a [CSPBuilder]. For use with [NewCSPBuilder].
+type CSPOption func(b *CSPBuilder) error
+
+// NewCSPBuilder creates a CSPBuilder with the specified options, starting from a policy which only
+// allows loading resources from the app's own origin.
+func NewCSPBuilder(opts ...CSPOption) (*CSPBuilder, error) {
+	b := &CSPBuilder{
+		sources: make(map[string][]string),
+	}
+	b.add(CSPDefaultSrc, CSPSelf)
+	for _, opt := range opts {
+		if err := opt(b); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// add adds sources to the directive. A fetch directive which did not previously exist is
+// initialized with the sources of the default-src directive, since otherwise adding sources to it
+// would stop it from falling back to default-src.
+func (b *CSPBuilder) add(directive string, sources ...string) {
+	existing, ok := b.sources[directive]
+	if !ok {
+		b.directives = append(b.directives, directive)
+		if strings.HasSuffix(directive, "-src") && directive != CSPDefaultSrc {
+			existing = slices.Clone(b.sources[CSPDefaultSrc])
+		}
+	}
+	for _, source := range sources {
+		if !slices.Contains(existing, source) {
+			existing = append(existing, source)
+		}
+	}
+	b.sources[directive] = existing
+}
+
+// WithCSPSources creates a [CSPOption] to add sources to a directive.
+func WithCSPSources(directive string, sources ...string) CSPOption {
+	return func(b *CSPBuilder) error {
+		b.add(directive, sources...)
+		return nil
+	}
+}
+
+// WithInlineHashes creates a [CSPOption] to allow the inline scripts and styles of the [Inlines],
+// by their hashes.
+func WithInlineHashes(i Inlines) CSPOption {
+	return func(b *CSPBuilder) error {
+		jsHashes := i.ComputeJSHashesForCSP()
+		slices.Sort(jsHashes)
+		cssHashes := i.ComputeCSSHashesForCSP()
+		slices.Sort(cssHashes)
+		b.add(CSPScriptSrc, jsHashes...)
+		b.add(CSPStyleSrc, cssHashes...)
+		return nil
+	}
+}
+
+// WithAppAssets creates a [CSPOption] to allow scripts and stylesheets from the origin of the URL
+// prefix passed to [Embeds.GetAppHashedNamer].
+func WithAppAssets(urlPrefix string) CSPOption {
+	return func(b *CSPBuilder) error {
+		source, err := assetOriginSource(urlPrefix)
+		if err != nil {
+			return err
+		}
+		b.add(CSPScriptSrc, source)
+		b.add(CSPStyleSrc, source)
+		return nil
+	}
+}
+
+// WithStaticAssets creates a [CSPOption] to allow images and media from the origin of the URL
+// prefix passed to [Embeds.GetStaticHashedNamer].
+func WithStaticAssets(urlPrefix string) CSPOption {
+	return func(b *CSPBuilder) error {
+		source, err := assetOriginSource(urlPrefix)
+		if err != nil {
+			return err
+		}
+		b.add(CSPImgSrc, source)
+		b.add(CSPMediaSrc, source)
+		return nil
+	}
+}
+
+// WithFontAssets creates a [CSPOption] to allow fonts from the origin of the URL prefix under which
+// the Embeds' FontsFS is served.
+func WithFontAssets(urlPrefix string) CSPOption {
+	return func(b *CSPBuilder) error {
+		source, err := assetOriginSource(urlPrefix)
+		if err != nil {
+			return err
+		}
+		b.add(CSPFontSrc, source)
+		return nil
+	}
+}
+
+// WithNonces creates a [CSPOption] to add a per-request nonce to the specified directives, or to
+// script-src and style-src if no directives are specified. The nonce is exposed in
+// [RenderDataMeta], and it can be used in templates with the functions from [CSPFuncs].
+func WithNonces(directives ...string) CSPOption {
+	if len(directives) == 0 {
+		directives = []string{CSPScriptSrc, CSPStyleSrc}
+	}
+	return func(b *CSPBuilder) error {
+		for _, directive := range directives {
+			b.add(directive)
+			if !slices.Contains(b.nonced, directive) {
+				b.nonced = append(b.nonced, directive)
+			}
+		}
+		return nil
+	}
+}
+
+// WithReportURI creates a [CSPOption] to report policy violations to the URI with the (deprecated
+// but widely-supported) report-uri directive.
+func WithReportURI(uri string) CSPOption {
+	return func(b *CSPBuilder) error {
+		b.add(CSPReportURI, uri)
+		return nil
+	}
+}
+
+// WithReportTo creates a [CSPOption] to report policy violations to the endpoint URL with the
+// report-to directive, using the endpoint group name in the Reporting-Endpoints header.
+func WithReportTo(group, endpointURL string) CSPOption {
+	return func(b *CSPBuilder) error {
+		b.add(CSPReportTo, group)
+		b.reportGroup = group
+		b.reportURL = endpointURL
+		return nil
+	}
+}
+
+// WithReportOnly creates a [CSPOption] to only report policy violations, rather than enforcing the
+// policy.
+func WithReportOnly() CSPOption {
+	return func(b *CSPBuilder) error {
+		b.reportOnly = true
+		return nil
+	}
+}
+
+// HasNonces checks whether the policy uses per-request nonces.
+func (b *CSPBuilder) HasNonces() bool {
+	return len(b.nonced) > 0
+}
+
+// Build returns the policy, with the nonce (if non-empty) added to the directives which should
+// have nonces.
+func (b *CSPBuilder) Build(nonce string) string {
+	directives := make([]string, 0, len(b.directives))
+	for _, directive := range b.directives {
+		sources := b.sources[directive]
+		if nonce != "" && slices.Contains(b.nonced, directive) {
+			sources = append(slices.Clone(sources), fmt.Sprintf("'nonce-%s'", nonce))
+		}
+		directives = append(directives, strings.TrimSpace(directive+" "+strings.Join(sources, " ")))
+	}
+	return strings.Join(directives, "; ")
+}
+
+// HeaderName returns the name of the header which the policy should be sent in.
+func (b *CSPBuilder) HeaderName() string {
+	if b.reportOnly {
+		return "Content-Security-Policy-Report-Only"
+	}
+	return "Content-Security-Policy"
+}
+
+// SetHeaders sets the policy's headers on the response, with the specified nonce.
+func (b *CSPBuilder) SetHeaders(h http.Header, nonce string) {
+	h.Set(b.HeaderName(), b.Build(nonce))
+	if b.reportGroup != "" {
+		h.Set("Reporting-Endpoints", fmt.Sprintf("%s=%q", b.reportGroup, b.reportURL))
+	}
+}
+
+// Middleware returns an http middleware which sets the policy's headers on every response, with a
+// new nonce for each request if the policy uses nonces. The nonce is added to the request's
+// context, where it can be looked up with [CSPNonce].
+func (b *CSPBuilder) Middleware() func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var nonce string
+			if b.HasNonces() {
+				var err error
+				if nonce, err = newCSPNonce(); err != nil {
+					http.Error(
+						w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError,
+					)
+					return
+				}
+				r = r.WithContext(context.WithValue(r.Context(), cspNonceContextKey{}, nonce))
+			}
+			b.SetHeaders(w.Header(), nonce)
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// EchoMiddleware returns the policy's middleware as an echo.MiddlewareFunc.
+func (b *CSPBuilder) EchoMiddleware() echo.MiddlewareFunc {
+	return echo.WrapMiddleware(b.Middleware())
+}
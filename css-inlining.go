@@ -0,0 +1,364 @@
+package godest
+
+import (
+	"bytes"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// CSS rules
+
+// cssComment matches comments in stylesheets.
+var cssComment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+
+// cssRule is a style rule from a stylesheet which can be inlined into style attributes.
+type cssRule struct {
+	selector     []cssCompoundSelector
+	specificity  [3]int
+	order        int
+	declarations string
+}
+
+// cssCompoundSelector is a sequence of simple selectors (a type or universal selector, an ID
+// selector, and class selectors) which must all match the same element.
+type cssCompoundSelector struct {
+	tag     string
+	id      string
+	classes []string
+}
+
+// parseStylesheet splits the stylesheet into style rules which can be inlined, and the remaining
+// CSS (such as @media rules and rules with pseudo-classes) which can't be inlined.
+func parseStylesheet(css string, order int) (rules []cssRule, remaining string) {
+	css = cssComment.ReplaceAllString(css, "")
+	var kept strings.Builder
+	for css = strings.TrimSpace(css); css != ""; css = strings.TrimSpace(css) {
+		if strings.HasPrefix(css, "@") {
+			end := atRuleEnd(css)
+			kept.WriteString(css[:end])
+			kept.WriteString("\n")
+			css = css[end:]
+			continue
+		}
+		open := strings.IndexByte(css, '{')
+		if open < 0 {
+			break
+		}
+		closing := strings.IndexByte(css[open:], '}')
+		if closing < 0 {
+			break
+		}
+		closing += open
+		selectors := strings.TrimSpace(css[:open])
+		declarations := normalizeDeclarations(css[open+1 : closing])
+		css = css[closing+1:]
+		if declarations == "" {
+			continue
+		}
+
+		var unsupported []string
+		for _, selector := range strings.Split(selectors, ",") {
+			selector = strings.TrimSpace(selector)
+			compounds, ok := parseSelector(selector)
+			if !ok {
+				unsupported = append(unsupported, selector)
+				continue
+			}
+			rules = append(rules, cssRule{
+				selector:     compounds,
+				specificity:  selectorSpecificity(compounds),
+				order:        order + len(rules),
+				declarations: declarations,
+			})
+		}
+		if len(unsupported) > 0 {
+			kept.WriteString(strings.Join(unsupported, ", ") + " { " + declarations + " }\n")
+		}
+	}
+	return rules, kept.String()
+}
+
+// atRuleEnd finds the end of the at-rule at the start of the CSS, which either ends with a
+// semicolon or with a (possibly nested) block.
+func atRuleEnd(css string) int {
+	depth := 0
+	for i, c := range css {
+		switch c {
+		case ';':
+			if depth == 0 {
+				return i + 1
+			}
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(css)
+}
+
+// splitDeclarations splits the declarations at semicolons which aren't inside strings or
+// parentheses (e.g. in "url(data:image/png;base64,...)").
+func splitDeclarations(declarations string) (split []string) {
+	var (
+		start   = 0
+		depth   = 0
+		quote   rune
+		escaped bool
+	)
+	for i, c := range declarations {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == ';' && depth == 0:
+			split = append(split, declarations[start:i])
+			start = i + 1
+		}
+	}
+	return append(split, declarations[start:])
+}
+
+func normalizeDeclarations(declarations string) string {
+	var normalized []string
+	for _, declaration := range splitDeclarations(declarations) {
+		if declaration = strings.TrimSpace(declaration); declaration != "" {
+			normalized = append(normalized, declaration)
+		}
+	}
+	return strings.Join(normalized, "; ")
+}
+
+// cssSimpleSelector matches selectors made only of type, universal, ID, and class selectors
+// combined with descendant combinators.
+var cssSimpleSelector = regexp.MustCompile(`^([a-zA-Z0-9*#._-]+\s*)+$`)
+
+// parseSelector parses the selector, if it can be inlined.
+func parseSelector(selector string) (compounds []cssCompoundSelector, ok bool) {
+	if !cssSimpleSelector.MatchString(selector) {
+		return nil, false
+	}
+	for _, field := range strings.Fields(selector) {
+		var compound cssCompoundSelector
+		for i, part := range splitCompoundSelector(field) {
+			switch {
+			case strings.HasPrefix(part, "#"):
+				compound.id = part[1:]
+			case strings.HasPrefix(part, "."):
+				compound.classes = append(compound.classes, part[1:])
+			case i == 0:
+				if part != "*" {
+					compound.tag = strings.ToLower(part)
+				}
+			default:
+				return nil, false
+			}
+		}
+		compounds = append(compounds, compound)
+	}
+	return compounds, len(compounds) > 0
+}
+
+// splitCompoundSelector splits a compound selector before each ID and class selector.
+func splitCompoundSelector(compound string) (parts []string) {
+	start := 0
+	for i := 1; i < len(compound); i++ {
+		if compound[i] == '#' || compound[i] == '.' {
+			parts = append(parts, compound[start:i])
+			start = i
+		}
+	}
+	return append(parts, compound[start:])
+}
+
+func selectorSpecificity(compounds []cssCompoundSelector) (specificity [3]int) {
+	for _, compound := range compounds {
+		if compound.id != "" {
+			specificity[0]++
+		}
+		specificity[1] += len(compound.classes)
+		if compound.tag != "" {
+			specificity[2]++
+		}
+	}
+	return specificity
+}
+
+// Selector matching
+
+func (s cssCompoundSelector) matches(n *html.Node) bool {
+	if n.Type != html.ElementNode || (s.tag != "" && s.tag != n.Data) {
+		return false
+	}
+	if s.id != "" && getAttr(n, "id") != s.id {
+		return false
+	}
+	classes := strings.Fields(getAttr(n, "class"))
+	for _, class := range s.classes {
+		if !slices.Contains(classes, class) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r cssRule) matches(n *html.Node) bool {
+	last := len(r.selector) - 1
+	if !r.selector[last].matches(n) {
+		return false
+	}
+	ancestor := n.Parent
+	for i := last - 1; i >= 0; i-- {
+		for ancestor != nil && !r.selector[i].matches(ancestor) {
+			ancestor = ancestor.Parent
+		}
+		if ancestor == nil {
+			return false
+		}
+		ancestor = ancestor.Parent
+	}
+	return true
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i, attr := range n.Attr {
+		if attr.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+// CSS inlining
+
+// inlineCSS moves the style rules from the HTML document's style elements and from its stylesheet
+// links into the style attributes of the elements which they match, since many email clients
+// ignore stylesheets. Stylesheet links with the URL prefix are resolved by stripping the URL prefix
+// from their URLs, unhashing the resulting file names, and then looking up the unhashed file names
+// in the filesystem; stylesheet links without the URL prefix are left as they are, while stylesheet
+// links with the URL prefix which can't be resolved are reported as errors. If the filesystem is
+// nil, all stylesheet links are left as they are. Any CSS which can't be inlined (e.g. @media
+// rules, and rules with pseudo-classes) is kept in a style element in the document's head, for
+// email clients which support it. Existing style attributes take precedence over inlined style
+// rules.
+func inlineCSS(
+	document string, stylesheetsFS fs.FS, urlPrefix string, unhash func(string) string,
+) (string, error) {
+	root, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't parse HTML")
+	}
+
+	var (
+		rules     []cssRule
+		remaining strings.Builder
+		head      *html.Node
+		removed   []*html.Node
+	)
+	for n := range root.Descendants() {
+		if n.Type != html.ElementNode {
+			continue
+		}
+		var css string
+		switch n.DataAtom {
+		case atom.Head:
+			head = n
+			continue
+		case atom.Style:
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				css += c.Data
+			}
+		case atom.Link:
+			href := getAttr(n, "href")
+			if stylesheetsFS == nil || getAttr(n, "rel") != "stylesheet" ||
+				!strings.HasPrefix(href, urlPrefix) {
+				continue
+			}
+			file := unhash(strings.TrimPrefix(href, urlPrefix))
+			stylesheet, err := readFile(file, stylesheetsFS)
+			if err != nil {
+				return "", errors.Wrapf(err, "couldn't read stylesheet %s linked as %s", file, href)
+			}
+			css = string(stylesheet)
+		default:
+			continue
+		}
+		stylesheetRules, stylesheetRemaining := parseStylesheet(css, len(rules))
+		rules = append(rules, stylesheetRules...)
+		remaining.WriteString(stylesheetRemaining)
+		removed = append(removed, n)
+	}
+	for _, n := range removed {
+		n.Parent.RemoveChild(n)
+	}
+
+	// Rules are applied in order of increasing specificity, and then in order of appearance
+	slices.SortStableFunc(rules, func(a, b cssRule) int {
+		for i := range a.specificity {
+			if a.specificity[i] != b.specificity[i] {
+				return a.specificity[i] - b.specificity[i]
+			}
+		}
+		return a.order - b.order
+	})
+	for n := range root.Descendants() {
+		if n.Type != html.ElementNode {
+			continue
+		}
+		var declarations []string
+		for _, rule := range rules {
+			if rule.matches(n) {
+				declarations = append(declarations, rule.declarations)
+			}
+		}
+		if len(declarations) == 0 {
+			continue
+		}
+		if style := normalizeDeclarations(getAttr(n, "style")); style != "" {
+			declarations = append(declarations, style)
+		}
+		setAttr(n, "style", strings.Join(declarations, "; "))
+	}
+
+	if remaining.Len() > 0 && head != nil {
+		style := &html.Node{Type: html.ElementNode, DataAtom: atom.Style, Data: "style"}
+		style.AppendChild(&html.Node{Type: html.TextNode, Data: remaining.String()})
+		head.AppendChild(style)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := html.Render(buf, root); err != nil {
+		return "", errors.Wrap(err, "couldn't render HTML")
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,176 @@
+// Package email provides support for composing multipart MIME email messages and handing them to
+// SMTP servers.
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Message is an email message with a plain-text body, an HTML body, or both.
+type Message struct {
+	From    mail.Address
+	To      []mail.Address
+	Cc      []mail.Address
+	Bcc     []mail.Address
+	ReplyTo []mail.Address
+	Subject string
+	// Date is the date of the message. If it's zero, the time when the message is encoded is used.
+	Date time.Time
+	// Header holds any additional header fields of the message, e.g. Message-ID or List-Unsubscribe.
+	Header textproto.MIMEHeader
+	// Text is the plain-text body of the message.
+	Text string
+	// HTML is the HTML body of the message.
+	HTML string
+}
+
+// Recipients returns the addresses of all recipients of the message, including Bcc recipients,
+// for use as the envelope recipients of an SMTP transaction.
+func (m Message) Recipients() []string {
+	recipients := make([]string, 0, len(m.To)+len(m.Cc)+len(m.Bcc))
+	for _, addresses := range [][]mail.Address{m.To, m.Cc, m.Bcc} {
+		for _, address := range addresses {
+			recipients = append(recipients, address.Address)
+		}
+	}
+	return recipients
+}
+
+// Bytes encodes the message in the Internet Message Format. A message with both a plain-text body
+// and an HTML body is encoded as a multipart/alternative MIME message. Bcc recipients are omitted
+// from the encoded header.
+func (m Message) Bytes() ([]byte, error) {
+	if m.Text == "" && m.HTML == "" {
+		return nil, errors.New("message has neither a plain-text body nor an HTML body")
+	}
+	if len(m.Recipients()) == 0 {
+		return nil, errors.New("message has no recipients")
+	}
+
+	header, err := m.header()
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if m.Text == "" || m.HTML == "" {
+		contentType, body := "text/plain; charset=utf-8", m.Text
+		if m.Text == "" {
+			contentType, body = "text/html; charset=utf-8", m.HTML
+		}
+		header.Set("Content-Type", contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		writeHeader(buf, header)
+		if err := writeQuotedPrintable(buf, body); err != nil {
+			return nil, errors.Wrap(err, "couldn't encode message body")
+		}
+		return buf.Bytes(), nil
+	}
+
+	parts := new(bytes.Buffer)
+	mw := multipart.NewWriter(parts)
+	for _, part := range []struct {
+		contentType string
+		body        string
+	}{
+		{contentType: "text/plain; charset=utf-8", body: m.Text},
+		{contentType: "text/html; charset=utf-8", body: m.HTML},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't create message part")
+		}
+		if err := writeQuotedPrintable(pw, part.body); err != nil {
+			return nil, errors.Wrapf(err, "couldn't encode %s message part", part.contentType)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, errors.Wrap(err, "couldn't finish multipart message")
+	}
+	header.Set("Content-Type", mime.FormatMediaType(
+		"multipart/alternative", map[string]string{"boundary": mw.Boundary()},
+	))
+	writeHeader(buf, header)
+	_, _ = buf.Write(parts.Bytes())
+	return buf.Bytes(), nil
+}
+
+// header makes the header of the message, except for the header fields describing the body.
+func (m Message) header() (textproto.MIMEHeader, error) {
+	header := make(textproto.MIMEHeader)
+	for key, values := range m.Header {
+		for _, value := range values {
+			header.Add(key, value)
+		}
+	}
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	header.Set("Date", date.Format(time.RFC1123Z))
+	header.Set("From", m.From.String())
+	for key, addresses := range map[string][]mail.Address{
+		"To": m.To, "Cc": m.Cc, "Reply-To": m.ReplyTo,
+	} {
+		if len(addresses) > 0 {
+			header.Set(key, formatAddresses(addresses))
+		}
+	}
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header.Set("MIME-Version", "1.0")
+
+	for key, values := range header {
+		for _, value := range values {
+			if strings.ContainsAny(key, "\r\n:") || strings.ContainsAny(value, "\r\n") {
+				return nil, errors.Errorf("message header field %s has a line break", key)
+			}
+		}
+	}
+	return header, nil
+}
+
+func formatAddresses(addresses []mail.Address) string {
+	formatted := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		formatted = append(formatted, address.String())
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// writeHeader writes the header fields in a deterministic order, followed by the blank line which
+// separates the header from the body.
+func writeHeader(buf *bytes.Buffer, header textproto.MIMEHeader) {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		for _, value := range header[key] {
+			fmt.Fprintf(buf, "%s: %s\r\n", key, value)
+		}
+	}
+	buf.WriteString("\r\n")
+}
+
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qw := quotedprintable.NewWriter(w)
+	if _, err := qw.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qw.Close()
+}
@@ -0,0 +1,86 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Sender sends email messages.
+type Sender interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// SMTPSender sends email messages through an SMTP server.
+type SMTPSender struct {
+	// Addr is the address of the SMTP server, including the port, e.g. "smtp.example.com:587".
+	Addr string
+	// Auth is the authentication mechanism for the SMTP server, if it requires authentication.
+	Auth smtp.Auth
+}
+
+// Send sends the message through the SMTP server. The STARTTLS extension is used if the server
+// supports it.
+func (s SMTPSender) Send(ctx context.Context, m Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := m.Bytes()
+	if err != nil {
+		return errors.Wrap(err, "couldn't encode message")
+	}
+	if err = smtp.SendMail(s.Addr, s.Auth, m.From.Address, m.Recipients(), data); err != nil {
+		return errors.Wrapf(err, "couldn't send message through %s", s.Addr)
+	}
+	return nil
+}
+
+// SentMessage is a message which was sent by a [FakeSender].
+type SentMessage struct {
+	Message Message
+	// Data is the message as it was encoded for sending.
+	Data []byte
+}
+
+// FakeSender records email messages instead of sending them, e.g. for testing or for local
+// development.
+type FakeSender struct {
+	mu   sync.Mutex
+	sent []SentMessage
+}
+
+// Send encodes the message and records it.
+func (s *FakeSender) Send(ctx context.Context, m Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := m.Bytes()
+	if err != nil {
+		return errors.Wrap(err, "couldn't encode message")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, SentMessage{Message: m, Data: data})
+	return nil
+}
+
+// Sent returns all messages which were sent, in the order they were sent.
+func (s *FakeSender) Sent() []SentMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sent := make([]SentMessage, len(s.sent))
+	copy(sent, s.sent)
+	return sent
+}
+
+// Reset forgets all messages which were sent.
+func (s *FakeSender) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sent = nil
+}
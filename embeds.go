@@ -6,7 +6,8 @@ import (
 	"fmt"
 	"html/template"
 	"io/fs"
-	"path"
+	"slices"
+	"strings"
 
 	"github.com/benbjohnson/hashfs"
 	"github.com/pkg/errors"
@@ -18,45 +19,27 @@ func ComputeCSPHash(resource []byte) string {
 	return fmt.Sprintf("'sha512-%s'", encodedHash)
 }
 
-func identifyModuleNonpageFiles(templates fs.FS) (map[string][]string, error) {
-	modules, err := listDirectories(templates, filterTemplateModule)
-	if err != nil {
-		return nil, errors.Wrap(err, "couldn't list template modules")
-	}
-
-	moduleFiles := make(map[string][]string)
-	for _, module := range modules {
-		var subfs fs.FS
-		if module == "" {
-			subfs = templates
-		} else {
-			subfs, err = fs.Sub(templates, module)
-		}
-		if err != nil {
-			return nil, errors.Wrapf(err, "couldn't list template module %s", module)
-		}
-		moduleSubfiles, err := listFiles(subfs, filterNonpageTemplate)
-		moduleFiles[module] = make([]string, len(moduleSubfiles))
-		for i, subfile := range moduleSubfiles {
-			if module == "" {
-				moduleFiles[module][i] = subfile
-			} else {
-				moduleFiles[module][i] = module + "/" + subfile
-			}
-		}
-		if err != nil {
-			return nil, errors.Wrapf(err, "couldn't list template files in module %s", module)
-		}
-	}
-	return moduleFiles, nil
-}
-
 // Etag pre-computation
 
+// computeAppFingerprint computes the app fingerprint from the app assets and shared templates.
+// App assets with entries in the manifest entries are represented by their hashed names, which
+// change whenever their contents change, so that they don't need to be read.
 func computeAppFingerprint(
-	appAssets, sharedTemplates []string, templates, app fs.FS,
+	appAssets, sharedTemplates []string, templates, app fs.FS, entries map[string]ManifestEntry,
 ) (string, error) {
-	appConcatenated, err := readConcatenated(appAssets, app)
+	var (
+		hashedNames []byte
+		unlisted    []string
+	)
+	for _, file := range appAssets {
+		entry, ok := entries[file]
+		if !ok {
+			unlisted = append(unlisted, file)
+			continue
+		}
+		hashedNames = append(append(hashedNames, entry.HashedPath...), 0)
+	}
+	appConcatenated, err := readConcatenated(unlisted, app)
 	if err != nil {
 		return "", errors.Wrap(err, "couldn't load all app assets together for fingerprinting")
 	}
@@ -65,43 +48,67 @@ func computeAppFingerprint(
 		return "", errors.Wrap(err, "couldn't load all shared templates together for fingerprinting")
 	}
 
-	return computeFingerprint(append(appConcatenated, sharedConcatenated...)), nil
+	return computeFingerprint(slices.Concat(hashedNames, appConcatenated, sharedConcatenated)), nil
 }
 
 func computePageFingerprints(
-	moduleNonpageFiles map[string][]string, pageFiles []string, templates fs.FS,
+	pages map[string]*template.Template, components map[string]registeredComponent, templates fs.FS,
 ) (map[string]string, error) {
-	moduleNonpages := make(map[string][]byte)
-	for module, files := range moduleNonpageFiles {
-		loadedNonpages, err := readConcatenated(files, templates)
+	pageFingerprints := make(map[string]string)
+	for pageFile, page := range pages {
+		// Each page's fingerprint is computed from the page template itself as well as all template
+		// files which define any templates reachable from the page template through {{template}}
+		// calls and component invocations (e.g. the layouts which the page extends, and partials,
+		// whether in the page's module or in other modules)
+		files := newTemplateGraph(page, components).ReachableFiles(pageFile)
+		if !slices.Contains(files, pageFile) {
+			files = append([]string{pageFile}, files...)
+		}
+		loaded, err := readConcatenated(files, templates)
 		if err != nil {
 			return nil, errors.Wrapf(
-				err, "couldn't load non-page template files in module %s for fingerprinting", module,
+				err, "couldn't load template files reachable from page %s for fingerprinting", pageFile,
 			)
 		}
-		moduleNonpages[module] = loadedNonpages
+		pageFingerprints[pageFile] = computeFingerprint(loaded)
 	}
+	return pageFingerprints, nil
+}
 
-	pages := make(map[string][]byte)
-	for _, file := range pageFiles {
-		loadedPage, err := readFile(file, templates)
-		if err != nil {
-			return nil, errors.Wrapf(err, "couldn't load page template %s for fingerprinting", file)
+// computeFragmentFingerprints computes the fingerprint of each block and other named template
+// defined in each page's template set, from all template files which define any templates
+// reachable from that named template.
+func computeFragmentFingerprints(
+	pages map[string]*template.Template, components map[string]registeredComponent, templates fs.FS,
+) (map[string]map[string]string, error) {
+	fragmentFingerprints := make(map[string]map[string]string)
+	// Many blocks (e.g. blocks from shared layouts) reach the same files, so they share fingerprints
+	fileFingerprints := make(map[string]string)
+	for pageFile, page := range pages {
+		graph := newTemplateGraph(page, components)
+		fragmentFingerprints[pageFile] = make(map[string]string)
+		for name := range graph.Files {
+			if filterTemplate(name) {
+				// Templates named after their files are pages, layouts, and partials, not blocks
+				continue
+			}
+			files := graph.ReachableFiles(name)
+			key := strings.Join(files, "\n")
+			fingerprint, ok := fileFingerprints[key]
+			if !ok {
+				loaded, err := readConcatenated(files, templates)
+				if err != nil {
+					return nil, errors.Wrapf(
+						err, "couldn't load template files reachable from block %s of page %s", name, pageFile,
+					)
+				}
+				fingerprint = computeFingerprint(loaded)
+				fileFingerprints[key] = fingerprint
+			}
+			fragmentFingerprints[pageFile][name] = fingerprint
 		}
-		pages[file] = loadedPage
-	}
-
-	pageFingerprints := make(map[string]string)
-	for _, pageFile := range pageFiles {
-		module := path.Dir(pageFile)
-		pageFingerprints[pageFile] = computeFingerprint(append(
-			// Each page's fingerprint is computed from the page template itself as well as any non-page
-			// files (e.g. partials) within its module, recursively including all non-page files in
-			// submodules (i.e. subdirectories)
-			pages[pageFile], moduleNonpages[module]...,
-		))
 	}
-	return pageFingerprints, nil
+	return fragmentFingerprints, nil
 }
 
 // Embedded filesystem template support
@@ -113,6 +120,12 @@ type Embeds struct {
 	AppFS       fs.FS
 	AppHFS      *hashfs.FS
 	FontsFS     fs.FS
+	MessagesFS  fs.FS
+
+	// manifest is an optional prebuilt manifest of hashed asset names, set with WithManifest.
+	manifest *AssetManifest
+	// unhashedAppNames maps the hashed names of app assets in the manifest to their original names.
+	unhashedAppNames map[string]string
 }
 
 func (e Embeds) computeAppFingerprint() (fingerprint string, err error) {
@@ -124,39 +137,42 @@ func (e Embeds) computeAppFingerprint() (fingerprint string, err error) {
 	if err != nil {
 		return "", errors.Wrap(err, "couldn't list shared templates")
 	}
-	fingerprint, err = computeAppFingerprint(appAssetFiles, sharedFiles, e.TemplatesFS, e.AppFS)
+	var entries map[string]ManifestEntry
+	if e.manifest != nil {
+		entries = e.manifest.App
+	}
+	fingerprint, err = computeAppFingerprint(
+		appAssetFiles, sharedFiles, e.TemplatesFS, e.AppFS, entries,
+	)
 	if err != nil {
 		return "", errors.Wrap(err, "couldn't compute fingerprint for app")
 	}
 	return fingerprint, nil
 }
 
-func (e Embeds) computePageFingerprints() (fingerprints map[string]string, err error) {
-	moduleNonpageFiles, err := identifyModuleNonpageFiles(e.TemplatesFS)
-	if err != nil {
-		return nil, errors.Wrap(err, "couldn't list template module non-page template files")
-	}
-	pageFiles, err := listFiles(e.TemplatesFS, filterPageTemplate)
-	if err != nil {
-		return nil, errors.Wrap(err, "couldn't list template pages")
+func (e Embeds) GetAppHashedNamer(urlPrefix string) func(string) string {
+	var entries map[string]ManifestEntry
+	if e.manifest != nil {
+		entries = e.manifest.App
 	}
-
-	fingerprints, err = computePageFingerprints(moduleNonpageFiles, pageFiles, e.TemplatesFS)
-	if err != nil {
-		return nil, errors.Wrap(err, "couldn't compute fingerprint for page/module templates")
+	return func(unhashedFilename string) string {
+		return urlPrefix + lookupHashedName(entries, e.AppHFS, unhashedFilename)
 	}
-	return fingerprints, nil
 }
 
-func (e Embeds) GetAppHashedNamer(urlPrefix string) func(string) string {
-	return func(unhashedFilename string) string {
-		return urlPrefix + e.AppHFS.HashName(unhashedFilename)
-	}
+// unhashAppName returns the original name in the AppFS of the file with the (possibly hashed) name
+// produced by the namer from [Embeds.GetAppHashedNamer].
+func (e Embeds) unhashAppName(hashedFilename string) string {
+	return lookupUnhashedName(e.unhashedAppNames, e.AppHFS, hashedFilename)
 }
 
 func (e Embeds) GetStaticHashedNamer(urlPrefix string) func(string) string {
+	var entries map[string]ManifestEntry
+	if e.manifest != nil {
+		entries = e.manifest.Static
+	}
 	return func(unhashedFilename string) string {
-		return urlPrefix + e.StaticHFS.HashName(unhashedFilename)
+		return urlPrefix + lookupHashedName(entries, e.StaticHFS, unhashedFilename)
 	}
 }
 
@@ -180,7 +196,7 @@ func (i Inlines) ComputeCSSHashesForCSP() (hashes []string) {
 }
 
 func (i Inlines) ComputeJSHashesForCSP() (hashes []string) {
-	hashes = make([]string, 0, len(i.CSS))
+	hashes = make([]string, 0, len(i.JS))
 	for _, inline := range i.JS {
 		hashes = append(hashes, ComputeCSPHash([]byte(inline)))
 	}
@@ -5,31 +5,7 @@ import (
 	"io/fs"
 )
 
-// Directories
-
-func listDirectories(f fs.FS, filter func(path string) bool) ([]string, error) {
-	dirs := []string{}
-	err := fs.WalkDir(f, ".", func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if !d.IsDir() {
-			return nil
-		}
-		if filter == nil || filter(path) {
-			if path == "." {
-				dirs = append(dirs, "")
-			} else {
-				dirs = append(dirs, path)
-			}
-		}
-		return nil
-	})
-	if err != nil {
-		return nil, err
-	}
-	return dirs, nil
-}
+// Files
 
 func listFiles(f fs.FS, filter func(path string) bool) ([]string, error) {
 	files := []string{}
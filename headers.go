@@ -3,6 +3,10 @@ package godest
 import (
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type HeaderOption func(http.Header)
@@ -36,3 +40,37 @@ func WithRevalidateWhenStale(maxAge int) HeaderOption {
 		))
 	}
 }
+
+// WithVary adds the request header fields to the Vary header, skipping any fields which are already
+// listed in it, so that caches keep responses to requests with different values of those fields
+// apart.
+func WithVary(fields ...string) HeaderOption {
+	return func(h http.Header) {
+		var existing []string
+		for _, value := range h.Values("Vary") {
+			for _, field := range strings.Split(value, ",") {
+				existing = append(existing, http.CanonicalHeaderKey(strings.TrimSpace(field)))
+			}
+		}
+		for _, field := range fields {
+			if !slices.Contains(existing, http.CanonicalHeaderKey(field)) {
+				h.Add("Vary", field)
+				existing = append(existing, http.CanonicalHeaderKey(field))
+			}
+		}
+	}
+}
+
+// WithServerTiming adds a metric to the Server-Timing header, so that the time taken by the server
+// (e.g. to render a page) can be inspected in the browser's developer tools.
+func WithServerTiming(metric string, duration time.Duration, description string) HeaderOption {
+	return func(h http.Header) {
+		value := metric
+		if description != "" {
+			value += fmt.Sprintf(";desc=%q", description)
+		}
+		h.Add("Server-Timing", value+";dur="+strconv.FormatFloat(
+			float64(duration)/float64(time.Millisecond), 'f', -1, 64,
+		))
+	}
+}
@@ -32,6 +32,14 @@ var DescriptiveErrors = map[int]DescriptiveError{
 		Name:        "Bad request method",
 		Description: "The server does not support the request method for the requested resource.",
 	},
+	http.StatusConflict: {
+		Name:        "Conflict",
+		Description: "The request conflicts with the current state of the requested resource.",
+	},
+	http.StatusRequestEntityTooLarge: {
+		Name:        "Request too large",
+		Description: "The server cannot process the request because it is too large.",
+	},
 	http.StatusUnsupportedMediaType: {
 		Name:        "Bad request format",
 		Description: "The server does not support the request format.",
@@ -40,6 +48,10 @@ var DescriptiveErrors = map[int]DescriptiveError{
 		Name:        "Too busy",
 		Description: "The server has reached a temporary usage limit. Please try again later.",
 	},
+	StatusClientClosedRequest: {
+		Name:        "Request canceled",
+		Description: "The request was canceled before the server could respond to it.",
+	},
 	http.StatusInternalServerError: {
 		Name:        "Server error",
 		Description: "An unexpected problem occurred. We're working to fix it.",
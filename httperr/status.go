@@ -0,0 +1,69 @@
+package httperr
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"zombiezen.com/go/sqlite"
+)
+
+// StatusClientClosedRequest is the non-standard status code (introduced by nginx) for requests
+// which were canceled by the client before the server could respond.
+const StatusClientClosedRequest = 499
+
+// Status determines the HTTP status code for responding to a request which failed with the error:
+//   - For an echo.HTTPError, its code is used.
+//   - For a canceled context, the request was canceled by the client, so the status code is
+//     [StatusClientClosedRequest]. For an exceeded context deadline, the status code is
+//     [http.StatusServiceUnavailable].
+//   - For a database query without results, the status code is [http.StatusNotFound].
+//   - For SQLite errors, the status code is [http.StatusServiceUnavailable] if the database was
+//     busy, locked, or read-only, [http.StatusConflict] if a constraint was violated, and
+//     [http.StatusRequestEntityTooLarge] if a value was too big.
+//
+// All other errors have status code [http.StatusInternalServerError].
+func Status(err error) int {
+	if he := (*echo.HTTPError)(nil); errors.As(err, &he) {
+		return he.Code
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return StatusClientClosedRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, sql.ErrNoRows):
+		return http.StatusNotFound
+	}
+	switch sqlite.ErrCode(err).ToPrimary() {
+	case sqlite.ResultBusy, sqlite.ResultLocked, sqlite.ResultReadOnly:
+		return http.StatusServiceUnavailable
+	case sqlite.ResultConstraint:
+		return http.StatusConflict
+	case sqlite.ResultTooBig:
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Message returns the message of the error which can be shown to users. Only the messages of
+// echo.HTTPErrors for client errors (with 4xx status codes) are meant for users; messages of all
+// other errors may reveal internal details of the server, so they're only returned if internal
+// messages are allowed (e.g. in development).
+func Message(err error, allowInternal bool) string {
+	if allowInternal {
+		return err.Error()
+	}
+	he := (*echo.HTTPError)(nil)
+	if !errors.As(err, &he) || he.Code < http.StatusBadRequest ||
+		he.Code >= http.StatusInternalServerError {
+		return ""
+	}
+	if message, ok := he.Message.(string); ok {
+		return message
+	}
+	return ""
+}
@@ -0,0 +1,154 @@
+package godest
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"html/template"
+	"io/fs"
+
+	"github.com/pkg/errors"
+)
+
+// SRIAlgorithm is a hash algorithm for Subresource Integrity.
+type SRIAlgorithm string
+
+// Hash algorithms supported for Subresource Integrity. SHA-384 and SHA-512 are recommended.
+const (
+	SRISHA256 SRIAlgorithm = "sha256"
+	SRISHA384 SRIAlgorithm = "sha384"
+	SRISHA512 SRIAlgorithm = "sha512"
+)
+
+func (a SRIAlgorithm) newHash() (hash.Hash, error) {
+	switch a {
+	default:
+		return nil, errors.Errorf("unsupported subresource integrity algorithm %s", a)
+	case SRISHA384:
+		return sha512.New384(), nil
+	case SRISHA512:
+		return sha512.New(), nil
+	case SRISHA256:
+		return sha256.New(), nil
+	}
+}
+
+// ComputeSRIHash computes the Subresource Integrity metadata (e.g. for an integrity attribute) for
+// the resource, with the hash algorithm.
+func ComputeSRIHash(algorithm SRIAlgorithm, resource []byte) (string, error) {
+	h, err := algorithm.newHash()
+	if err != nil {
+		return "", err
+	}
+	_, _ = h.Write(resource) // hash.Hash's Write never returns an error
+	return string(algorithm) + "-" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// computeIntegrities computes the Subresource Integrity metadata of every file in the filesystem,
+// keyed by unhashed file path.
+func computeIntegrities(
+	fsys fs.FS, algorithm SRIAlgorithm,
+) (integrities map[string]string, err error) {
+	integrities = make(map[string]string)
+	if fsys == nil {
+		return integrities, nil
+	}
+	files, err := listFiles(fsys, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't list files")
+	}
+	for _, file := range files {
+		data, err := readFile(file, fsys)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't load %s for computing its integrity", file)
+		}
+		if integrities[file], err = ComputeSRIHash(algorithm, data); err != nil {
+			return nil, errors.Wrapf(err, "couldn't compute integrity of %s", file)
+		}
+	}
+	return integrities, nil
+}
+
+// HashedAsset is the hashed URL of an asset together with its Subresource Integrity metadata, for
+// use in the src/href and integrity attributes of script and link tags.
+type HashedAsset struct {
+	URL       string
+	Integrity string
+}
+
+// HashedAssetNamer returns the [HashedAsset] for the unhashed filename of an asset.
+type HashedAssetNamer func(unhashedFilename string) (HashedAsset, error)
+
+func newHashedAssetNamer(
+	hashedNamer func(string) string, integrities map[string]string,
+) HashedAssetNamer {
+	return func(unhashedFilename string) (HashedAsset, error) {
+		integrity, ok := integrities[unhashedFilename]
+		if !ok {
+			return HashedAsset{}, errors.Errorf("couldn't find asset %s", unhashedFilename)
+		}
+		return HashedAsset{
+			URL:       hashedNamer(unhashedFilename),
+			Integrity: integrity,
+		}, nil
+	}
+}
+
+// AssetIntegrities holds the Subresource Integrity metadata of all files in the Embeds' AppFS and
+// StaticFS.
+type AssetIntegrities struct {
+	Algorithm SRIAlgorithm
+	App       map[string]string
+	Static    map[string]string
+}
+
+// ComputeAssetIntegrities computes the Subresource Integrity metadata of all files in the Embeds'
+// AppFS and StaticFS with the hash algorithm. This should be done once at startup, like the
+// computation of the app fingerprint for [TemplateRenderer]. If the Embeds has a prebuilt manifest
+// (see [Embeds.WithManifest]) computed with the same hash algorithm, the integrities are taken
+// from the manifest instead.
+func (e Embeds) ComputeAssetIntegrities(algorithm SRIAlgorithm) (i AssetIntegrities, err error) {
+	i.Algorithm = algorithm
+	if e.manifest != nil && e.manifest.Algorithm == algorithm {
+		i.App = manifestIntegrities(e.manifest.App)
+		i.Static = manifestIntegrities(e.manifest.Static)
+		return i, nil
+	}
+	if i.App, err = computeIntegrities(e.AppFS, algorithm); err != nil {
+		return AssetIntegrities{}, errors.Wrap(err, "couldn't compute integrities of app assets")
+	}
+	if i.Static, err = computeIntegrities(e.StaticFS, algorithm); err != nil {
+		return AssetIntegrities{}, errors.Wrap(err, "couldn't compute integrities of static assets")
+	}
+	return i, nil
+}
+
+// GetAppHashedAssetNamer is like [Embeds.GetAppHashedNamer], except that the namer also returns
+// the Subresource Integrity metadata of the asset.
+func (e Embeds) GetAppHashedAssetNamer(urlPrefix string, i AssetIntegrities) HashedAssetNamer {
+	return newHashedAssetNamer(e.GetAppHashedNamer(urlPrefix), i.App)
+}
+
+// GetStaticHashedAssetNamer is like [Embeds.GetStaticHashedNamer], except that the namer also
+// returns the Subresource Integrity metadata of the asset.
+func (e Embeds) GetStaticHashedAssetNamer(urlPrefix string, i AssetIntegrities) HashedAssetNamer {
+	return newHashedAssetNamer(e.GetStaticHashedNamer(urlPrefix), i.Static)
+}
+
+// NewIntegrityFuncs computes the Subresource Integrity metadata of all files in the Embeds' AppFS
+// and StaticFS, and returns template functions appAsset and staticAsset which return the
+// [HashedAsset] for an asset, e.g.
+// `{{$js := appAsset "app.js"}}<script src="{{$js.URL}}" integrity="{{$js.Integrity}}"></script>`.
+func (e Embeds) NewIntegrityFuncs(
+	appURLPrefix, staticURLPrefix string, algorithm SRIAlgorithm,
+) (template.FuncMap, error) {
+	i, err := e.ComputeAssetIntegrities(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return template.FuncMap{
+		"appAsset":    e.GetAppHashedAssetNamer(appURLPrefix, i),
+		"staticAsset": e.GetStaticHashedAssetNamer(staticURLPrefix, i),
+	}, nil
+}
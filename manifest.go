@@ -0,0 +1,181 @@
+package godest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+
+	"github.com/benbjohnson/hashfs"
+	"github.com/pkg/errors"
+)
+
+// ManifestEntry describes an asset in an [AssetManifest].
+type ManifestEntry struct {
+	HashedPath  string `json:"hashedPath"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"contentType"`
+	Integrity   string `json:"integrity"`
+}
+
+// AssetManifest lists the hashed names and other metadata of all assets in the Embeds' AppFS and
+// StaticFS, keyed by original (unhashed) path. It can be computed at build time and embedded into
+// the server binary, so that assets don't need to be hashed when the server starts. Hashed paths
+// follow the naming scheme of hashfs, so they can still be served by [HandleFSFileRevved].
+type AssetManifest struct {
+	Algorithm SRIAlgorithm             `json:"algorithm"`
+	App       map[string]ManifestEntry `json:"app"`
+	Static    map[string]ManifestEntry `json:"static"`
+}
+
+func computeManifestEntries(
+	fsys fs.FS, hfs *hashfs.FS, algorithm SRIAlgorithm,
+) (entries map[string]ManifestEntry, err error) {
+	entries = make(map[string]ManifestEntry)
+	if fsys == nil {
+		return entries, nil
+	}
+	if hfs == nil {
+		hfs = hashfs.NewFS(fsys)
+	}
+	files, err := listFiles(fsys, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't list files")
+	}
+	for _, file := range files {
+		data, err := readFile(file, fsys)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't load %s for the asset manifest", file)
+		}
+		integrity, err := ComputeSRIHash(algorithm, data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't compute integrity of %s", file)
+		}
+		contentType := mime.TypeByExtension(path.Ext(file))
+		if contentType == "" {
+			contentType = http.DetectContentType(data)
+		}
+		entries[file] = ManifestEntry{
+			HashedPath:  hfs.HashName(file),
+			Size:        int64(len(data)),
+			ContentType: contentType,
+			Integrity:   integrity,
+		}
+	}
+	return entries, nil
+}
+
+// ComputeAssetManifest computes the [AssetManifest] of the Embeds' AppFS and StaticFS, with
+// Subresource Integrity metadata computed with the hash algorithm.
+func (e Embeds) ComputeAssetManifest(algorithm SRIAlgorithm) (m AssetManifest, err error) {
+	m.Algorithm = algorithm
+	if m.App, err = computeManifestEntries(e.AppFS, e.AppHFS, algorithm); err != nil {
+		return AssetManifest{}, errors.Wrap(err, "couldn't compute manifest of app assets")
+	}
+	if m.Static, err = computeManifestEntries(e.StaticFS, e.StaticHFS, algorithm); err != nil {
+		return AssetManifest{}, errors.Wrap(err, "couldn't compute manifest of static assets")
+	}
+	return m, nil
+}
+
+// WriteAssetManifest writes the manifest as JSON, e.g. to a file at build time.
+func WriteAssetManifest(w io.Writer, m AssetManifest) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return errors.Wrap(encoder.Encode(m), "couldn't encode asset manifest")
+}
+
+// ReadAssetManifest reads a manifest from a JSON file in the filesystem.
+func ReadAssetManifest(fsys fs.FS, filename string) (m AssetManifest, err error) {
+	data, err := readFile(filename, fsys)
+	if err != nil {
+		return AssetManifest{}, errors.Wrapf(err, "couldn't read asset manifest %s", filename)
+	}
+	if err = json.Unmarshal(data, &m); err != nil {
+		return AssetManifest{}, errors.Wrapf(err, "couldn't parse asset manifest %s", filename)
+	}
+	return m, nil
+}
+
+// WithManifest returns a copy of the Embeds which uses the prebuilt manifest to look up hashed
+// asset names (e.g. in the namers from [Embeds.GetAppHashedNamer] and
+// [Embeds.GetStaticHashedNamer]), asset integrities (e.g. in [Embeds.ComputeAssetIntegrities]), and
+// the contributions of app assets to the app fingerprint of [TemplateRenderer], instead of hashing
+// assets when the server starts. Assets missing from the manifest are hashed as usual.
+func (e Embeds) WithManifest(m AssetManifest) Embeds {
+	e.manifest = &m
+	e.unhashedAppNames = make(map[string]string, len(m.App))
+	for file, entry := range m.App {
+		e.unhashedAppNames[entry.HashedPath] = file
+	}
+	return e
+}
+
+// lookupHashedName looks up the hashed name of an asset in the manifest entries, falling back to
+// hashing the asset. If there is no hashed filesystem to hash the asset with, the unhashed name is
+// returned.
+func lookupHashedName(
+	entries map[string]ManifestEntry, hfs *hashfs.FS, unhashedFilename string,
+) string {
+	if entry, ok := entries[unhashedFilename]; ok {
+		return entry.HashedPath
+	}
+	if hfs == nil {
+		return unhashedFilename
+	}
+	return hfs.HashName(unhashedFilename)
+}
+
+// lookupUnhashedName is the inverse of lookupHashedName: it returns the original name of the file
+// with the hashed name, or the name itself if it isn't a hashed name. The unhashed names map the
+// hashed names of the manifest entries to their original names.
+func lookupUnhashedName(
+	unhashedNames map[string]string, hfs *hashfs.FS, hashedFilename string,
+) string {
+	if file, ok := unhashedNames[hashedFilename]; ok {
+		return file
+	}
+	if hfs == nil {
+		return hashedFilename
+	}
+	base, hash := hfs.ParseName(hashedFilename)
+	if hash == "" || hfs.HashName(base) != hashedFilename {
+		return hashedFilename
+	}
+	return base
+}
+
+// manifestIntegrities returns the integrities from the manifest entries.
+func manifestIntegrities(entries map[string]ManifestEntry) map[string]string {
+	integrities := make(map[string]string, len(entries))
+	for file, entry := range entries {
+		integrities[file] = entry.Integrity
+	}
+	return integrities
+}
+
+// HandleAssetManifest serves the manifest as JSON (e.g. for a CDN or a service worker), with an
+// etag so that clients can cheaply check whether the manifest has changed.
+func HandleAssetManifest(m AssetManifest) (http.Handler, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't encode asset manifest")
+	}
+	etag := fmt.Sprintf("%q", computeFingerprint(data))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, no-cache")
+		w.Header().Set("Etag", etag)
+		if checkEtagMatch(r.Header, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(data)
+		}
+	}), nil
+}
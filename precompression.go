@@ -0,0 +1,254 @@
+package godest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/benbjohnson/hashfs"
+	"github.com/pkg/errors"
+)
+
+// Content codings
+
+const (
+	encodingBrotli   = "br"
+	encodingGzip     = "gzip"
+	encodingIdentity = "identity"
+)
+
+// precompressedEncodings lists the supported content codings in order of preference, along with
+// the file extensions of build-time precompressed files for those codings.
+var precompressedEncodings = []struct {
+	name string
+	ext  string
+}{
+	{name: encodingBrotli, ext: ".br"},
+	{name: encodingGzip, ext: ".gz"},
+}
+
+// Compressible File Filters
+
+// compressibleFileExts lists the file extensions of assets which are worth compressing. Formats
+// which are already compressed (e.g. most image formats, WOFF fonts) are excluded.
+var compressibleFileExts = []string{
+	".css", ".js", ".mjs", ".map", ".json", ".webmanifest", ".html", ".xml", ".txt", ".svg",
+	".wasm", ".ttf", ".otf", ".eot", ".ico",
+}
+
+func filterCompressibleAsset(filePath string) bool {
+	return slices.Contains(compressibleFileExts, path.Ext(filePath))
+}
+
+// Compression
+
+func compressBrotli(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := brotli.NewWriterLevel(&buf, brotli.BestCompression)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func compressGzip(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func compress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	default:
+		return nil, errors.Errorf("unsupported content coding %s", encoding)
+	case encodingBrotli:
+		return compressBrotli(data)
+	case encodingGzip:
+		return compressGzip(data)
+	}
+}
+
+// Precompressed filesystem
+
+// precompressedFile holds the compressed variants of a file.
+type precompressedFile struct {
+	contentType string
+	variants    map[string][]byte
+}
+
+// PrecompressedFS serves files from a filesystem (usually an embedded filesystem, with hashed file
+// names) using Brotli- and gzip-compressed variants of compressible files which were produced in
+// advance, so that files don't need to be compressed upon each request.
+type PrecompressedFS struct {
+	hfs   *hashfs.FS
+	files map[string]precompressedFile
+}
+
+// Precompress produces Brotli and gzip variants of all compressible files in the filesystem. If
+// the filesystem already has a precompressed variant of a file (e.g. "app.js.br" or "app.js.gz"
+// for "app.js", as produced at build time), that variant is used instead of compressing the file
+// at startup. Variants which would not be smaller than the uncompressed file are discarded. If the
+// filesystem is a [hashfs.FS], requests for hashed file names are served with the precompressed
+// variants of the corresponding files, so hashed names stay stable.
+func Precompress(fsys fs.FS) (*PrecompressedFS, error) {
+	hfs, ok := fsys.(*hashfs.FS)
+	if !ok {
+		hfs = hashfs.NewFS(fsys)
+	}
+	p := &PrecompressedFS{
+		hfs:   hfs,
+		files: make(map[string]precompressedFile),
+	}
+	files, err := listFiles(fsys, filterCompressibleAsset)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't list compressible files")
+	}
+	for _, file := range files {
+		data, err := readFile(file, fsys)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't load %s for precompression", file)
+		}
+		variants := make(map[string][]byte)
+		for _, encoding := range precompressedEncodings {
+			compressed, err := fs.ReadFile(fsys, file+encoding.ext)
+			if errors.Is(err, fs.ErrNotExist) {
+				compressed, err = compress(encoding.name, data)
+			}
+			if err != nil {
+				return nil, errors.Wrapf(err, "couldn't make %s variant of %s", encoding.name, file)
+			}
+			if len(compressed) < len(data) {
+				variants[encoding.name] = compressed
+			}
+		}
+		if len(variants) == 0 {
+			continue
+		}
+
+		contentType := mime.TypeByExtension(path.Ext(file))
+		if contentType == "" {
+			contentType = http.DetectContentType(data)
+		}
+		p.files[file] = precompressedFile{
+			contentType: contentType,
+			variants:    variants,
+		}
+	}
+	return p, nil
+}
+
+// Content negotiation
+
+// parseAcceptEncoding parses the Accept-Encoding header into the quality values of the content
+// codings.
+func parseAcceptEncoding(h http.Header) map[string]float64 {
+	qualities := make(map[string]float64)
+	for _, value := range h.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(coding), ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			q := 1.0
+			if rawQ, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				parsed, err := strconv.ParseFloat(rawQ, 64)
+				if err != nil {
+					continue
+				}
+				q = parsed
+			}
+			qualities[name] = q
+		}
+	}
+	return qualities
+}
+
+// negotiateEncoding chooses the most-preferred available content coding accepted by the request,
+// or an empty string if only the identity coding should be used.
+func negotiateEncoding(h http.Header, available map[string][]byte) string {
+	qualities := parseAcceptEncoding(h)
+	best, bestQ := "", 0.0
+	for _, encoding := range precompressedEncodings {
+		if _, ok := available[encoding.name]; !ok {
+			continue
+		}
+		q, ok := qualities[encoding.name]
+		if !ok {
+			q, ok = qualities["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding.name, q
+		}
+	}
+	if identityQ, ok := qualities[encodingIdentity]; ok && identityQ > bestQ {
+		return ""
+	}
+	return best
+}
+
+// Serving
+
+func (p *PrecompressedFS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	filename := path.Clean(strings.TrimPrefix(r.URL.Path, "/"))
+	base, hash := p.hfs.ParseName(filename)
+	if hash == "" || p.hfs.HashName(base) != filename {
+		base, hash = filename, ""
+	}
+	file, ok := p.files[base]
+	if !ok {
+		hashfs.FileServer(p.hfs).ServeHTTP(w, r)
+		return
+	}
+
+	WithVary("Accept-Encoding")(w.Header())
+	encoding := negotiateEncoding(r.Header, file.variants)
+	if encoding == "" {
+		hashfs.FileServer(p.hfs).ServeHTTP(w, r)
+		return
+	}
+
+	variant := file.variants[encoding]
+	if hash != "" {
+		// Each content coding is a different representation, so it needs a different etag
+		etag := "\"" + hash + "-" + encoding + "\""
+		w.Header().Set("Cache-Control", "public, max-age=31536000")
+		w.Header().Set("ETag", etag)
+		if checkEtagMatch(r.Header, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", file.contentType)
+	w.Header().Set("Content-Encoding", encoding)
+	w.Header().Set("Content-Length", strconv.Itoa(len(variant)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(variant)
+	}
+}
+
+// HandleFSPrecompressed is like [HandleFSFileRevved], except that it serves precompressed variants
+// of compressible files to clients which accept them.
+func HandleFSPrecompressed(routePrefix string, p *PrecompressedFS) http.Handler {
+	return http.StripPrefix(routePrefix, p)
+}
@@ -0,0 +1,106 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Backplane
+
+// BackplaneMessage is the record of a broadcast forwarded between processes over a [Backplane].
+type BackplaneMessage struct {
+	// Origin is the ID of the [Hub] which broadcast the message.
+	Origin string
+	Topic  string
+	// Payload is the marshaled message.
+	Payload []byte
+}
+
+// Backplane forwards broadcasts between the Hubs of multiple processes (e.g. multiple replicas of
+// an app behind a load balancer), so that messages broadcast on one process also reach subscribers
+// on the other processes. Implementations must be safe for concurrent use.
+type Backplane interface {
+	// Publish forwards the message to all Hubs subscribed to the backplane.
+	Publish(ctx context.Context, message BackplaneMessage) error
+	// Subscribe calls the receive callback function on every message published on the backplane
+	// (including messages published by the subscriber itself) after Subscribe was called, blocking
+	// until the context is canceled or the backplane fails.
+	Subscribe(ctx context.Context, receive func(message BackplaneMessage)) error
+}
+
+// Memory backplane
+
+// memorySubscriberBufferSize is the number of messages which can be queued for delivery to each
+// subscriber of a [MemoryBackplane] before publishers are blocked.
+const memorySubscriberBufferSize = 64
+
+// memorySubscriber is the record stored for each subscriber of a [MemoryBackplane].
+type memorySubscriber struct {
+	messages chan BackplaneMessage
+	done     chan struct{}
+}
+
+// MemoryBackplane is an in-process reference implementation of [Backplane], e.g. for connecting
+// the Hubs of multiple brokers in the same process, or for testing.
+type MemoryBackplane struct {
+	subscribers map[*memorySubscriber]struct{}
+	mu          sync.RWMutex
+}
+
+// NewMemoryBackplane creates a [MemoryBackplane].
+func NewMemoryBackplane() *MemoryBackplane {
+	return &MemoryBackplane{
+		subscribers: make(map[*memorySubscriber]struct{}),
+	}
+}
+
+// Publish enqueues the message for delivery to all subscribers of the backplane, blocking until the
+// message is added to the queues of all subscribers or until the context is canceled.
+func (b *MemoryBackplane) Publish(ctx context.Context, message BackplaneMessage) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subscribers {
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "couldn't publish message on topic %s", message.Topic)
+		case <-sub.done:
+			// The subscriber is being removed, so it no longer needs the message
+		case sub.messages <- message:
+		}
+	}
+	return nil
+}
+
+// Subscribe calls the receive callback function on every message published on the backplane,
+// until the context is canceled.
+func (b *MemoryBackplane) Subscribe(
+	ctx context.Context, receive func(message BackplaneMessage),
+) error {
+	sub := &memorySubscriber{
+		messages: make(chan BackplaneMessage, memorySubscriberBufferSize),
+		done:     make(chan struct{}),
+	}
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+	defer func() {
+		// We unblock any publishers before removing the subscriber, since publishers hold a read lock
+		close(sub.done)
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.subscribers, sub)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case message := <-sub.messages:
+			receive(message)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package pubsub_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/sargassum-world/godest/marshaling"
+	"github.com/sargassum-world/godest/pubsub"
+)
+
+// subscribeTo subscribes to the topic on the Hub and returns the channel of received messages.
+func subscribeTo(
+	ctx context.Context, h *pubsub.Hub[string], topic string,
+) <-chan string {
+	received := make(chan string, 16)
+	h.Subscribe(ctx, topic, func(message string) error {
+		received <- message
+		return nil
+	})
+	return received
+}
+
+// receiveUntil collects the messages received on the channel up to and including the last
+// message.
+func receiveUntil(
+	ctx context.Context, t *testing.T, received <-chan string, last string,
+) (messages []string) {
+	t.Helper()
+
+	for {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for message %q after receiving %q", last, messages)
+		case message := <-received:
+			messages = append(messages, message)
+			if message == last {
+				return messages
+			}
+		}
+	}
+}
+
+// awaitForwarding waits until broadcasts on the sender Hub are forwarded to the receiver Hub, since
+// each Hub only starts receiving forwarded messages some time after it starts serving the
+// backplane.
+func awaitForwarding(ctx context.Context, t *testing.T, sender, receiver *pubsub.Hub[string]) {
+	t.Helper()
+
+	const topic = "ready"
+	received := subscribeTo(ctx, receiver, topic)
+	for {
+		sender.Broadcast(topic, "ping")
+		select {
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for backplane forwarding")
+		case <-received:
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestMemoryBackplaneForwarding(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	backplane := pubsub.NewMemoryBackplane()
+	logger := echo.New().Logger
+	hubs := []*pubsub.Hub[string]{
+		pubsub.NewHub[string](nil, logger), pubsub.NewHub[string](nil, logger),
+	}
+	for _, h := range hubs {
+		t.Cleanup(h.Close)
+		go func() {
+			_ = h.ServeBackplane(ctx, backplane, marshaling.JSON{})
+		}()
+	}
+	awaitForwarding(ctx, t, hubs[0], hubs[1])
+	awaitForwarding(ctx, t, hubs[1], hubs[0])
+
+	const topic = "topic"
+	received := []<-chan string{subscribeTo(ctx, hubs[0], topic), subscribeTo(ctx, hubs[1], topic)}
+
+	// Because the backplane delivers messages to each Hub in order, any echo of a broadcast back to
+	// its own Hub would be received before a later broadcast from the other Hub
+	hubs[0].Broadcast(topic, "forwarded")
+	hubs[1].Broadcast(topic, "end of forwarded")
+	if got, want := receiveUntil(ctx, t, received[0], "end of forwarded"), []string{
+		"forwarded", "end of forwarded",
+	}; !slices.Equal(got, want) {
+		t.Errorf("origin hub received %q, want %q", got, want)
+	}
+	// The other Hub may emit the two broadcasts in either order, since only one was forwarded
+	got := []string{<-received[1], <-received[1]}
+	slices.Sort(got)
+	if want := []string{"end of forwarded", "forwarded"}; !slices.Equal(got, want) {
+		t.Errorf("other hub received %q, want %q", got, want)
+	}
+
+	hubs[0].BroadcastLocal(topic, "local")
+	hubs[0].Broadcast(topic, "end of local")
+	if got, want := receiveUntil(ctx, t, received[0], "end of local"), []string{
+		"local", "end of local",
+	}; !slices.Equal(got, want) {
+		t.Errorf("origin hub received %q, want %q", got, want)
+	}
+	if got, want := receiveUntil(ctx, t, received[1], "end of local"), []string{
+		"end of local",
+	}; !slices.Equal(got, want) {
+		t.Errorf("other hub received %q, want %q", got, want)
+	}
+}
@@ -112,13 +112,15 @@ func (c *BrokerContext[HandlerContext, Message]) Hub() *Hub[[]Message] {
 }
 
 // Publish broadcasts the messages over the associated broker's pub-sub [Hub], on the same topic
-// as the BrokerContext itself.
+// as the BrokerContext itself. The messages are not forwarded over the Hub's [Backplane] (see
+// [Hub.BroadcastLocal]), since PUB handlers are run in every process with subscriptions on their
+// topics, so each process already publishes its own messages.
 func (c *BrokerContext[HandlerContext, Message]) Publish(messages ...Message) {
-	c.hub.Broadcast(c.topic, messages)
+	c.hub.BroadcastLocal(c.topic, messages)
 }
 
 // Broadcast broadcasts the messages over the associated broker's pub-sub [Hub], on the specified
-// topic.
+// topic. The messages are also forwarded over the Hub's [Backplane], if it has one.
 func (c *BrokerContext[HandlerContext, Message]) Broadcast(topic string, messages ...Message) {
 	c.hub.Broadcast(topic, messages)
 }
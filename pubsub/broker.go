@@ -5,6 +5,8 @@ import (
 	"net/url"
 
 	"github.com/pkg/errors"
+
+	"github.com/sargassum-world/godest/marshaling"
 )
 
 // Broker is the top-level pub-sub framework for routing pub-sub events to handlers. Analogous to
@@ -202,21 +204,38 @@ func (b *Broker[HandlerContext, Message]) CancelPub(topic string) {
 func (b *Broker[HandlerContext, Message]) Subscribe(
 	ctx context.Context, topic string, hc HandlerContextMaker[HandlerContext, Message],
 	broadcastHandler func(ctx context.Context, messages []Message) error,
+) (finished <-chan struct{}) {
+	return b.SubscribeWithPosition(
+		ctx, topic, hc,
+		func(ctx context.Context, messages []Message, _ StreamPosition) error {
+			return broadcastHandler(ctx, messages)
+		},
+	)
+}
+
+// SubscribeWithPosition runs the SUB handler for the topic and adds a subscription like
+// [Broker.Subscribe], but with a broadcast handler callback function which is also given the
+// position of the messages in the [History] of the broker's Hub.
+func (b *Broker[HandlerContext, Message]) SubscribeWithPosition(
+	ctx context.Context, topic string, hc HandlerContextMaker[HandlerContext, Message],
+	broadcastHandler func(ctx context.Context, messages []Message, position StreamPosition) error,
 ) (finished <-chan struct{}) {
 	if err := b.TriggerSub(ctx, topic, hc); err != nil {
 		return nil
 	}
 	cctx, cancel := context.WithCancel(ctx)
-	removed := b.hub.Subscribe(cctx, topic, func(messages []Message) error {
-		if err := cctx.Err(); err != nil {
-			return err
-		}
-		if err := broadcastHandler(cctx, messages); err != nil {
-			cancel()
-			return err
-		}
-		return nil
-	})
+	removed := b.hub.SubscribeWithPosition(
+		cctx, topic, func(messages []Message, position StreamPosition) error {
+			if err := cctx.Err(); err != nil {
+				return err
+			}
+			if err := broadcastHandler(cctx, messages, position); err != nil {
+				cancel()
+				return err
+			}
+			return nil
+		},
+	)
 	go func() {
 		<-removed
 		b.TriggerUnsub(cctx, topic, hc)
@@ -256,6 +275,15 @@ func (b *Broker[HandlerContext, Message]) Serve(
 	return ctx.Err()
 }
 
+// ServeBackplane forwards broadcasts between the broker's [Hub] and the Hubs of other processes
+// subscribed to the backplane, blocking until the context is canceled or the backplane fails.
+// Refer to [Hub.ServeBackplane] for details.
+func (b *Broker[HandlerContext, Message]) ServeBackplane(
+	ctx context.Context, backplane Backplane, marshaler marshaling.Marshaler,
+) error {
+	return b.hub.ServeBackplane(ctx, backplane, marshaler)
+}
+
 // Router Interface
 
 // Router is the subset of [Broker] methods for adding handlers to routes.
@@ -0,0 +1,180 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// History
+
+// StreamPosition identifies the position of a message in the history of a topic. Offsets of
+// messages on a topic start at 1 and increase by 1 with each message; they're only comparable
+// between positions with the same epoch, which identifies a history (e.g. a new epoch begins when
+// an in-memory history is created, since any previous history was lost).
+type StreamPosition struct {
+	Epoch  string
+	Offset uint64
+}
+
+// HistoryEntry is the record of a message broadcast on a topic.
+type HistoryEntry[Message any] struct {
+	Position StreamPosition
+	Time     time.Time
+	Message  Message
+}
+
+// ErrHistoryUnavailable is returned (possibly wrapped) by the methods of a [History] when the
+// messages after a position can't be provided, e.g. because the position has a different epoch or
+// because some of the messages were already deleted from the history.
+var ErrHistoryUnavailable = errors.New("history unavailable")
+
+// History records messages broadcast on each topic of a [Hub], so that subscribers which missed
+// messages (e.g. because their connection was interrupted) can look them up. Implementations must
+// be safe for concurrent use.
+type History[Message any] interface {
+	// Append records the message broadcast on the topic, and returns the message's entry.
+	Append(ctx context.Context, topic string, message Message) (HistoryEntry[Message], error)
+	// After returns the entries of all messages on the topic after the position, in order. It
+	// returns [ErrHistoryUnavailable] if the history can't provide all of those messages.
+	After(
+		ctx context.Context, topic string, position StreamPosition,
+	) ([]HistoryEntry[Message], error)
+	// Since returns the entries of all messages on the topic which were recorded at or after the
+	// time and are still kept in the history, in order.
+	Since(ctx context.Context, topic string, t time.Time) ([]HistoryEntry[Message], error)
+}
+
+// Memory history
+
+// MemoryHistory is a [History] which keeps a bounded number of recent messages for each topic in
+// memory. Its epoch is randomly generated when it's created.
+type MemoryHistory[Message any] struct {
+	epoch     string
+	limit     int
+	retention time.Duration
+
+	mu     sync.Mutex
+	topics map[string]*memoryTopicHistory[Message]
+}
+
+type memoryTopicHistory[Message any] struct {
+	entries    []HistoryEntry[Message]
+	lastOffset uint64
+}
+
+// NewMemoryHistory creates a [MemoryHistory] which keeps up to the limit of messages for each
+// topic, for up to the retention duration (or indefinitely, if the retention duration is zero).
+func NewMemoryHistory[Message any](limit int, retention time.Duration) *MemoryHistory[Message] {
+	return &MemoryHistory[Message]{
+		epoch:     rand.Text(),
+		limit:     max(limit, 1),
+		retention: retention,
+		topics:    make(map[string]*memoryTopicHistory[Message]),
+	}
+}
+
+// Epoch returns the epoch of the history.
+func (h *MemoryHistory[Message]) Epoch() string {
+	return h.epoch
+}
+
+// expire removes entries past the retention duration from the topic's history.
+func (h *MemoryHistory[Message]) expire(th *memoryTopicHistory[Message], now time.Time) {
+	if h.retention == 0 {
+		return
+	}
+	expired := 0
+	for expired < len(th.entries) && now.Sub(th.entries[expired].Time) > h.retention {
+		expired++
+	}
+	th.entries = th.entries[expired:]
+}
+
+// Append records the message broadcast on the topic, removing the topic's oldest message if the
+// limit was reached.
+func (h *MemoryHistory[Message]) Append(
+	_ context.Context, topic string, message Message,
+) (HistoryEntry[Message], error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	th, ok := h.topics[topic]
+	if !ok {
+		th = &memoryTopicHistory[Message]{}
+		h.topics[topic] = th
+	}
+	now := time.Now()
+	h.expire(th, now)
+	th.lastOffset++
+	entry := HistoryEntry[Message]{
+		Position: StreamPosition{Epoch: h.epoch, Offset: th.lastOffset},
+		Time:     now,
+		Message:  message,
+	}
+	if len(th.entries) >= h.limit {
+		th.entries = th.entries[len(th.entries)-h.limit+1:]
+	}
+	th.entries = append(th.entries, entry)
+	return entry, nil
+}
+
+// After returns the entries of all messages on the topic after the position.
+func (h *MemoryHistory[Message]) After(
+	_ context.Context, topic string, position StreamPosition,
+) ([]HistoryEntry[Message], error) {
+	if position.Epoch != h.epoch {
+		return nil, errors.Wrapf(ErrHistoryUnavailable, "unknown epoch %s", position.Epoch)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	th, ok := h.topics[topic]
+	if !ok {
+		if position.Offset > 0 {
+			return nil, errors.Wrapf(ErrHistoryUnavailable, "no messages on topic %s", topic)
+		}
+		return nil, nil
+	}
+	h.expire(th, time.Now())
+	if position.Offset > th.lastOffset {
+		return nil, errors.Wrapf(
+			ErrHistoryUnavailable, "offset %d is past last offset %d", position.Offset, th.lastOffset,
+		)
+	}
+	firstOffset := th.lastOffset - uint64(len(th.entries)) + 1
+	if position.Offset+1 < firstOffset {
+		return nil, errors.Wrapf(
+			ErrHistoryUnavailable, "messages after offset %d were already deleted", position.Offset,
+		)
+	}
+	return append(
+		[]HistoryEntry[Message](nil), th.entries[position.Offset+1-firstOffset:]...,
+	), nil
+}
+
+// Since returns the entries of all messages on the topic which were recorded at or after the
+// time.
+func (h *MemoryHistory[Message]) Since(
+	_ context.Context, topic string, t time.Time,
+) ([]HistoryEntry[Message], error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	th, ok := h.topics[topic]
+	if !ok {
+		return nil, nil
+	}
+	h.expire(th, time.Now())
+	entries := make([]HistoryEntry[Message], 0, len(th.entries))
+	for _, entry := range th.entries {
+		if !entry.Time.Before(t) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,109 @@
+package pubsub_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/sargassum-world/godest/pubsub"
+)
+
+func TestMemoryHistoryAfter(t *testing.T) {
+	const limit = 3
+	h := pubsub.NewMemoryHistory[string](limit, 0)
+	ctx := context.Background()
+	// Offsets 1 and 2 are evicted by the limit, so only offsets 3 to 5 are kept
+	for _, message := range []string{"a", "b", "c", "d", "e"} {
+		if _, err := h.Append(ctx, "topic", message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name     string
+		topic    string
+		position pubsub.StreamPosition
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "offset 0 of unknown topic",
+			topic:    "other",
+			position: pubsub.StreamPosition{Epoch: h.Epoch()},
+		},
+		{
+			name:     "offset 0 after evictions",
+			topic:    "topic",
+			position: pubsub.StreamPosition{Epoch: h.Epoch()},
+			wantErr:  true,
+		},
+		{
+			name:     "below evicted entries",
+			topic:    "topic",
+			position: pubsub.StreamPosition{Epoch: h.Epoch(), Offset: 1},
+			wantErr:  true,
+		},
+		{
+			name:     "just before first kept entry",
+			topic:    "topic",
+			position: pubsub.StreamPosition{Epoch: h.Epoch(), Offset: 2},
+			want:     []string{"c", "d", "e"},
+		},
+		{
+			name:     "within kept entries",
+			topic:    "topic",
+			position: pubsub.StreamPosition{Epoch: h.Epoch(), Offset: 3},
+			want:     []string{"d", "e"},
+		},
+		{
+			name:     "last offset",
+			topic:    "topic",
+			position: pubsub.StreamPosition{Epoch: h.Epoch(), Offset: 5},
+		},
+		{
+			name:     "past last offset",
+			topic:    "topic",
+			position: pubsub.StreamPosition{Epoch: h.Epoch(), Offset: 6},
+			wantErr:  true,
+		},
+		{
+			name:     "past last offset of unknown topic",
+			topic:    "other",
+			position: pubsub.StreamPosition{Epoch: h.Epoch(), Offset: 1},
+			wantErr:  true,
+		},
+		{
+			name:     "unknown epoch",
+			topic:    "topic",
+			position: pubsub.StreamPosition{Epoch: "unknown", Offset: 3},
+			wantErr:  true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			entries, err := h.After(ctx, c.topic, c.position)
+			if c.wantErr {
+				if !errors.Is(err, pubsub.ErrHistoryUnavailable) {
+					t.Fatalf("got error %v, want %v", err, pubsub.ErrHistoryUnavailable)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(c.want) {
+				t.Fatalf("got %d entries, want %d", len(entries), len(c.want))
+			}
+			for i, entry := range entries {
+				if entry.Message != c.want[i] {
+					t.Errorf("entry %d: got message %q, want %q", i, entry.Message, c.want[i])
+				}
+				wantOffset := c.position.Offset + uint64(i) + 1
+				if entry.Position != (pubsub.StreamPosition{Epoch: h.Epoch(), Offset: wantOffset}) {
+					t.Errorf("entry %d: got position %+v, want offset %d", i, entry.Position, wantOffset)
+				}
+			}
+		})
+	}
+}
@@ -6,9 +6,13 @@ package pubsub
 
 import (
 	"context"
+	"crypto/rand"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
+
+	"github.com/sargassum-world/godest/marshaling"
 )
 
 // Receiver
@@ -16,10 +20,15 @@ import (
 // ReceiveFunc is the callback function used to handle each message emitted over a subscription.
 type ReceiveFunc[Message any] func(message Message) error
 
+// PositionedReceiveFunc is the callback function used to handle each message emitted over a
+// subscription, together with the position of the message in the [History] of the [Hub]. If the
+// Hub has no History, the position is the zero value.
+type PositionedReceiveFunc[Message any] func(message Message, position StreamPosition) error
+
 // subscription is the record stored for each active subscription.
 type subscription[Message any] struct {
 	topic   string
-	receive ReceiveFunc[Message]
+	receive PositionedReceiveFunc[Message]
 	cancel  context.CancelFunc
 }
 
@@ -40,6 +49,12 @@ type Hub[Message any] struct {
 	mu            sync.RWMutex
 	brChanges     chan<- BroadcastingChange
 	logger        Logger
+
+	// Backplane forwarding and history, which are protected by mu
+	origin    string
+	backplane Backplane
+	marshaler marshaling.Marshaler
+	history   History[Message]
 }
 
 // NewHub creates a [Hub]. If a send channel of [BroadcastingChange] events is provided, the Hub
@@ -50,9 +65,16 @@ func NewHub[Message any](brChanges chan<- BroadcastingChange, logger Logger) *Hu
 		broadcastings: make(map[string]broadcasting[Message]),
 		brChanges:     brChanges,
 		logger:        logger,
+		origin:        rand.Text(),
 	}
 }
 
+// ID returns the randomly-generated ID of the [Hub], which identifies the Hub as the origin of
+// messages it forwards over a [Backplane].
+func (h *Hub[Message]) ID() string {
+	return h.origin
+}
+
 // Close cancels all subscriptions and destroys internal resources. If the [Hub] has an associated
 // send channel of [BroadcastingChange] events, Close closes and forgets that channel without
 // emitting an event listing all removed topics. The Hub should not be used after it's closed.
@@ -83,6 +105,17 @@ func (h *Hub[Message]) Close() {
 // the subscription becomes inactive.
 func (h *Hub[Message]) Subscribe(
 	ctx context.Context, topic string, receive ReceiveFunc[Message],
+) (removed <-chan struct{}) {
+	return h.SubscribeWithPosition(ctx, topic, func(message Message, _ StreamPosition) error {
+		return receive(message)
+	})
+}
+
+// SubscribeWithPosition creates an active subscription on the topic like [Hub.Subscribe], but with
+// a receive callback function which is also given the position of each message in the Hub's
+// [History].
+func (h *Hub[Message]) SubscribeWithPosition(
+	ctx context.Context, topic string, receive PositionedReceiveFunc[Message],
 ) (removed <-chan struct{}) {
 	h.logger.Debugf("adding pub-sub subscription on topic %s", topic)
 	cctx, cancel := context.WithCancel(ctx)
@@ -166,8 +199,20 @@ func (h *Hub[Message]) unsubscribe(subscriptions ...*subscription[Message]) {
 
 // Broadcast emits a message to all subscriptions on the topic, blocking until completion of
 // the receiver callback functions of all subscriptions; those callbacks are run in parallel.
-// Subscriptions whose receiver callbacks returned errors are deactivated.
+// Subscriptions whose receiver callbacks returned errors are deactivated. If the Hub is serving a
+// [Backplane] (see [Hub.ServeBackplane]), the message is first forwarded to the Hubs of other
+// processes, which emit it to their own subscriptions on the topic.
 func (h *Hub[Message]) Broadcast(topic string, message Message) {
+	h.forward(topic, message)
+	h.BroadcastLocal(topic, message)
+}
+
+// BroadcastLocal emits a message to all subscriptions on the topic like [Hub.Broadcast], but
+// without forwarding the message over a [Backplane] to the Hubs of other processes. If the Hub
+// has a [History], the message is first recorded in it, even if the topic has no subscriptions.
+func (h *Hub[Message]) BroadcastLocal(topic string, message Message) {
+	position := h.record(topic, message)
+
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
@@ -185,7 +230,7 @@ func (h *Hub[Message]) Broadcast(topic string, message Message) {
 			sub *subscription[Message], message Message, willUnsubscribe chan<- *subscription[Message],
 		) {
 			defer wg.Done()
-			if err := sub.receive(message); err != nil {
+			if err := sub.receive(message, position); err != nil {
 				h.logger.Warn(errors.Wrapf(
 					err, "removing subscription for %s due to message receiver error", topic,
 				))
@@ -202,3 +247,127 @@ func (h *Hub[Message]) Broadcast(topic string, message Message) {
 	}
 	h.unsubscribe(unsubscribe...)
 }
+
+// History
+
+// historyAppendTimeout bounds how long [Hub.BroadcastLocal] can be blocked by recording a message
+// in the Hub's [History] (e.g. while a sqlite database is locked by another process).
+const historyAppendTimeout = 5 * time.Second
+
+// record records the message in the Hub's [History], if it has one, and returns the position of the
+// message in the History. It doesn't hold the Hub's lock while recording the message, so that a
+// slow History doesn't block subscriptions from being added or removed.
+func (h *Hub[Message]) record(topic string, message Message) (position StreamPosition) {
+	history := h.History()
+	if history == nil {
+		return StreamPosition{}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), historyAppendTimeout)
+	defer cancel()
+	entry, err := history.Append(ctx, topic, message)
+	if err != nil {
+		h.logger.Error(errors.Wrapf(err, "couldn't record message on %s in history", topic))
+		return StreamPosition{}
+	}
+	return entry.Position
+}
+
+// SetHistory sets the [History] in which the Hub records every message it emits, so that
+// subscribers can look up messages they missed. Since the messages which the Hub emits include
+// messages forwarded from other processes over a [Backplane], each process should have its own
+// history (or its own epoch in a shared history). Positions are then only meaningful to the process
+// which recorded them, so a client which reconnects to a different process can only recover missed
+// messages by time (with [History.Since]), not by position.
+func (h *Hub[Message]) SetHistory(history History[Message]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.history = history
+}
+
+// History returns the [History] of the Hub, or nil if it has none.
+func (h *Hub[Message]) History() History[Message] {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.history
+}
+
+// Backplane forwarding
+
+// backplanePublishTimeout bounds how long [Hub.Broadcast] can be blocked by forwarding a message
+// over a [Backplane] (e.g. while a sqlite database is locked by another process), so that a slow
+// backplane can't stall broadcasting indefinitely.
+const backplanePublishTimeout = 5 * time.Second
+
+// ServeBackplane forwards broadcasts between the Hub and the Hubs of other processes subscribed to
+// the backplane, blocking until the context is canceled or the backplane fails. While
+// ServeBackplane is running, messages emitted by [Hub.Broadcast] are marshaled with the marshaler
+// and published on the backplane, and messages published on the backplane by other Hubs are
+// unmarshaled and emitted to the Hub's own subscriptions. Messages must survive a round-trip
+// through the marshaler; for example, with [marshaling.JSON], values stored in fields of interface
+// type are unmarshaled as maps, slices, and primitive values.
+func (h *Hub[Message]) ServeBackplane(
+	ctx context.Context, backplane Backplane, marshaler marshaling.Marshaler,
+) error {
+	h.mu.Lock()
+	if h.backplane != nil {
+		h.mu.Unlock()
+		return errors.New("pub-sub hub is already serving a backplane")
+	}
+	h.backplane = backplane
+	h.marshaler = marshaler
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		h.backplane = nil
+		h.marshaler = nil
+	}()
+
+	err := backplane.Subscribe(ctx, func(bm BackplaneMessage) {
+		if bm.Origin == h.origin {
+			return
+		}
+		var message Message
+		if err := marshaler.Unmarshal(bm.Payload, &message); err != nil {
+			h.logger.Error(errors.Wrapf(
+				err, "couldn't unmarshal message forwarded from hub %s on topic %s", bm.Origin, bm.Topic,
+			))
+			return
+		}
+		h.BroadcastLocal(bm.Topic, message)
+	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return errors.Wrap(err, "couldn't serve pub-sub backplane")
+	}
+	return err
+}
+
+// forward publishes the message on the backplane served by the Hub, if there is one.
+func (h *Hub[Message]) forward(topic string, message Message) {
+	h.mu.RLock()
+	backplane := h.backplane
+	marshaler := h.marshaler
+	h.mu.RUnlock()
+	if backplane == nil {
+		return
+	}
+
+	payload, err := marshaler.Marshal(message)
+	if err != nil {
+		h.logger.Error(errors.Wrapf(err, "couldn't marshal message on topic %s for backplane", topic))
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), backplanePublishTimeout)
+	defer cancel()
+	if err := backplane.Publish(ctx, BackplaneMessage{
+		Origin:  h.origin,
+		Topic:   topic,
+		Payload: payload,
+	}); err != nil {
+		h.logger.Error(errors.Wrapf(err, "couldn't forward message on topic %s to backplane", topic))
+	}
+}
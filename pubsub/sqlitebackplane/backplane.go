@@ -0,0 +1,159 @@
+// Package sqlitebackplane provides a sqlite-backed [pubsub.Backplane] using [database.DB], for
+// forwarding broadcasts between processes which share a sqlite database file without an external
+// message broker.
+package sqlitebackplane
+
+import (
+	"context"
+	_ "embed"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"zombiezen.com/go/sqlite"
+
+	"github.com/sargassum-world/godest/database"
+	"github.com/sargassum-world/godest/handling"
+	"github.com/sargassum-world/godest/pubsub"
+)
+
+// SqliteBackplane forwards broadcasts between processes by inserting messages into a table of a
+// godest sqlite-backed [database.DB] and polling that table for new messages.
+type SqliteBackplane struct {
+	// PollInterval is the interval between queries for new messages by each subscriber.
+	PollInterval time.Duration
+	// Retention is how long messages are kept in the table before they're deleted by
+	// [SqliteBackplane.Cleanup]. It should be much longer than PollInterval, so that all subscribers
+	// can receive every message before it's deleted.
+	Retention time.Duration
+	db        *database.DB
+	logger    pubsub.Logger
+}
+
+// NewSqliteBackplane returns a new SqliteBackplane.
+//
+// The db argument should be a [database.DB] with a "pubsub_backplane_message" table already
+// initialized according to the schema defined by the migrations in [NewDomainEmbeds]. The logger
+// is used to report failed polls, which are retried on the next poll.
+func NewSqliteBackplane(
+	db *database.DB, pollInterval, retention time.Duration, logger pubsub.Logger,
+) *SqliteBackplane {
+	return &SqliteBackplane{
+		PollInterval: pollInterval,
+		Retention:    retention,
+		db:           db,
+		logger:       logger,
+	}
+}
+
+//go:embed queries/insert-message.sql
+var rawInsertMessageQuery string
+var insertMessageQuery string = strings.TrimSpace(rawInsertMessageQuery)
+
+// Publish adds the message to the table, so that it will be received by all subscribers on their
+// next poll.
+func (b *SqliteBackplane) Publish(ctx context.Context, message pubsub.BackplaneMessage) error {
+	m := Message{
+		Origin:       message.Origin,
+		Topic:        message.Topic,
+		Payload:      message.Payload,
+		CreationTime: time.Now(),
+	}
+	return errors.Wrapf(
+		b.db.ExecuteInsertion(ctx, insertMessageQuery, m.newInsertion()),
+		"couldn't add message on topic %s", message.Topic,
+	)
+}
+
+//go:embed queries/select-last-message-id.sql
+var rawSelectLastMessageIDQuery string
+var selectLastMessageIDQuery string = strings.TrimSpace(rawSelectLastMessageIDQuery)
+
+func (b *SqliteBackplane) getLastMessageID(ctx context.Context) (id int64, err error) {
+	if err = b.db.ExecuteSelection(
+		ctx, selectLastMessageIDQuery, nil, func(s *sqlite.Stmt) error {
+			id = s.GetInt64("id")
+			return nil
+		},
+	); err != nil {
+		return 0, errors.Wrap(err, "couldn't get id of last message")
+	}
+	return id, nil
+}
+
+//go:embed queries/select-messages-after.sql
+var rawSelectMessagesAfterQuery string
+var selectMessagesAfterQuery string = strings.TrimSpace(rawSelectMessagesAfterQuery)
+
+// GetMessagesAfter returns all messages in the table added after the message with the id, in the
+// order in which they were added.
+func (b *SqliteBackplane) GetMessagesAfter(ctx context.Context, id int64) ([]Message, error) {
+	sel := newMessagesSelector()
+	if err := b.db.ExecuteSelection(
+		ctx, selectMessagesAfterQuery, newMessagesAfterSelection(id), sel.Step,
+	); err != nil {
+		return nil, errors.Wrapf(err, "couldn't get messages after id %d", id)
+	}
+	return sel.Messages(), nil
+}
+
+// Subscribe polls the table for messages added after Subscribe was called, and calls the receive
+// callback function on every new message, until the context is canceled. If a poll fails (e.g.
+// because the database is busy), the error is logged and the messages are queried again on the
+// next poll, so that no messages are skipped as long as they haven't been deleted by
+// [SqliteBackplane.Cleanup] yet.
+func (b *SqliteBackplane) Subscribe(
+	ctx context.Context, receive func(message pubsub.BackplaneMessage),
+) error {
+	lastID, err := b.getLastMessageID(ctx)
+	if err != nil {
+		return err
+	}
+	return handling.Repeat(ctx, b.PollInterval, func() (done bool, err error) {
+		messages, err := b.GetMessagesAfter(ctx, lastID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, err
+			}
+			b.logger.Error(errors.Wrap(err, "couldn't poll for backplane messages, will retry"))
+			return false, nil
+		}
+		for _, m := range messages {
+			receive(m.backplaneMessage())
+			lastID = m.ID
+		}
+		return false, nil
+	})
+}
+
+//go:embed queries/delete-messages-past-retention.sql
+var rawDeleteOldMessagesQuery string
+var deleteOldMessagesQuery string = strings.TrimSpace(rawDeleteOldMessagesQuery)
+
+// DeleteOldMessages deletes all messages which were added to the table before the threshold.
+func (b *SqliteBackplane) DeleteOldMessages(ctx context.Context, threshold time.Time) error {
+	return errors.Wrapf(
+		b.db.ExecuteDelete(
+			ctx, deleteOldMessagesQuery, Message{CreationTime: threshold}.newDeletePastRetention(),
+		),
+		"couldn't delete messages added before %s", threshold,
+	)
+}
+
+// Cleanup deletes all messages which were added to the table longer ago than the Retention
+// duration. It never reports that it's done, so that [SqliteBackplane.PeriodicallyCleanup] keeps
+// repeating it.
+func (b *SqliteBackplane) Cleanup(ctx context.Context) (done bool, err error) {
+	if err := b.DeleteOldMessages(ctx, time.Now().Add(-b.Retention)); err != nil {
+		return false, errors.Wrap(err, "couldn't perform periodic deletion of old messages")
+	}
+	return false, nil
+}
+
+// PeriodicallyCleanup runs [SqliteBackplane.Cleanup] at the interval, blocking until the context
+// is canceled or a cleanup fails.
+func (b *SqliteBackplane) PeriodicallyCleanup(ctx context.Context, interval time.Duration) error {
+	return handling.Repeat(ctx, interval, func() (done bool, err error) {
+		return b.Cleanup(ctx)
+	})
+}
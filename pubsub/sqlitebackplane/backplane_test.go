@@ -0,0 +1,178 @@
+package sqlitebackplane_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+
+	"github.com/sargassum-world/godest/database"
+	"github.com/sargassum-world/godest/pubsub"
+	"github.com/sargassum-world/godest/pubsub/sqlitebackplane"
+)
+
+// errorRecordingLogger is a [pubsub.Logger] which also reports logged errors to the test.
+type errorRecordingLogger struct {
+	pubsub.Logger
+	errors chan<- string
+}
+
+func (l errorRecordingLogger) Error(i ...interface{}) {
+	select {
+	case l.errors <- fmt.Sprint(i...):
+	default:
+	}
+}
+
+// newTestDB opens a database in a temporary file, with the schema of the backplane.
+func newTestDB(ctx context.Context, t *testing.T) *database.DB {
+	t.Helper()
+
+	db := database.NewDB(database.Config{
+		URI:           "file:" + filepath.Join(t.TempDir(), "db.sqlite3"),
+		Flags:         sqlite.OpenURI | sqlite.OpenWAL,
+		WritePoolSize: 1,
+		ReadPoolSize:  2,
+	})
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	schema, err := database.Embeds{
+		DomainEmbeds: map[string]database.DomainEmbeds{
+			"sqlitebackplane": sqlitebackplane.NewDomainEmbeds(),
+		},
+		MigrationFiles: []database.MigrationFile{
+			{Domain: "sqlitebackplane", File: sqlitebackplane.MigrationFiles[0]},
+		},
+	}.NewSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Migrate(ctx, schema); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// execute runs the SQL statement on the database.
+func execute(ctx context.Context, t *testing.T, db *database.DB, query string) {
+	t.Helper()
+
+	conn, err := db.AcquireWriter(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.ReleaseWriter(conn)
+	if err = sqlitex.ExecuteTransient(conn, query, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receiveUntil collects the payloads of the messages received on the channel up to and including
+// the last payload.
+func receiveUntil(
+	ctx context.Context, t *testing.T, received <-chan pubsub.BackplaneMessage, last string,
+) (payloads []string) {
+	t.Helper()
+
+	for {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for message %q after receiving %q", last, payloads)
+		case message := <-received:
+			payloads = append(payloads, string(message.Payload))
+			if string(message.Payload) == last {
+				return payloads
+			}
+		}
+	}
+}
+
+func TestSqliteBackplane(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	db := newTestDB(ctx, t)
+	errs := make(chan string, 1)
+	b := sqlitebackplane.NewSqliteBackplane(
+		db, 10*time.Millisecond, time.Hour,
+		errorRecordingLogger{Logger: echo.New().Logger, errors: errs},
+	)
+	publish := func(payload string) {
+		t.Helper()
+		if err := b.Publish(ctx, pubsub.BackplaneMessage{
+			Origin: "test", Topic: "topic", Payload: []byte(payload),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Messages published before the subscription aren't received
+	publish("before subscription")
+	received := make(chan pubsub.BackplaneMessage, 16)
+	sctx, scancel := context.WithCancel(ctx)
+	subscribed := make(chan error)
+	go func() {
+		subscribed <- b.Subscribe(sctx, func(message pubsub.BackplaneMessage) {
+			received <- message
+		})
+	}()
+	defer func() {
+		scancel()
+		<-subscribed
+	}()
+	// The subscription's cursor starts at the last message once Subscribe has queried it, so we
+	// keep publishing until the subscriber receives a message
+	for ready := false; !ready; {
+		publish("ready")
+		select {
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for subscription")
+		case <-received:
+			ready = true
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	// Skip any further ready messages
+	publish("end of ready")
+	for _, payload := range receiveUntil(ctx, t, received, "end of ready") {
+		if payload != "ready" && payload != "end of ready" {
+			t.Errorf("received unexpected message %q", payload)
+		}
+	}
+
+	// Each message is received once, in order, across multiple polls
+	publish("first")
+	publish("second")
+	time.Sleep(5 * b.PollInterval)
+	publish("third")
+	if got, want := receiveUntil(ctx, t, received, "third"), []string{
+		"first", "second", "third",
+	}; !slices.Equal(got, want) {
+		t.Errorf("received %q, want %q", got, want)
+	}
+
+	// Messages added while polls fail are received once polls succeed again
+	execute(ctx, t, db, "alter table pubsub_backplane_message rename to renamed_message")
+	select {
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for failed poll")
+	case <-errs:
+	}
+	execute(ctx, t, db, `insert into renamed_message (origin, topic, payload, creation_time)
+values ('test', 'topic', cast('during failure' as blob), 0)`)
+	execute(ctx, t, db, "alter table renamed_message rename to pubsub_backplane_message")
+	publish("after failure")
+	if got, want := receiveUntil(ctx, t, received, "after failure"), []string{
+		"during failure", "after failure",
+	}; !slices.Equal(got, want) {
+		t.Errorf("received %q, want %q", got, want)
+	}
+}
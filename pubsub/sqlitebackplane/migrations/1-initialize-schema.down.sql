@@ -0,0 +1,2 @@
+drop index pubsub_backplane_message_idx_creation_time;
+drop table pubsub_backplane_message;
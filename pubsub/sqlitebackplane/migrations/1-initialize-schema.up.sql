@@ -0,0 +1,12 @@
+-- Backplane message
+
+create table pubsub_backplane_message (
+  id            integer primary key autoincrement,
+  origin        text    not null,
+  topic         text    not null,
+  payload       blob    not null,
+  creation_time integer not null
+) strict;
+
+create index pubsub_backplane_message_idx_creation_time
+on pubsub_backplane_message (creation_time);
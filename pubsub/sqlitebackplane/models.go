@@ -0,0 +1,76 @@
+package sqlitebackplane
+
+import (
+	"time"
+
+	"zombiezen.com/go/sqlite"
+
+	"github.com/sargassum-world/godest/pubsub"
+)
+
+// Message
+
+type Message struct {
+	ID           int64
+	Origin       string
+	Topic        string
+	Payload      []byte
+	CreationTime time.Time
+}
+
+func (m Message) newInsertion() map[string]interface{} {
+	return map[string]interface{}{
+		"$origin":        m.Origin,
+		"$topic":         m.Topic,
+		"$payload":       m.Payload,
+		"$creation_time": m.CreationTime.UnixMilli(),
+	}
+}
+
+func (m Message) newDeletePastRetention() map[string]interface{} {
+	return map[string]interface{}{
+		"$creation_time_threshold": m.CreationTime.UnixMilli(),
+	}
+}
+
+func newMessagesAfterSelection(id int64) map[string]interface{} {
+	return map[string]interface{}{
+		"$id": id,
+	}
+}
+
+func (m Message) backplaneMessage() pubsub.BackplaneMessage {
+	return pubsub.BackplaneMessage{
+		Origin:  m.Origin,
+		Topic:   m.Topic,
+		Payload: m.Payload,
+	}
+}
+
+// Messages
+
+type messagesSelector struct {
+	messages []Message
+}
+
+func newMessagesSelector() *messagesSelector {
+	return &messagesSelector{
+		messages: make([]Message, 0),
+	}
+}
+
+func (sel *messagesSelector) Step(s *sqlite.Stmt) error {
+	payload := make([]byte, s.GetLen("payload"))
+	s.GetBytes("payload", payload)
+	sel.messages = append(sel.messages, Message{
+		ID:      s.GetInt64("id"),
+		Origin:  s.GetText("origin"),
+		Topic:   s.GetText("topic"),
+		Payload: payload,
+	})
+	return nil
+}
+
+func (sel *messagesSelector) Messages() []Message {
+	return sel.messages
+}
@@ -0,0 +1,2 @@
+delete from pubsub_backplane_message
+where pubsub_backplane_message.creation_time < $creation_time_threshold
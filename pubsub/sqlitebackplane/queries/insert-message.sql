@@ -0,0 +1,2 @@
+insert into pubsub_backplane_message (origin, topic, payload, creation_time)
+values ($origin, $topic, $payload, $creation_time);
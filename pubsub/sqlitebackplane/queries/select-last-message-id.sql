@@ -0,0 +1,2 @@
+select coalesce(max(m.id), 0) as id
+from pubsub_backplane_message as m
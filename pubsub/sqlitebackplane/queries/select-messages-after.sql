@@ -0,0 +1,8 @@
+select
+  m.id      as id,
+  m.origin  as origin,
+  m.topic   as topic,
+  m.payload as payload
+from pubsub_backplane_message as m
+where m.id > $id
+order by m.id
@@ -0,0 +1,286 @@
+// Package sqlitehistory provides a sqlite-backed [pubsub.History] using [database.DB], which keeps
+// the history of broadcast messages across restarts of the process.
+package sqlitehistory
+
+import (
+	"context"
+	_ "embed"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+
+	"github.com/sargassum-world/godest/database"
+	"github.com/sargassum-world/godest/handling"
+	"github.com/sargassum-world/godest/marshaling"
+	"github.com/sargassum-world/godest/pubsub"
+)
+
+// SqliteHistory records messages broadcast on each topic of a [pubsub.Hub] in tables of a godest
+// sqlite-backed [database.DB]. Messages are stored as marshaled payloads, so they must survive a
+// round-trip through the marshaler.
+type SqliteHistory[Message any] struct {
+	// Epoch identifies the history. Processes which share a database (e.g. because they forward
+	// broadcasts to each other over a [pubsub.Backplane]) must use different epochs, since each
+	// process records all messages it emits (including forwarded messages) in its own history.
+	// Because positions are only valid within an epoch, a client which reconnects to a different
+	// process than the one which sent it a message can't resume after that message's position; its
+	// history requests by position are rejected with [pubsub.ErrHistoryUnavailable], so it should
+	// fall back to requesting messages since a time.
+	Epoch string
+	// Limit is the maximum number of messages kept for each topic.
+	Limit int
+	// Retention is how long messages are kept before they're deleted by [SqliteHistory.Cleanup].
+	Retention time.Duration
+	db        *database.DB
+	marshaler marshaling.Marshaler
+}
+
+// NewSqliteHistory returns a new SqliteHistory.
+//
+// The db argument should be a [database.DB] with "pubsub_history_topic" and
+// "pubsub_history_entry" tables already initialized according to the schema defined by the
+// migrations in [NewDomainEmbeds].
+func NewSqliteHistory[Message any](
+	db *database.DB, marshaler marshaling.Marshaler, epoch string, limit int,
+	retention time.Duration,
+) *SqliteHistory[Message] {
+	return &SqliteHistory[Message]{
+		Epoch:     epoch,
+		Limit:     max(limit, 1),
+		Retention: retention,
+		db:        db,
+		marshaler: marshaler,
+	}
+}
+
+func (h *SqliteHistory[Message]) historyEntry(e Entry) (pubsub.HistoryEntry[Message], error) {
+	var message Message
+	if err := h.marshaler.Unmarshal(e.Payload, &message); err != nil {
+		return pubsub.HistoryEntry[Message]{}, errors.Wrapf(
+			err, "couldn't unmarshal message at offset %d on topic %s", e.Offset, e.Topic,
+		)
+	}
+	return pubsub.HistoryEntry[Message]{
+		Position: pubsub.StreamPosition{Epoch: e.Epoch, Offset: e.Offset},
+		Time:     e.CreationTime,
+		Message:  message,
+	}, nil
+}
+
+func (h *SqliteHistory[Message]) historyEntries(
+	entries []Entry,
+) (historyEntries []pubsub.HistoryEntry[Message], err error) {
+	historyEntries = make([]pubsub.HistoryEntry[Message], len(entries))
+	for i, e := range entries {
+		if historyEntries[i], err = h.historyEntry(e); err != nil {
+			return nil, err
+		}
+	}
+	return historyEntries, nil
+}
+
+//go:embed queries/upsert-topic-offset.sql
+var rawUpsertTopicOffsetQuery string
+var upsertTopicOffsetQuery string = strings.TrimSpace(rawUpsertTopicOffsetQuery)
+
+//go:embed queries/insert-entry.sql
+var rawInsertEntryQuery string
+var insertEntryQuery string = strings.TrimSpace(rawInsertEntryQuery)
+
+//go:embed queries/delete-entries-past-limit.sql
+var rawDeleteExcessEntriesQuery string
+var deleteExcessEntriesQuery string = strings.TrimSpace(rawDeleteExcessEntriesQuery)
+
+// AddEntry assigns the next offset of the entry's topic to the entry and adds the entry, deleting
+// the topic's oldest entries past the limit.
+func (h *SqliteHistory[Message]) AddEntry(ctx context.Context, e Entry) (added Entry, err error) {
+	conn, err := h.db.AcquireWriter(ctx)
+	if err != nil {
+		return Entry{}, errors.Wrap(err, "couldn't acquire writer to add history entry")
+	}
+	defer h.db.ReleaseWriter(conn)
+	defer sqlitex.Save(conn)(&err)
+
+	if err = database.ExecuteSelection(
+		conn, upsertTopicOffsetQuery, e.newTopicOffsetUpsert(), func(s *sqlite.Stmt) error {
+			e.Offset = uint64(s.GetInt64("last_offset"))
+			return nil
+		},
+	); err != nil {
+		return Entry{}, errors.Wrapf(err, "couldn't assign offset on topic %s", e.Topic)
+	}
+	if err = database.ExecuteInsertion(conn, insertEntryQuery, e.newInsertion()); err != nil {
+		return Entry{}, errors.Wrapf(err, "couldn't add entry on topic %s", e.Topic)
+	}
+	if err = database.ExecuteDelete(
+		conn, deleteExcessEntriesQuery, e.newDeletePastLimit(h.Limit),
+	); err != nil {
+		return Entry{}, errors.Wrapf(err, "couldn't delete excess entries on topic %s", e.Topic)
+	}
+	return e, nil
+}
+
+// Append records the message broadcast on the topic.
+func (h *SqliteHistory[Message]) Append(
+	ctx context.Context, topic string, message Message,
+) (pubsub.HistoryEntry[Message], error) {
+	payload, err := h.marshaler.Marshal(message)
+	if err != nil {
+		return pubsub.HistoryEntry[Message]{}, errors.Wrapf(
+			err, "couldn't marshal message on topic %s", topic,
+		)
+	}
+	e, err := h.AddEntry(ctx, Entry{
+		Epoch:        h.Epoch,
+		Topic:        topic,
+		Payload:      payload,
+		CreationTime: time.Now(),
+	})
+	if err != nil {
+		return pubsub.HistoryEntry[Message]{}, err
+	}
+	return pubsub.HistoryEntry[Message]{
+		Position: pubsub.StreamPosition{Epoch: e.Epoch, Offset: e.Offset},
+		Time:     e.CreationTime,
+		Message:  message,
+	}, nil
+}
+
+//go:embed queries/select-topic-offset.sql
+var rawSelectTopicOffsetQuery string
+var selectTopicOffsetQuery string = strings.TrimSpace(rawSelectTopicOffsetQuery)
+
+// GetLastOffset returns the offset of the last entry added on the topic, or zero if no entries
+// were added on the topic since it was last deleted by [SqliteHistory.DeleteOldEntries].
+func (h *SqliteHistory[Message]) GetLastOffset(
+	ctx context.Context, topic string,
+) (offset uint64, err error) {
+	if err = h.db.ExecuteSelection(
+		ctx, selectTopicOffsetQuery, newTopicOffsetSelection(h.Epoch, topic),
+		func(s *sqlite.Stmt) error {
+			offset = uint64(s.GetInt64("last_offset"))
+			return nil
+		},
+	); err != nil {
+		return 0, errors.Wrapf(err, "couldn't get last offset on topic %s", topic)
+	}
+	return offset, nil
+}
+
+//go:embed queries/select-entries-after.sql
+var rawSelectEntriesAfterQuery string
+var selectEntriesAfterQuery string = strings.TrimSpace(rawSelectEntriesAfterQuery)
+
+// GetEntriesAfter returns all entries on the topic after the offset.
+func (h *SqliteHistory[Message]) GetEntriesAfter(
+	ctx context.Context, topic string, offset uint64,
+) ([]Entry, error) {
+	sel := newEntriesSelector(h.Epoch, topic)
+	if err := h.db.ExecuteSelection(
+		ctx, selectEntriesAfterQuery, newEntriesAfterSelection(h.Epoch, topic, offset), sel.Step,
+	); err != nil {
+		return nil, errors.Wrapf(err, "couldn't get entries after offset %d on topic %s", offset, topic)
+	}
+	return sel.Entries(), nil
+}
+
+// After returns the entries of all messages on the topic after the position.
+func (h *SqliteHistory[Message]) After(
+	ctx context.Context, topic string, position pubsub.StreamPosition,
+) ([]pubsub.HistoryEntry[Message], error) {
+	if position.Epoch != h.Epoch {
+		return nil, errors.Wrapf(pubsub.ErrHistoryUnavailable, "unknown epoch %s", position.Epoch)
+	}
+	lastOffset, err := h.GetLastOffset(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+	if position.Offset > lastOffset {
+		return nil, errors.Wrapf(
+			pubsub.ErrHistoryUnavailable, "offset %d is past last offset %d",
+			position.Offset, lastOffset,
+		)
+	}
+	entries, err := h.GetEntriesAfter(ctx, topic, position.Offset)
+	if err != nil {
+		return nil, err
+	}
+	if position.Offset < lastOffset &&
+		(len(entries) == 0 || entries[0].Offset != position.Offset+1) {
+		return nil, errors.Wrapf(
+			pubsub.ErrHistoryUnavailable, "entries after offset %d were already deleted",
+			position.Offset,
+		)
+	}
+	return h.historyEntries(entries)
+}
+
+//go:embed queries/select-entries-since.sql
+var rawSelectEntriesSinceQuery string
+var selectEntriesSinceQuery string = strings.TrimSpace(rawSelectEntriesSinceQuery)
+
+// Since returns the entries of all messages on the topic which were recorded at or after the
+// time.
+func (h *SqliteHistory[Message]) Since(
+	ctx context.Context, topic string, t time.Time,
+) ([]pubsub.HistoryEntry[Message], error) {
+	sel := newEntriesSelector(h.Epoch, topic)
+	if err := h.db.ExecuteSelection(
+		ctx, selectEntriesSinceQuery, newEntriesSinceSelection(h.Epoch, topic, t), sel.Step,
+	); err != nil {
+		return nil, errors.Wrapf(err, "couldn't get entries since %s on topic %s", t, topic)
+	}
+	return h.historyEntries(sel.Entries())
+}
+
+//go:embed queries/delete-entries-past-retention.sql
+var rawDeleteOldEntriesQuery string
+var deleteOldEntriesQuery string = strings.TrimSpace(rawDeleteOldEntriesQuery)
+
+//go:embed queries/delete-empty-topics.sql
+var rawDeleteEmptyTopicsQuery string
+var deleteEmptyTopicsQuery string = strings.TrimSpace(rawDeleteEmptyTopicsQuery)
+
+// DeleteOldEntries deletes all entries (of all epochs) which were added before the threshold, and
+// then deletes all topics (of all epochs) which no longer have any entries. Because the newest
+// entry of each topic is never deleted for being past the limit, a topic is only deleted once no
+// entries were added on it since the threshold; if an entry is later added on that topic, the
+// topic's offsets start over.
+func (h *SqliteHistory[Message]) DeleteOldEntries(
+	ctx context.Context, threshold time.Time,
+) (err error) {
+	conn, err := h.db.AcquireWriter(ctx)
+	if err != nil {
+		return errors.Wrap(err, "couldn't acquire writer to delete old history entries")
+	}
+	defer h.db.ReleaseWriter(conn)
+	defer sqlitex.Save(conn)(&err)
+
+	if err = database.ExecuteDelete(
+		conn, deleteOldEntriesQuery, Entry{CreationTime: threshold}.newDeletePastRetention(),
+	); err != nil {
+		return errors.Wrapf(err, "couldn't delete entries added before %s", threshold)
+	}
+	if err = database.ExecuteDelete(conn, deleteEmptyTopicsQuery, nil); err != nil {
+		return errors.Wrap(err, "couldn't delete topics without entries")
+	}
+	return nil
+}
+
+func (h *SqliteHistory[Message]) Cleanup(ctx context.Context) (done bool, err error) {
+	if err := h.DeleteOldEntries(ctx, time.Now().Add(-h.Retention)); err != nil {
+		return false, errors.Wrap(err, "couldn't perform periodic deletion of old entries")
+	}
+	return false, nil
+}
+
+func (h *SqliteHistory[Message]) PeriodicallyCleanup(
+	ctx context.Context, interval time.Duration,
+) error {
+	return handling.Repeat(ctx, interval, func() (done bool, err error) {
+		return h.Cleanup(ctx)
+	})
+}
@@ -0,0 +1,3 @@
+drop index pubsub_history_entry_idx_creation_time;
+drop table pubsub_history_entry;
+drop table pubsub_history_topic;
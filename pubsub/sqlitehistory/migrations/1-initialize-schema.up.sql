@@ -0,0 +1,22 @@
+-- History topic
+
+create table pubsub_history_topic (
+  epoch       text    not null,
+  topic       text    not null,
+  last_offset integer not null,
+  primary key (epoch, topic)
+) strict;
+
+-- History entry
+
+create table pubsub_history_entry (
+  epoch         text    not null,
+  topic         text    not null,
+  stream_offset integer not null,
+  payload       blob    not null,
+  creation_time integer not null,
+  primary key (epoch, topic, stream_offset)
+) strict;
+
+create index pubsub_history_entry_idx_creation_time
+on pubsub_history_entry (creation_time);
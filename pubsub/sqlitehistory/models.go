@@ -0,0 +1,104 @@
+package sqlitehistory
+
+import (
+	"time"
+
+	"zombiezen.com/go/sqlite"
+)
+
+// Entry
+
+type Entry struct {
+	Epoch        string
+	Topic        string
+	Offset       uint64
+	Payload      []byte
+	CreationTime time.Time
+}
+
+func (e Entry) newTopicOffsetUpsert() map[string]interface{} {
+	return map[string]interface{}{
+		"$epoch": e.Epoch,
+		"$topic": e.Topic,
+	}
+}
+
+func (e Entry) newInsertion() map[string]interface{} {
+	return map[string]interface{}{
+		"$epoch":         e.Epoch,
+		"$topic":         e.Topic,
+		"$stream_offset": int64(e.Offset),
+		"$payload":       e.Payload,
+		"$creation_time": e.CreationTime.UnixMilli(),
+	}
+}
+
+func (e Entry) newDeletePastLimit(limit int) map[string]interface{} {
+	return map[string]interface{}{
+		"$epoch":                   e.Epoch,
+		"$topic":                   e.Topic,
+		"$stream_offset_threshold": int64(e.Offset) - int64(limit),
+	}
+}
+
+func (e Entry) newDeletePastRetention() map[string]interface{} {
+	return map[string]interface{}{
+		"$creation_time_threshold": e.CreationTime.UnixMilli(),
+	}
+}
+
+func newTopicOffsetSelection(epoch, topic string) map[string]interface{} {
+	return map[string]interface{}{
+		"$epoch": epoch,
+		"$topic": topic,
+	}
+}
+
+func newEntriesAfterSelection(epoch, topic string, offset uint64) map[string]interface{} {
+	return map[string]interface{}{
+		"$epoch":         epoch,
+		"$topic":         topic,
+		"$stream_offset": int64(offset),
+	}
+}
+
+func newEntriesSinceSelection(epoch, topic string, t time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"$epoch":         epoch,
+		"$topic":         topic,
+		"$creation_time": t.UnixMilli(),
+	}
+}
+
+// Entries
+
+type entriesSelector struct {
+	epoch   string
+	topic   string
+	entries []Entry
+}
+
+func newEntriesSelector(epoch, topic string) *entriesSelector {
+	return &entriesSelector{
+		epoch:   epoch,
+		topic:   topic,
+		entries: make([]Entry, 0),
+	}
+}
+
+func (sel *entriesSelector) Step(s *sqlite.Stmt) error {
+	payload := make([]byte, s.GetLen("payload"))
+	s.GetBytes("payload", payload)
+	sel.entries = append(sel.entries, Entry{
+		Epoch:        sel.epoch,
+		Topic:        sel.topic,
+		Offset:       uint64(s.GetInt64("stream_offset")),
+		Payload:      payload,
+		CreationTime: time.UnixMilli(s.GetInt64("creation_time")),
+	})
+	return nil
+}
+
+func (sel *entriesSelector) Entries() []Entry {
+	return sel.entries
+}
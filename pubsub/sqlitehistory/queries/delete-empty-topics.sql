@@ -0,0 +1,8 @@
+delete from pubsub_history_topic
+where not exists (
+  select 1
+  from pubsub_history_entry as e
+  where
+    e.epoch = pubsub_history_topic.epoch
+    and e.topic = pubsub_history_topic.topic
+)
@@ -0,0 +1,5 @@
+delete from pubsub_history_entry
+where
+  pubsub_history_entry.epoch = $epoch
+  and pubsub_history_entry.topic = $topic
+  and pubsub_history_entry.stream_offset <= $stream_offset_threshold
@@ -0,0 +1,2 @@
+delete from pubsub_history_entry
+where pubsub_history_entry.creation_time < $creation_time_threshold
@@ -0,0 +1,2 @@
+insert into pubsub_history_entry (epoch, topic, stream_offset, payload, creation_time)
+values ($epoch, $topic, $stream_offset, $payload, $creation_time);
@@ -0,0 +1,10 @@
+select
+  e.stream_offset as stream_offset,
+  e.payload       as payload,
+  e.creation_time as creation_time
+from pubsub_history_entry as e
+where
+  e.epoch = $epoch
+  and e.topic = $topic
+  and e.stream_offset > $stream_offset
+order by e.stream_offset
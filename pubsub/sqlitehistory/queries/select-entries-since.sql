@@ -0,0 +1,10 @@
+select
+  e.stream_offset as stream_offset,
+  e.payload       as payload,
+  e.creation_time as creation_time
+from pubsub_history_entry as e
+where
+  e.epoch = $epoch
+  and e.topic = $topic
+  and e.creation_time >= $creation_time
+order by e.stream_offset
@@ -0,0 +1,5 @@
+select t.last_offset as last_offset
+from pubsub_history_topic as t
+where
+  t.epoch = $epoch
+  and t.topic = $topic
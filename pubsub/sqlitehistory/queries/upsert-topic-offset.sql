@@ -0,0 +1,5 @@
+insert into pubsub_history_topic (epoch, topic, last_offset)
+values ($epoch, $topic, 1)
+on conflict (epoch, topic) do update
+set last_offset = pubsub_history_topic.last_offset + 1
+returning last_offset
@@ -0,0 +1,235 @@
+package godest
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/pkg/errors"
+)
+
+// ServiceWorkerTemplate is the source of a service worker template which precaches assets and
+// serves them from the cache, for apps to copy into their TemplatesFS (see
+// [TemplateRenderer.RenderServiceWorker]).
+//
+//go:embed service-worker.js.tmpl
+var ServiceWorkerTemplate string
+
+// ServiceWorkerPrecache selects the assets which a service worker should precache. Each list of
+// patterns contains double-star glob patterns of file paths in the corresponding filesystem of the
+// [Embeds]; each URL prefix should match the route prefix under which the filesystem is served.
+// App and static assets are precached by their hashed URLs; fonts are precached by their unhashed
+// URLs, since the Embeds' FontsFS has no hashed file names.
+type ServiceWorkerPrecache struct {
+	AppURLPrefix    string
+	AppPatterns     []string
+	StaticURLPrefix string
+	StaticPatterns  []string
+	FontsURLPrefix  string
+	FontsPatterns   []string
+}
+
+// validate checks that all patterns are valid.
+func (p ServiceWorkerPrecache) validate() error {
+	for _, patterns := range [][]string{p.AppPatterns, p.StaticPatterns, p.FontsPatterns} {
+		for _, pattern := range patterns {
+			if !doublestar.ValidatePattern(pattern) {
+				return errors.Errorf("invalid precache pattern %s", pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// listPrecacheURLs lists the URLs of the files in the filesystem which match any of the patterns.
+func listPrecacheURLs(
+	fsys fs.FS, patterns []string, namer func(string) string,
+) (urls []string, err error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	if fsys == nil {
+		return nil, errors.New("can't precache assets from missing filesystem")
+	}
+	files, err := listFiles(fsys, func(path string) bool {
+		for _, pattern := range patterns {
+			if doublestar.MatchUnvalidated(pattern, path) {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't list assets to precache")
+	}
+	for _, file := range files {
+		urls = append(urls, namer(file))
+	}
+	return urls, nil
+}
+
+// listPrecacheURLs lists the URLs of all assets in the Embeds which should be precached.
+func (p ServiceWorkerPrecache) listPrecacheURLs(e Embeds) (urls []string, err error) {
+	if err = p.validate(); err != nil {
+		return nil, err
+	}
+	appURLs, err := listPrecacheURLs(e.AppFS, p.AppPatterns, e.GetAppHashedNamer(p.AppURLPrefix))
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't list app assets to precache")
+	}
+	staticURLs, err := listPrecacheURLs(
+		e.StaticFS, p.StaticPatterns, e.GetStaticHashedNamer(p.StaticURLPrefix),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't list static assets to precache")
+	}
+	fontsURLs, err := listPrecacheURLs(e.FontsFS, p.FontsPatterns, func(file string) string {
+		return p.FontsURLPrefix + file
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't list fonts to precache")
+	}
+	return append(append(appURLs, staticURLs...), fontsURLs...), nil
+}
+
+// ServiceWorkerData is the template data for rendering a service worker template.
+type ServiceWorkerData struct {
+	// Version changes whenever the app fingerprint or the precache list changes, so that clients
+	// update their service workers (and precached assets) whenever either of them changes.
+	Version string
+	// PrecacheURLs are the URLs of the assets to precache.
+	PrecacheURLs []string
+}
+
+// VersionJSON returns the Version encoded as a JavaScript string literal.
+func (d ServiceWorkerData) VersionJSON() (string, error) {
+	encoded, err := json.Marshal(d.Version)
+	return string(encoded), errors.Wrap(err, "couldn't encode service worker version")
+}
+
+// PrecacheURLsJSON returns the PrecacheURLs encoded as a JavaScript array literal.
+func (d ServiceWorkerData) PrecacheURLsJSON() (string, error) {
+	urls := d.PrecacheURLs
+	if urls == nil {
+		urls = []string{}
+	}
+	encoded, err := json.Marshal(urls)
+	return string(encoded), errors.Wrap(err, "couldn't encode precache list")
+}
+
+// newServiceWorkerData computes the version of the service worker from the app fingerprint and the
+// precache list.
+func newServiceWorkerData(appFingerprint string, urls []string) (ServiceWorkerData, error) {
+	encodedURLs, err := json.Marshal(urls)
+	if err != nil {
+		return ServiceWorkerData{}, errors.Wrap(err, "couldn't encode precache list")
+	}
+	return ServiceWorkerData{
+		Version:      computeFingerprint(append([]byte(appFingerprint), encodedURLs...)),
+		PrecacheURLs: urls,
+	}, nil
+}
+
+// TemplateRenderer: service worker support
+
+func (tr TemplateRenderer) getWorkers() (workers map[string]*texttemplate.Template, err error) {
+	workers = tr.workerTemplates
+	if workers == nil {
+		funcs, err := tr.getFuncs()
+		if err != nil {
+			return nil, err
+		}
+		if workers, err = instantiateTextTemplates(
+			tr.embeds.TemplatesFS, funcs, filterWorkerTemplate,
+		); err != nil {
+			return nil, errors.Wrap(err, "couldn't instantiate service worker templates")
+		}
+	}
+	return workers, nil
+}
+
+// RenderServiceWorker renders the service worker template (a text template file in the Embeds'
+// TemplatesFS whose name ends with ".worker.tmpl", e.g. "app/service-worker.worker.tmpl") with
+// [RenderData] which has the TemplateRenderer's Inlines and [ServiceWorkerData] for the assets
+// selected by the precache configuration. [ServiceWorkerTemplate] is a template which apps can
+// copy into their TemplatesFS. The precache list is computed upon each render, so it stays
+// current when templates and assets are hot-reloaded.
+func (tr TemplateRenderer) RenderServiceWorker(
+	templateName string, p ServiceWorkerPrecache,
+) (script []byte, err error) {
+	tr, err = tr.loaded()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	defer func() {
+		tr.observeRender(ServiceWorkerRender, templateName, start, len(script), err)
+	}()
+
+	workers, err := tr.getWorkers()
+	if err != nil {
+		return nil, err
+	}
+	tmpl, ok := workers[templateName]
+	if !ok {
+		return nil, errors.Errorf("service worker template %s not found", templateName)
+	}
+	urls, err := p.listPrecacheURLs(tr.embeds)
+	if err != nil {
+		return nil, err
+	}
+	fingerprints, err := tr.getFingerprints()
+	if err != nil {
+		return nil, err
+	}
+	data, err := newServiceWorkerData(fingerprints.app, urls)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	if err = tmpl.Execute(buf, tr.NewRenderData(nil, data, nil)); err != nil {
+		return nil, errors.Wrapf(err, "couldn't execute service worker template %s", templateName)
+	}
+	return buf.Bytes(), nil
+}
+
+// HandleServiceWorker returns a handler which serves the service worker script rendered by
+// [TemplateRenderer.RenderServiceWorker] to precache the selected assets by their hashed URLs. The
+// script is served with its own etag so that browsers can cheaply revalidate it. If the script
+// can't be rendered, the handler responds with a generic server error and reports the error to the
+// logger (if it isn't nil), so that internal error messages aren't exposed to clients.
+func (tr TemplateRenderer) HandleServiceWorker(
+	templateName string, p ServiceWorkerPrecache, logger Logger,
+) (http.Handler, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		script, err := tr.RenderServiceWorker(templateName, p)
+		if err != nil {
+			if logger != nil {
+				logger.Error(errors.Wrap(err, "couldn't render service worker"))
+			}
+			http.Error(
+				w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError,
+			)
+			return
+		}
+
+		if noContent := setAndCheckEtag(w, r, computeFingerprint(script)); noContent {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(script)
+		}
+	}), nil
+}
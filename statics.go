@@ -4,9 +4,13 @@ import (
 	"fmt"
 	"io/fs"
 	"net/http"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/benbjohnson/hashfs"
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/pkg/errors"
 )
 
 func wrapStaticHeader(h http.Handler, age int) http.Handler {
@@ -25,3 +29,88 @@ func HandleFS(routePrefix string, fsys fs.FS, age time.Duration) http.Handler {
 func HandleFSFileRevved(routePrefix string, fsys fs.FS) http.Handler {
 	return http.StripPrefix(routePrefix, hashfs.FileServer(fsys))
 }
+
+// Static files with etags and cache policies
+
+type cachePolicy struct {
+	pattern string
+	age     time.Duration
+}
+
+// staticConfig holds the configuration of the handler created by [HandleFSWithEtags].
+type staticConfig struct {
+	policies []cachePolicy
+}
+
+// StaticOption modifies the handler created by [HandleFSWithEtags].
+type StaticOption func(c *staticConfig)
+
+// WithCachePolicy creates a [StaticOption] to cache files whose paths (relative to the root of the
+// filesystem) match the double-star glob pattern with the specified max age, instead of the
+// default max age. When a file matches multiple patterns, the first matching pattern is used.
+func WithCachePolicy(pattern string, age time.Duration) StaticOption {
+	return func(c *staticConfig) {
+		c.policies = append(c.policies, cachePolicy{pattern: pattern, age: age})
+	}
+}
+
+// computeStaticEtags computes strong etags from the contents of all files in the filesystem.
+func computeStaticEtags(fsys fs.FS) (map[string]string, error) {
+	files, err := listFiles(fsys, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't list static files")
+	}
+	etags := make(map[string]string, len(files))
+	for _, file := range files {
+		data, err := readFile(file, fsys)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't load static file %s for fingerprinting", file)
+		}
+		etags[file] = fmt.Sprintf("%q", computeFingerprint(data))
+	}
+	return etags, nil
+}
+
+// HandleFSWithEtags is like [HandleFS], except that it sets strong etags derived from the
+// contents of the files (computed once when the handler is created) so that conditional requests
+// can be answered with 304 Not Modified responses even for filesystems without file modification
+// times (e.g. embed.FS), and so that byte range requests (e.g. for large media files) can be made
+// conditional on the etag with If-Range. Max ages can be set for different files with
+// [WithCachePolicy].
+func HandleFSWithEtags(
+	routePrefix string, fsys fs.FS, defaultAge time.Duration, opts ...StaticOption,
+) (http.Handler, error) {
+	etags, err := computeStaticEtags(fsys)
+	if err != nil {
+		return nil, err
+	}
+	var c staticConfig
+	for _, opt := range opts {
+		opt(&c)
+	}
+	for _, policy := range c.policies {
+		if !doublestar.ValidatePattern(policy.pattern) {
+			return nil, errors.Errorf("invalid cache policy pattern %s", policy.pattern)
+		}
+	}
+
+	fileServer := http.FileServer(http.FS(fsys))
+	return http.StripPrefix(routePrefix, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+			age := defaultAge
+			for _, policy := range c.policies {
+				if doublestar.MatchUnvalidated(policy.pattern, name) {
+					age = policy.age
+					break
+				}
+			}
+			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(age.Seconds())))
+			if etag, ok := etags[name]; ok {
+				// http.FileServer uses this etag to evaluate If-Match, If-None-Match, and If-Range
+				w.Header().Set("Etag", etag)
+			}
+			fileServer.ServeHTTP(w, r)
+		},
+	)), nil
+}
@@ -0,0 +1,480 @@
+package godest
+
+import (
+	"fmt"
+	"html/template"
+	"reflect"
+	"strings"
+	"text/template/parse"
+
+	"github.com/pkg/errors"
+)
+
+// TemplateProblem describes a problem found by statically checking a template against the Go types
+// of the data it will be executed with.
+type TemplateProblem struct {
+	// Template is the name of the template (page, partial, etc.) which was being checked.
+	Template string
+	// Location is the location of the problem in the template file, as "file:line:column".
+	Location string
+	// Problem describes the problem.
+	Problem string
+}
+
+func (p TemplateProblem) Error() string {
+	return fmt.Sprintf("%s: %s (while checking %s)", p.Location, p.Problem, p.Template)
+}
+
+// PageTypes specifies example values whose Go types are used as the types of the fields of
+// [RenderData] when statically checking a page template. A nil example value leaves the type of
+// the corresponding field unknown, so that fields accessed through it are not checked. If Inlines
+// is nil, the type of the TemplateRenderer's inlines is used instead.
+type PageTypes struct {
+	Inlines any
+	Data    any
+	Auth    any
+}
+
+// templateChecker statically checks template parse trees against Go types. A nil reflect.Type
+// represents a type which is unknown before execution, and it's never reported as a problem.
+type templateChecker struct {
+	set      *template.Template
+	partials map[string]*template.Template
+	funcs    map[string]reflect.Type
+
+	checking string
+	visited  map[string]bool
+	problems []TemplateProblem
+}
+
+// templateScope maps template variable names to their types.
+type templateScope map[string]reflect.Type
+
+func (s templateScope) child() templateScope {
+	child := make(templateScope, len(s))
+	for name, t := range s {
+		child[name] = t
+	}
+	return child
+}
+
+var (
+	anyType    = reflect.TypeFor[any]()
+	boolType   = reflect.TypeFor[bool]()
+	intType    = reflect.TypeFor[int]()
+	floatType  = reflect.TypeFor[float64]()
+	stringType = reflect.TypeFor[string]()
+)
+
+// builtinTemplateFuncTypes lists the result types of the predefined template functions which have
+// a fixed result type.
+var builtinTemplateFuncTypes = map[string]reflect.Type{
+	"not":      boolType,
+	"eq":       boolType,
+	"ne":       boolType,
+	"lt":       boolType,
+	"le":       boolType,
+	"gt":       boolType,
+	"ge":       boolType,
+	"len":      intType,
+	"print":    stringType,
+	"printf":   stringType,
+	"println":  stringType,
+	"html":     stringType,
+	"js":       stringType,
+	"urlquery": stringType,
+}
+
+func newTemplateChecker(
+	set *template.Template, partials map[string]*template.Template, funcs []template.FuncMap,
+) *templateChecker {
+	c := &templateChecker{
+		set:      set,
+		partials: partials,
+		funcs:    make(map[string]reflect.Type),
+		visited:  make(map[string]bool),
+	}
+	for name, t := range builtinTemplateFuncTypes {
+		c.funcs[name] = t
+	}
+	for _, funcMap := range funcs {
+		for name, f := range funcMap {
+			c.funcs[name] = nil
+			if ft := reflect.TypeOf(f); ft != nil && ft.Kind() == reflect.Func && ft.NumOut() > 0 {
+				c.funcs[name] = known(ft.Out(0))
+			}
+		}
+	}
+	return c
+}
+
+// known returns nil (i.e. unknown) for interface types, since the dynamic type of an interface
+// value can't be known before execution.
+func known(t reflect.Type) reflect.Type {
+	if t == nil || t.Kind() == reflect.Interface {
+		return nil
+	}
+	return t
+}
+
+func (c *templateChecker) report(tree *parse.Tree, node parse.Node, format string, args ...any) {
+	location := tree.ParseName
+	if node != nil {
+		location, _ = tree.ErrorContext(node)
+	}
+	problem := TemplateProblem{
+		Template: c.checking,
+		Location: location,
+		Problem:  fmt.Sprintf(format, args...),
+	}
+	for _, p := range c.problems {
+		if p == problem {
+			return
+		}
+	}
+	c.problems = append(c.problems, problem)
+}
+
+// checkTemplate checks the named template with the specified type for dot.
+func (c *templateChecker) checkTemplate(name string, dot reflect.Type) {
+	key := fmt.Sprintf("%s\x00%v", name, dot)
+	if c.visited[key] {
+		return
+	}
+	c.visited[key] = true
+
+	tmpl := c.set.Lookup(name)
+	if tmpl == nil || tmpl.Tree == nil {
+		return
+	}
+	c.checkNode(tmpl.Tree, tmpl.Tree.Root, dot, templateScope{"$": dot})
+}
+
+func (c *templateChecker) checkNode(
+	tree *parse.Tree, node parse.Node, dot reflect.Type, scope templateScope,
+) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			c.checkNode(tree, child, dot, scope)
+		}
+	case *parse.ActionNode:
+		c.pipeType(tree, n.Pipe, dot, scope)
+	case *parse.IfNode:
+		scope = scope.child()
+		c.pipeType(tree, n.Pipe, dot, scope)
+		c.checkNode(tree, n.List, dot, scope.child())
+		c.checkNode(tree, n.ElseList, dot, scope.child())
+	case *parse.WithNode:
+		scope = scope.child()
+		t := c.pipeType(tree, n.Pipe, dot, scope)
+		c.checkNode(tree, n.List, indirect(t), scope.child())
+		c.checkNode(tree, n.ElseList, dot, scope.child())
+	case *parse.RangeNode:
+		c.checkRange(tree, n, dot, scope.child())
+	case *parse.TemplateNode:
+		c.checkTemplateCall(tree, n, dot, scope)
+	}
+}
+
+func (c *templateChecker) checkRange(
+	tree *parse.Tree, n *parse.RangeNode, dot reflect.Type, scope templateScope,
+) {
+	// Determine the types of the range's keys and elements
+	var (
+		t     = indirect(c.pipeType(tree, n.Pipe, dot, scope.child()))
+		key   reflect.Type
+		value reflect.Type
+	)
+	if t != nil {
+		switch t.Kind() {
+		case reflect.Array, reflect.Slice:
+			key, value = intType, known(t.Elem())
+		case reflect.Map:
+			key, value = known(t.Key()), known(t.Elem())
+		case reflect.Chan:
+			value = known(t.Elem())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			value = t
+		case reflect.Func:
+			// Iterator functions (e.g. iter.Seq and iter.Seq2) pass their keys and elements as the
+			// parameters of their yield function
+			switch {
+			case t.CanSeq():
+				if len(n.Pipe.Decl) > 1 {
+					c.report(tree, n, "can't iterate over more than one variable of type %s", t)
+				}
+				value = known(t.In(0).In(0))
+			case t.CanSeq2() && len(n.Pipe.Decl) > 1:
+				key, value = known(t.In(0).In(0)), known(t.In(0).In(1))
+			case t.CanSeq2():
+				// With only one variable, the range uses the key as the element
+				value = known(t.In(0).In(0))
+			default:
+				c.report(tree, n, "can't range over value of type %s", t)
+			}
+		default:
+			c.report(tree, n, "can't range over value of type %s", t)
+		}
+	}
+
+	// Declare variables
+	switch len(n.Pipe.Decl) {
+	case 1:
+		scope[n.Pipe.Decl[0].Ident[0]] = value
+	case 2:
+		scope[n.Pipe.Decl[0].Ident[0]] = key
+		scope[n.Pipe.Decl[1].Ident[0]] = value
+	}
+
+	c.checkNode(tree, n.List, indirect(value), scope.child())
+	c.checkNode(tree, n.ElseList, dot, scope.child())
+}
+
+func (c *templateChecker) checkTemplateCall(
+	tree *parse.Tree, n *parse.TemplateNode, dot reflect.Type, scope templateScope,
+) {
+	var t reflect.Type
+	if n.Pipe != nil {
+		t = c.pipeType(tree, n.Pipe, dot, scope.child())
+	}
+	if tmpl := c.set.Lookup(n.Name); tmpl == nil || tmpl.Tree == nil {
+		c.report(tree, n, "template %s is not defined", n.Name)
+		return
+	}
+	if c.partials != nil && filterPartialTemplate(n.Name) {
+		if _, ok := c.partials[n.Name]; !ok {
+			c.report(tree, n, "partial template %s has no template file", n.Name)
+		}
+	}
+	c.checkTemplate(n.Name, indirect(t))
+}
+
+// pipeType checks the pipeline, declares any variables it declares in the scope, and returns the
+// type of the pipeline's result.
+func (c *templateChecker) pipeType(
+	tree *parse.Tree, pipe *parse.PipeNode, dot reflect.Type, scope templateScope,
+) (t reflect.Type) {
+	if pipe == nil {
+		return nil
+	}
+	for _, cmd := range pipe.Cmds {
+		t = c.commandType(tree, cmd, dot, scope)
+	}
+	for _, variable := range pipe.Decl {
+		name := variable.Ident[0]
+		if prev, ok := scope[name]; pipe.IsAssign && ok && prev != t {
+			// The variable may hold values of different types at different points in execution
+			t = nil
+		}
+		scope[name] = t
+	}
+	return t
+}
+
+func (c *templateChecker) commandType(
+	tree *parse.Tree, cmd *parse.CommandNode, dot reflect.Type, scope templateScope,
+) reflect.Type {
+	for _, arg := range cmd.Args[1:] {
+		c.argType(tree, arg, dot, scope)
+	}
+	if ident, ok := cmd.Args[0].(*parse.IdentifierNode); ok {
+		t, ok := c.funcs[ident.Ident]
+		if !ok {
+			return nil
+		}
+		return t
+	}
+	return c.argType(tree, cmd.Args[0], dot, scope)
+}
+
+func (c *templateChecker) argType(
+	tree *parse.Tree, arg parse.Node, dot reflect.Type, scope templateScope,
+) reflect.Type {
+	switch n := arg.(type) {
+	case *parse.DotNode:
+		return dot
+	case *parse.FieldNode:
+		return c.fieldsType(tree, n, dot, n.Ident)
+	case *parse.VariableNode:
+		t, ok := scope[n.Ident[0]]
+		if !ok {
+			c.report(tree, n, "variable %s is not defined", n.Ident[0])
+			return nil
+		}
+		return c.fieldsType(tree, n, t, n.Ident[1:])
+	case *parse.ChainNode:
+		return c.fieldsType(tree, n, c.argType(tree, n.Node, dot, scope), n.Field)
+	case *parse.PipeNode:
+		return c.pipeType(tree, n, dot, scope.child())
+	case *parse.IdentifierNode:
+		return c.funcs[n.Ident]
+	case *parse.BoolNode:
+		return boolType
+	case *parse.StringNode:
+		return stringType
+	case *parse.NumberNode:
+		if n.IsInt {
+			return intType
+		}
+		return floatType
+	default:
+		return nil
+	}
+}
+
+// fieldsType resolves a chain of field, map key, and method names, starting from the type.
+func (c *templateChecker) fieldsType(
+	tree *parse.Tree, node parse.Node, t reflect.Type, names []string,
+) reflect.Type {
+	for i, name := range names {
+		if t == nil {
+			return nil
+		}
+		resolved, ok := fieldType(t, name)
+		if !ok {
+			c.report(
+				tree, node, "can't resolve field .%s on type %s", strings.Join(names[:i+1], "."), t,
+			)
+			return nil
+		}
+		t = resolved
+	}
+	return t
+}
+
+// fieldType resolves the name as a method, struct field, or map key on the type, in the same way
+// as text/template does during execution. It returns a nil type if the resolved type is unknown.
+func fieldType(t reflect.Type, name string) (resolved reflect.Type, ok bool) {
+	if method, ok := t.MethodByName(name); ok {
+		return methodResultType(method.Type), true
+	}
+	if t.Kind() != reflect.Pointer && t.Kind() != reflect.Interface {
+		if method, ok := reflect.PointerTo(t).MethodByName(name); ok {
+			return methodResultType(method.Type), true
+		}
+	}
+
+	t = indirect(t)
+	switch t.Kind() {
+	case reflect.Interface:
+		return nil, true
+	case reflect.Struct:
+		field, ok := t.FieldByName(name)
+		if !ok || !field.IsExported() {
+			return nil, false
+		}
+		return known(field.Type), true
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, false
+		}
+		return known(t.Elem()), true
+	default:
+		return nil, false
+	}
+}
+
+func methodResultType(t reflect.Type) reflect.Type {
+	if t.NumOut() == 0 {
+		return nil
+	}
+	return known(t.Out(0))
+}
+
+// indirect dereferences pointer types.
+func indirect(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+// typeOf returns the type of the example value, or the type of an empty interface if the example
+// value is nil.
+func typeOf(example any) reflect.Type {
+	if example == nil {
+		return anyType
+	}
+	return reflect.TypeOf(example)
+}
+
+// TemplateRenderer: static template checks
+
+// CheckPages statically checks each of the specified page templates (e.g. the pages required with
+// [TemplateRenderer.MustHave]) against the types of the [RenderData] they will be executed with,
+// following all {{template}} calls from the pages. It reports field paths which can't be resolved
+// on those types, calls of templates which are not defined, and calls of partial templates which
+// have no corresponding partial template file. This is meant to be used in tests, so that typos in
+// templates can be caught before pages are rendered.
+func (tr TemplateRenderer) CheckPages(pages map[string]PageTypes) ([]TemplateProblem, error) {
+	tr, err := tr.loaded()
+	if err != nil {
+		return nil, err
+	}
+	partials, err := tr.getPartials()
+	if err != nil {
+		return nil, err
+	}
+	funcs, err := tr.getFuncs()
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []TemplateProblem
+	for _, pageName := range sortedKeys(pages) {
+		types := pages[pageName]
+		page, err := tr.getPage(pageName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't check page template %s", pageName)
+		}
+		inlines := types.Inlines
+		if inlines == nil {
+			inlines = tr.inlines
+		}
+		dot := reflect.StructOf([]reflect.StructField{
+			{Name: "Meta", Type: reflect.TypeFor[RenderDataMeta]()},
+			{Name: "Inlines", Type: typeOf(inlines)},
+			{Name: "Data", Type: typeOf(types.Data)},
+			{Name: "Auth", Type: typeOf(types.Auth)},
+		})
+
+		c := newTemplateChecker(page, partials, funcs)
+		c.checking = pageName
+		c.checkTemplate(pageName, dot)
+		problems = append(problems, c.problems...)
+	}
+	return problems, nil
+}
+
+// CheckPartial statically checks the partial template against the type of the example data it
+// will be executed with, as with [TemplateRenderer.CheckPages].
+func (tr TemplateRenderer) CheckPartial(
+	partialName string, partialData any,
+) ([]TemplateProblem, error) {
+	tr, err := tr.loaded()
+	if err != nil {
+		return nil, err
+	}
+	partials, err := tr.getPartials()
+	if err != nil {
+		return nil, err
+	}
+	funcs, err := tr.getFuncs()
+	if err != nil {
+		return nil, err
+	}
+	partial, ok := partials[partialName]
+	if !ok {
+		return nil, errors.Errorf("partial template %s not found", partialName)
+	}
+
+	c := newTemplateChecker(partial, partials, funcs)
+	c.checking = partialName
+	c.checkTemplate(partialName, known(typeOf(partialData)))
+	return c.problems, nil
+}
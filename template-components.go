@@ -0,0 +1,520 @@
+package godest
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"reflect"
+	"slices"
+	"sync"
+	"text/template/parse"
+
+	"github.com/pkg/errors"
+)
+
+// Component declarations
+
+// Component declares a partial template as a component: a partial template which is executed with
+// typed props and with named slots of HTML content, rather than with arbitrary data. Components are
+// invoked from templates with the component template function, which takes the name of the
+// component followed by alternating names and values of its props and slots, e.g.
+// `{{component "card" "Title" .Data.Title "body" (component "button" "Label" "OK")}}`.
+type Component struct {
+	// Partial is the name of the partial template which renders the component.
+	Partial string
+	// Props is an example value of the struct type of the component's props; its exported fields
+	// are the component's props. Props are required unless their fields are tagged with
+	// `component:"optional"`. If Props is nil, the component has no props.
+	Props any
+	// Slots are the names of the component's slots, which are all optional.
+	Slots []string
+}
+
+// ComponentData is the data which a component's partial template is executed with.
+type ComponentData struct {
+	Props any
+	Slots map[string]template.HTML
+}
+
+const componentOptionalTag = "optional"
+
+// componentProp describes a prop of a component.
+type componentProp struct {
+	index    int
+	optional bool
+	t        reflect.Type
+}
+
+// registeredComponent is a Component whose declaration has been validated.
+type registeredComponent struct {
+	Component
+	propsType reflect.Type
+	props     map[string]componentProp
+}
+
+func newRegisteredComponent(name string, c Component) (rc registeredComponent, err error) {
+	if !filterPartialTemplate(c.Partial) {
+		return registeredComponent{}, errors.Errorf(
+			"component %s must be rendered by a partial template, not %s", name, c.Partial,
+		)
+	}
+	rc.Component = c
+	rc.propsType = reflect.TypeFor[struct{}]()
+	if c.Props != nil {
+		rc.propsType = reflect.TypeOf(c.Props)
+	}
+	if rc.propsType.Kind() != reflect.Struct {
+		return registeredComponent{}, errors.Errorf(
+			"props of component %s must be a struct, not %s", name, rc.propsType,
+		)
+	}
+	rc.props = make(map[string]componentProp)
+	for i := range rc.propsType.NumField() {
+		field := rc.propsType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		rc.props[field.Name] = componentProp{
+			index:    i,
+			optional: field.Tag.Get("component") == componentOptionalTag,
+			t:        field.Type,
+		}
+	}
+	for _, slot := range c.Slots {
+		if _, ok := rc.props[slot]; ok {
+			return registeredComponent{}, errors.Errorf(
+				"component %s has both a prop and a slot named %s", name, slot,
+			)
+		}
+	}
+	return rc, nil
+}
+
+// isArg checks whether the name is the name of a prop or slot of the component.
+func (rc registeredComponent) isArg(name string) bool {
+	_, ok := rc.props[name]
+	return ok || slices.Contains(rc.Slots, name)
+}
+
+// missingProps returns the sorted names of the required props which were not given.
+func (rc registeredComponent) missingProps(given map[string]struct{}) (missing []string) {
+	for _, name := range sortedKeys(rc.props) {
+		if _, ok := given[name]; !ok && !rc.props[name].optional {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// makeData makes the data for executing the component's partial template from the alternating
+// names and values of props and slots passed to the component template function.
+func (rc registeredComponent) makeData(name string, args []any) (d ComponentData, err error) {
+	if len(args)%2 != 0 {
+		return ComponentData{}, errors.Errorf(
+			"component %s needs a value after every prop or slot name", name,
+		)
+	}
+	props := reflect.New(rc.propsType).Elem()
+	d.Slots = make(map[string]template.HTML)
+	given := make(map[string]struct{})
+	for i := 0; i < len(args); i += 2 {
+		argName, ok := args[i].(string)
+		if !ok {
+			return ComponentData{}, errors.Errorf(
+				"component %s needs prop and slot names to be strings, not %T", name, args[i],
+			)
+		}
+		given[argName] = struct{}{}
+		if slices.Contains(rc.Slots, argName) {
+			if d.Slots[argName], err = slotContent(args[i+1]); err != nil {
+				return ComponentData{}, errors.Wrapf(err, "invalid slot %s of component %s", argName, name)
+			}
+			continue
+		}
+		prop, ok := rc.props[argName]
+		if !ok {
+			return ComponentData{}, errors.Errorf("component %s has no prop or slot %s", name, argName)
+		}
+		value, err := propValue(prop.t, args[i+1])
+		if err != nil {
+			return ComponentData{}, errors.Wrapf(err, "invalid prop %s of component %s", argName, name)
+		}
+		props.Field(prop.index).Set(value)
+	}
+	if missing := rc.missingProps(given); len(missing) > 0 {
+		return ComponentData{}, errors.Errorf("component %s is missing props %v", name, missing)
+	}
+	d.Props = props.Interface()
+	return d, nil
+}
+
+// propValue converts the value passed to the component template function into a value of the
+// prop's type.
+func propValue(t reflect.Type, arg any) (reflect.Value, error) {
+	if arg == nil {
+		return reflect.Zero(t), nil
+	}
+	value := reflect.ValueOf(arg)
+	switch {
+	case value.Type().AssignableTo(t):
+		return value, nil
+	case isNumericKind(value.Kind()) && isNumericKind(t.Kind()):
+		return value.Convert(t), nil
+	default:
+		return reflect.Value{}, errors.Errorf("can't use value of type %s as %s", value.Type(), t)
+	}
+}
+
+func isNumericKind(k reflect.Kind) bool {
+	return (k >= reflect.Int && k <= reflect.Uint64) || k == reflect.Float32 || k == reflect.Float64
+}
+
+// slotContent converts the value passed to the component template function into HTML content for a
+// slot. Strings are escaped, while [template.HTML] values (e.g. rendered components) are not.
+func slotContent(arg any) (template.HTML, error) {
+	switch content := arg.(type) {
+	case nil:
+		return "", nil
+	case template.HTML:
+		return content, nil
+	case string:
+		//nolint:gosec // The string is escaped, so it's well-formed
+		return template.HTML(template.HTMLEscapeString(content)), nil
+	default:
+		return "", errors.Errorf("can't use value of type %T as HTML content", arg)
+	}
+}
+
+// Component registry
+
+// componentRegistry holds the components of a TemplateRenderer. It's shared by all copies of the
+// TemplateRenderer, including the snapshots of a hot-reloading TemplateRenderer.
+type componentRegistry struct {
+	mu         sync.RWMutex
+	components map[string]registeredComponent
+}
+
+func newComponentRegistry() *componentRegistry {
+	return &componentRegistry{
+		components: make(map[string]registeredComponent),
+	}
+}
+
+func (r *componentRegistry) register(name string, rc registeredComponent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.components[name]; ok {
+		return errors.Errorf("component %s was already registered", name)
+	}
+	r.components[name] = rc
+	return nil
+}
+
+func (r *componentRegistry) lookup(name string) (rc registeredComponent, ok bool) {
+	if r == nil {
+		return registeredComponent{}, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rc, ok = r.components[name]
+	return rc, ok
+}
+
+func (r *componentRegistry) list() map[string]registeredComponent {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	components := make(map[string]registeredComponent, len(r.components))
+	for name, rc := range r.components {
+		components[name] = rc
+	}
+	return components
+}
+
+// componentPartials holds the pre-loaded partial templates which components are rendered with, so
+// that the component template function can use them even though template functions must be
+// provided before the partial templates are loaded.
+type componentPartials struct {
+	partials map[string]*template.Template
+}
+
+// TemplateRenderer: components
+
+// RegisterComponent adds a component which can be invoked from templates with the component
+// template function. Components should be registered before templates are rendered, since the
+// fingerprints of pages which invoke the component are recomputed to include its partial template.
+// Components can be validated with [TemplateRenderer.MustHaveComponents].
+func (tr TemplateRenderer) RegisterComponent(name string, c Component) error {
+	if tr.components == nil {
+		return errors.New("template renderer was not initialized with a component registry")
+	}
+	rc, err := newRegisteredComponent(name, c)
+	if err != nil {
+		return err
+	}
+	if err = tr.components.register(name, rc); err != nil {
+		return err
+	}
+	return tr.refreshFingerprints()
+}
+
+// refreshFingerprints recomputes the pre-computed fingerprints (if any) with the edges from
+// component invocations to the partial templates of the currently registered components.
+func (tr TemplateRenderer) refreshFingerprints() error {
+	tr, err := tr.loaded()
+	if err != nil {
+		// The next successful reload will compute fingerprints with all registered components
+		return nil
+	}
+	if tr.fingerprints == nil {
+		// Lazy TemplateRenderers compute fingerprints upon each render
+		return nil
+	}
+	preloaded := tr.fingerprints
+	tr.fingerprints = nil
+	refreshed, err := tr.getFingerprints()
+	if err != nil {
+		return errors.Wrap(err, "couldn't recompute fingerprints with registered components")
+	}
+	*preloaded = *refreshed
+	return nil
+}
+
+// componentFuncs returns the component template function.
+func (tr TemplateRenderer) componentFuncs() template.FuncMap {
+	return template.FuncMap{
+		"component": func(name string, args ...any) (template.HTML, error) {
+			rc, ok := tr.components.lookup(name)
+			if !ok {
+				return "", errors.Errorf("component %s was not registered", name)
+			}
+			data, err := rc.makeData(name, args)
+			if err != nil {
+				return "", err
+			}
+
+			var partial *template.Template
+			if tr.componentPartials != nil && tr.componentPartials.partials != nil {
+				if partial, ok = tr.componentPartials.partials[rc.Partial]; !ok {
+					return "", errors.Errorf("partial template %s not found", rc.Partial)
+				}
+			} else if partial, err = tr.getPartial(rc.Partial); err != nil {
+				return "", err
+			}
+			buf := new(bytes.Buffer)
+			if err = partial.ExecuteTemplate(buf, rc.Partial, data); err != nil {
+				return "", errors.Wrapf(err, "couldn't execute component %s", name)
+			}
+			//nolint:gosec // This is generated from trusted templates, so we know it's well-formed
+			return template.HTML(buf.String()), nil
+		},
+	}
+}
+
+// TemplateRenderer: component checks
+
+// CheckComponents statically checks all registered components and all invocations of components in
+// all template files. It reports components whose partial templates don't exist or access fields
+// which their props don't have (as with [TemplateRenderer.CheckPartial]), and invocations of
+// unregistered components, of unknown props or slots, and without required props. Invocations
+// whose component name or prop/slot names aren't string constants are only partially checked.
+func (tr TemplateRenderer) CheckComponents() ([]TemplateProblem, error) {
+	tr, err := tr.loaded()
+	if err != nil {
+		return nil, err
+	}
+	partials, err := tr.getPartials()
+	if err != nil {
+		return nil, err
+	}
+	funcs, err := tr.getFuncs()
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []TemplateProblem
+	components := tr.components.list()
+	for _, name := range sortedKeys(components) {
+		rc := components[name]
+		partial, ok := partials[rc.Partial]
+		if !ok {
+			problems = append(problems, TemplateProblem{
+				Template: rc.Partial,
+				Location: rc.Partial,
+				Problem:  fmt.Sprintf("partial template for component %s not found", name),
+			})
+			continue
+		}
+		dot := reflect.StructOf([]reflect.StructField{
+			{Name: "Props", Type: rc.propsType},
+			{Name: "Slots", Type: reflect.TypeFor[map[string]template.HTML]()},
+		})
+		c := newTemplateChecker(partial, partials, funcs)
+		c.checking = rc.Partial
+		c.checkTemplate(rc.Partial, dot)
+		problems = append(problems, c.problems...)
+	}
+
+	files, err := listFiles(tr.embeds.TemplatesFS, filterTemplate)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't list templates")
+	}
+	for _, file := range files {
+		fileProblems, err := checkComponentCalls(tr.embeds.TemplatesFS, file, components)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, fileProblems...)
+	}
+	return problems, nil
+}
+
+// ShouldHaveComponents returns the first problem reported by [TemplateRenderer.CheckComponents] as
+// an error, if any problems were reported.
+func (tr TemplateRenderer) ShouldHaveComponents() error {
+	problems, err := tr.CheckComponents()
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return problems[0]
+	}
+	return nil
+}
+
+// MustHaveComponents is like [TemplateRenderer.ShouldHaveComponents], but it panics upon any error.
+func (tr TemplateRenderer) MustHaveComponents() {
+	if _, err := tr.loaded(); err != nil {
+		// Templates which failed to hot-reload will instead be reported when rendering pages
+		return
+	}
+	if err := tr.ShouldHaveComponents(); err != nil {
+		panic(err)
+	}
+}
+
+// checkComponentCalls checks all invocations of components in the template file, which is parsed
+// without checking its functions so that it doesn't need to be parsed with all template functions.
+func checkComponentCalls(
+	templatesFS fs.FS, file string, components map[string]registeredComponent,
+) (problems []TemplateProblem, err error) {
+	b, err := fs.ReadFile(templatesFS, file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't read template %s", file)
+	}
+	tree := parse.New(file)
+	tree.Mode = parse.SkipFuncCheck
+	trees := make(map[string]*parse.Tree)
+	if _, err = tree.Parse(string(b), "", "", trees); err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse template %s", file)
+	}
+
+	report := func(node parse.Node, problem string) {
+		location, _ := tree.ErrorContext(node)
+		problems = append(problems, TemplateProblem{
+			Template: file,
+			Location: location,
+			Problem:  problem,
+		})
+	}
+	for _, name := range sortedKeys(trees) {
+		collectFuncCalls(trees[name].Root, "component", func(cmd *parse.CommandNode) {
+			if problem := checkComponentCall(cmd, components); problem != "" {
+				report(cmd, problem)
+			}
+		})
+	}
+	return problems, nil
+}
+
+// checkComponentCall checks the invocation of a component, returning a description of any problem.
+func checkComponentCall(
+	cmd *parse.CommandNode, components map[string]registeredComponent,
+) (problem string) {
+	if len(cmd.Args) < 2 {
+		return "component invocation needs a component name"
+	}
+	nameNode, ok := cmd.Args[1].(*parse.StringNode)
+	if !ok {
+		return ""
+	}
+	name := nameNode.Text
+	rc, ok := components[name]
+	if !ok {
+		return fmt.Sprintf("component %s was not registered", name)
+	}
+	args := cmd.Args[2:]
+	if len(args)%2 != 0 {
+		return fmt.Sprintf("component %s needs a value after every prop or slot name", name)
+	}
+	given := make(map[string]struct{})
+	for i := 0; i < len(args); i += 2 {
+		argNode, ok := args[i].(*parse.StringNode)
+		if !ok {
+			// Props and slots which aren't known until execution may provide any missing props
+			return ""
+		}
+		if !rc.isArg(argNode.Text) {
+			return fmt.Sprintf("component %s has no prop or slot %s", name, argNode.Text)
+		}
+		given[argNode.Text] = struct{}{}
+	}
+	if missing := rc.missingProps(given); len(missing) > 0 {
+		return fmt.Sprintf("component %s is missing props %v", name, missing)
+	}
+	return ""
+}
+
+// collectFuncCalls recursively calls the visit function on every command within the parse tree node
+// which invokes the named template function.
+func collectFuncCalls(node parse.Node, funcName string, visit func(cmd *parse.CommandNode)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectFuncCalls(child, funcName, visit)
+		}
+	case *parse.ActionNode:
+		collectFuncCalls(n.Pipe, funcName, visit)
+	case *parse.IfNode:
+		collectBranchFuncCalls(&n.BranchNode, funcName, visit)
+	case *parse.RangeNode:
+		collectBranchFuncCalls(&n.BranchNode, funcName, visit)
+	case *parse.WithNode:
+		collectBranchFuncCalls(&n.BranchNode, funcName, visit)
+	case *parse.TemplateNode:
+		collectFuncCalls(n.Pipe, funcName, visit)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			collectFuncCalls(cmd, funcName, visit)
+		}
+	case *parse.CommandNode:
+		if ident, ok := n.Args[0].(*parse.IdentifierNode); ok && ident.Ident == funcName {
+			visit(n)
+		}
+		for _, arg := range n.Args {
+			collectFuncCalls(arg, funcName, visit)
+		}
+	case *parse.ChainNode:
+		collectFuncCalls(n.Node, funcName, visit)
+	}
+}
+
+func collectBranchFuncCalls(
+	n *parse.BranchNode, funcName string, visit func(cmd *parse.CommandNode),
+) {
+	collectFuncCalls(n.Pipe, funcName, visit)
+	collectFuncCalls(n.List, funcName, visit)
+	collectFuncCalls(n.ElseList, funcName, visit)
+}
@@ -0,0 +1,236 @@
+package godest
+
+import (
+	"bytes"
+	"html"
+	"html/template"
+	"io"
+	"io/fs"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/sargassum-world/godest/email"
+)
+
+// Email template files
+
+// An email has a plain-text variant, an HTML variant, or both, e.g.
+// "notifications/welcome.text.email.tmpl" and "notifications/welcome.html.email.tmpl" for the
+// email named "notifications/welcome".
+const (
+	textEmailTemplateFileExt = ".text" + emailTemplateFileExt
+	htmlEmailTemplateFileExt = ".html" + emailTemplateFileExt
+	emailSubjectTemplateName = "subject"
+)
+
+func filterTextEmailTemplate(path string) bool {
+	return strings.HasSuffix(path, textEmailTemplateFileExt)
+}
+
+func filterHTMLEmailTemplate(path string) bool {
+	return strings.HasSuffix(path, htmlEmailTemplateFileExt)
+}
+
+// instantiateTextTemplates makes a text template set for each template file selected by the filter
+// (e.g. plain-text email templates). Unlike HTML email templates, text templates can't use layouts
+// or partials, since those are HTML templates.
+func instantiateTextTemplates(
+	templatesFS fs.FS, funcs []template.FuncMap, filter func(path string) bool,
+) (templates map[string]*texttemplate.Template, err error) {
+	templates = make(map[string]*texttemplate.Template)
+	templateFiles, err := listFiles(templatesFS, filter)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't list templates")
+	}
+	for _, name := range templateFiles {
+		b, err := fs.ReadFile(templatesFS, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't read template %s", name)
+		}
+		tmpl := texttemplate.New(name)
+		for _, f := range funcs {
+			tmpl = tmpl.Funcs(texttemplate.FuncMap(f))
+		}
+		if templates[name], err = tmpl.Parse(string(b)); err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse template %s", name)
+		}
+	}
+	return templates, nil
+}
+
+// Email rendering options
+
+// emailConfig holds the configuration for rendering an email.
+type emailConfig struct {
+	locale       string
+	inlineCSS    bool
+	stylesheets  bool
+	appURLPrefix string
+}
+
+// EmailOption modifies the configuration for rendering an email. For use with
+// [TemplateRenderer.RenderEmail].
+type EmailOption func(c *emailConfig)
+
+// WithEmailLocale creates an [EmailOption] to render the email in the locale, instead of in the
+// TemplateRenderer's DefaultLocale.
+func WithEmailLocale(locale string) EmailOption {
+	return func(c *emailConfig) {
+		c.locale = locale
+	}
+}
+
+// WithEmailStylesheets creates an [EmailOption] to inline stylesheets which are linked from the
+// HTML variant of the email with URLs under the URL prefix (e.g. the prefix passed to
+// [Embeds.GetAppHashedNamer]), by looking them up in the Embeds' AppFS.
+func WithEmailStylesheets(appURLPrefix string) EmailOption {
+	return func(c *emailConfig) {
+		c.stylesheets = true
+		c.appURLPrefix = appURLPrefix
+	}
+}
+
+// WithoutEmailCSSInlining creates an [EmailOption] to leave the styles of the HTML variant of the
+// email in style elements and stylesheet links, rather than inlining them into style attributes.
+func WithoutEmailCSSInlining() EmailOption {
+	return func(c *emailConfig) {
+		c.inlineCSS = false
+	}
+}
+
+// TemplateRenderer: email rendering
+
+// RenderEmail renders the plain-text and HTML variants of the email into a message with the
+// subject and the bodies of the email. The variants are rendered with [RenderData] which has the
+// TemplateRenderer's Inlines, the email data, and metadata without any request-dependent values.
+// The subject is rendered from the "subject" template defined by the plain-text variant or, if the
+// plain-text variant doesn't exist or doesn't define a subject, by the HTML variant. HTML email
+// templates follow the same module conventions as page templates, so they can extend layouts and
+// use partials and components. Styles in the HTML variant are inlined into style attributes (see
+// [WithEmailStylesheets] and [WithoutEmailCSSInlining]). Sender and recipients must be added to
+// the message before it can be sent with an [email.Sender].
+func (tr TemplateRenderer) RenderEmail(
+	emailName string, emailData any, opts ...EmailOption,
+) (m email.Message, err error) {
+	tr, err = tr.loaded()
+	if err != nil {
+		return email.Message{}, err
+	}
+	start := time.Now()
+	defer func() {
+		tr.observeRender(EmailRender, emailName, start, len(m.Text)+len(m.HTML), err)
+	}()
+	c := emailConfig{
+		locale:    tr.DefaultLocale,
+		inlineCSS: true,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	data := tr.NewRenderData(nil, emailData, nil)
+	data.Meta.Locale = c.locale
+
+	textEmails, err := tr.getTextEmails()
+	if err != nil {
+		return email.Message{}, err
+	}
+	htmlEmails, err := tr.getHTMLEmails()
+	if err != nil {
+		return email.Message{}, err
+	}
+	textName, htmlName := emailName+textEmailTemplateFileExt, emailName+htmlEmailTemplateFileExt
+	textTmpl, hasText := textEmails[textName]
+	htmlTmpl, hasHTML := htmlEmails[htmlName]
+	if !hasText && !hasHTML {
+		return email.Message{}, errors.Errorf("email template %s not found", emailName)
+	}
+
+	if hasText {
+		if m.Text, err = executeEmailTemplate(textTmpl, textName, data); err != nil {
+			return email.Message{}, err
+		}
+		if textTmpl.Lookup(emailSubjectTemplateName) != nil {
+			if m.Subject, err = executeEmailTemplate(
+				textTmpl, emailSubjectTemplateName, data,
+			); err != nil {
+				return email.Message{}, err
+			}
+		}
+	}
+	if hasHTML {
+		if m.HTML, err = executeEmailTemplate(htmlTmpl, htmlName, data); err != nil {
+			return email.Message{}, err
+		}
+		if m.Subject == "" && htmlTmpl.Lookup(emailSubjectTemplateName) != nil {
+			if m.Subject, err = executeEmailTemplate(
+				htmlTmpl, emailSubjectTemplateName, data,
+			); err != nil {
+				return email.Message{}, err
+			}
+			m.Subject = html.UnescapeString(m.Subject)
+		}
+		if c.inlineCSS {
+			var stylesheetsFS fs.FS
+			if c.stylesheets {
+				stylesheetsFS = tr.embeds.AppFS
+			}
+			if m.HTML, err = inlineCSS(
+				m.HTML, stylesheetsFS, c.appURLPrefix, tr.embeds.unhashAppName,
+			); err != nil {
+				return email.Message{}, errors.Wrapf(err, "couldn't inline CSS in email %s", emailName)
+			}
+		}
+	}
+	m.Subject = strings.Join(strings.Fields(m.Subject), " ")
+	return m, nil
+}
+
+// executeEmailTemplate executes the named template from the template set of an email variant.
+func executeEmailTemplate(
+	tmpl interface {
+		ExecuteTemplate(w io.Writer, name string, data any) error
+	},
+	templateName string, data RenderData,
+) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := tmpl.ExecuteTemplate(buf, templateName, data); err != nil {
+		return "", errors.Wrapf(err, "couldn't execute email template %s", templateName)
+	}
+	return buf.String(), nil
+}
+
+func (tr TemplateRenderer) getTextEmails() (
+	emails map[string]*texttemplate.Template, err error,
+) {
+	emails = tr.textEmailTemplates
+	if emails == nil {
+		funcs, err := tr.getFuncs()
+		if err != nil {
+			return nil, err
+		}
+		if emails, err = instantiateTextTemplates(
+			tr.embeds.TemplatesFS, funcs, filterTextEmailTemplate,
+		); err != nil {
+			return nil, errors.Wrap(err, "couldn't instantiate plain-text email templates")
+		}
+	}
+	return emails, nil
+}
+
+func (tr TemplateRenderer) getHTMLEmails() (emails map[string]*template.Template, err error) {
+	emails = tr.htmlEmailTemplates
+	if emails == nil {
+		root, err := tr.getRoot()
+		if err != nil {
+			return nil, err
+		}
+		emails, err = instantiateModuleTemplates(tr.embeds.TemplatesFS, root, filterHTMLEmailTemplate)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't instantiate HTML email templates")
+		}
+	}
+	return emails, nil
+}
@@ -0,0 +1,142 @@
+package godest
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+
+	"github.com/sargassum-world/godest/httperr"
+	"github.com/sargassum-world/godest/turbostreams"
+)
+
+// Error pages
+
+// ErrorData is the template data for rendering error pages and error Turbo Streams messages.
+type ErrorData struct {
+	httperr.DescriptiveError
+	// Code is the HTTP status code of the error.
+	Code int
+	// Message is the message of the error which can be shown to users (see [httperr.Message]), or an
+	// empty string if the error has no such message.
+	Message string
+}
+
+// errorHandlerConfig holds the configuration of an error handler.
+type errorHandlerConfig struct {
+	pageName     string
+	streamTarget string
+	partialName  string
+	devMode      bool
+	getAuth      func(c echo.Context) (any, error)
+}
+
+// ErrorHandlerOption modifies the configuration of an error handler. For use with
+// [TemplateRenderer.NewHTTPErrorHandler].
+type ErrorHandlerOption func(c *errorHandlerConfig)
+
+// WithErrorTurboStream creates an [ErrorHandlerOption] to respond to requests which accept Turbo
+// Streams messages (e.g. form submissions) with a message which replaces the contents of the target
+// element with the partial template, rendered with the [ErrorData].
+func WithErrorTurboStream(target, partialName string) ErrorHandlerOption {
+	return func(c *errorHandlerConfig) {
+		c.streamTarget = target
+		c.partialName = partialName
+	}
+}
+
+// WithErrorDevMode creates an [ErrorHandlerOption] to show internal error messages in error
+// responses if dev mode is enabled. Internal error messages should never be shown in production,
+// since they may reveal internal details of the server.
+func WithErrorDevMode(devMode bool) ErrorHandlerOption {
+	return func(c *errorHandlerConfig) {
+		c.devMode = devMode
+	}
+}
+
+// WithErrorAuth creates an [ErrorHandlerOption] to look up the auth data for rendering error pages,
+// e.g. for a navigation bar which shows whether the user is signed in. If looking up the auth data
+// fails, the error page is rendered with nil auth data.
+func WithErrorAuth(getAuth func(c echo.Context) (any, error)) ErrorHandlerOption {
+	return func(c *errorHandlerConfig) {
+		c.getAuth = getAuth
+	}
+}
+
+// NewHTTPErrorHandler creates an echo.HTTPErrorHandler which responds to failed requests with the
+// error page template, rendered with [ErrorData] for the status code determined by
+// [httperr.Status]. Requests which accept Turbo Streams messages are instead answered with a Turbo
+// Streams message, if one is configured with [WithErrorTurboStream]. Server errors are logged with
+// the echo.Context's logger, and requests canceled by the client are not answered at all. If the
+// error page can't be rendered, a plain-text error response is sent instead.
+func (tr TemplateRenderer) NewHTTPErrorHandler(
+	pageName string, opts ...ErrorHandlerOption,
+) echo.HTTPErrorHandler {
+	c := errorHandlerConfig{
+		pageName: pageName,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return func(err error, ec echo.Context) {
+		code := httperr.Status(err)
+		if code >= http.StatusInternalServerError {
+			ec.Logger().Error(err)
+		}
+		if ec.Response().Committed || code == httperr.StatusClientClosedRequest {
+			return
+		}
+
+		data := ErrorData{
+			DescriptiveError: httperr.Describe(code),
+			Code:             code,
+			Message:          httperr.Message(err, c.devMode),
+		}
+		if rerr := tr.writeError(ec, c, data); rerr != nil {
+			ec.Logger().Error(errors.Wrapf(rerr, "couldn't render error response for %s", err))
+			if ec.Response().Committed {
+				return
+			}
+			WithUncacheable()(ec.Response().Header())
+			if rerr = ec.String(code, data.Name+": "+data.Description); rerr != nil {
+				ec.Logger().Error(errors.Wrap(rerr, "couldn't send plain-text error response"))
+			}
+		}
+	}
+}
+
+// writeError writes the error response for the error data.
+func (tr TemplateRenderer) writeError(ec echo.Context, c errorHandlerConfig, data ErrorData) error {
+	r := ec.Request()
+	w := ec.Response()
+	WithUncacheable()(w.Header())
+	if r.Method == http.MethodHead {
+		return ec.NoContent(data.Code)
+	}
+
+	if c.partialName != "" && turbostreams.Accepted(r.Header) {
+		// The message is rendered before anything is written, so that a plain-text error response can
+		// still be sent if rendering fails
+		buf := new(bytes.Buffer)
+		if err := tr.WriteTurboStream(buf, turbostreams.Message{
+			Action:   turbostreams.ActionUpdate,
+			Target:   c.streamTarget,
+			Template: c.partialName,
+			Data:     data,
+		}); err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", turbostreams.ContentType+"; charset=utf-8")
+		w.WriteHeader(data.Code)
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+
+	var auth any
+	if c.getAuth != nil {
+		// The error page should still be shown even if the auth data is unavailable
+		auth, _ = c.getAuth(ec)
+	}
+	return tr.Page(w, r, data.Code, c.pageName, data, auth)
+}
@@ -0,0 +1,323 @@
+package godest
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Required pages
+
+// requiredTemplates records the names of all templates which were required by
+// [TemplateRenderer.MustHave] or [TemplateRenderer.ShouldHave]. It's shared by all copies of the
+// TemplateRenderer, including the snapshots of a hot-reloading TemplateRenderer.
+type requiredTemplates struct {
+	mu    sync.Mutex
+	names map[string]struct{}
+}
+
+func newRequiredTemplates() *requiredTemplates {
+	return &requiredTemplates{
+		names: make(map[string]struct{}),
+	}
+}
+
+func (r *requiredTemplates) add(templateNames ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range templateNames {
+		r.names[name] = struct{}{}
+	}
+}
+
+// RequiredPages returns the sorted names of all page templates which were required by
+// [TemplateRenderer.MustHave] or [TemplateRenderer.ShouldHave].
+func (tr TemplateRenderer) RequiredPages() []string {
+	if tr.required == nil {
+		return nil
+	}
+	tr.required.mu.Lock()
+	defer tr.required.mu.Unlock()
+
+	var pages []string
+	for _, name := range sortedKeys(tr.required.names) {
+		if filterPageTemplate(name) {
+			pages = append(pages, name)
+		}
+	}
+	return pages
+}
+
+// Rendering to strings
+
+// RenderPage renders the page template with the [RenderData] for the request into a string,
+// rather than into an HTTP response.
+func (tr TemplateRenderer) RenderPage(
+	r *http.Request, pageName string, templateData any, authData any,
+) (string, error) {
+	if err := r.ParseForm(); err != nil {
+		return "", errors.Wrap(err, "couldn't parse URL query values and/or POST/PUT/PATCH request body")
+	}
+	var b strings.Builder
+	if err := tr.WritePage(&b, pageName, tr.NewRenderData(r, templateData, authData)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// RenderPartial renders the partial template with the data into a string.
+func (tr TemplateRenderer) RenderPartial(partialName string, partialData any) (string, error) {
+	var b strings.Builder
+	if err := tr.WritePartial(&b, partialName, partialData); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Static site export
+
+// ExportRoute is a route of the static site, which is exported by rendering a page template.
+type ExportRoute struct {
+	// Path is the URL path of the route, e.g. "/docs/intro".
+	Path string
+	// Page is the name of the page template which renders the route.
+	Page string
+	// Data is the template data for rendering the page.
+	Data any
+	// Auth is the auth data for rendering the page.
+	Auth any
+}
+
+// exportConfig holds the configuration of a static site export.
+type exportConfig struct {
+	appURLPrefix    string
+	staticURLPrefix string
+	fontsURLPrefix  string
+	assetBaseURL    string
+	pagePath        func(pageName string) string
+}
+
+// ExportOption modifies the configuration of a static site export. For use with
+// [TemplateRenderer.ExportStaticSite].
+type ExportOption func(c *exportConfig)
+
+// WithExportAssets creates an [ExportOption] to copy the Embeds' AppFS, StaticFS, and FontsFS into
+// the static site under the URL prefixes which the page templates use for them (e.g. the prefixes
+// passed to [Embeds.GetAppHashedNamer] and [Embeds.GetStaticHashedNamer]). Files in the AppFS and
+// StaticFS are copied with hashed names, and files in the FontsFS are copied with their original
+// names. Empty prefixes skip copying of the corresponding filesystems.
+func WithExportAssets(appURLPrefix, staticURLPrefix, fontsURLPrefix string) ExportOption {
+	return func(c *exportConfig) {
+		c.appURLPrefix = appURLPrefix
+		c.staticURLPrefix = staticURLPrefix
+		c.fontsURLPrefix = fontsURLPrefix
+	}
+}
+
+// WithExportAssetBaseURL creates an [ExportOption] to rewrite asset URLs in exported pages to be
+// under the base URL (e.g. the URL of a CDN, or the path under which the static site will be
+// published), instead of rewriting them to be relative to each page.
+func WithExportAssetBaseURL(baseURL string) ExportOption {
+	return func(c *exportConfig) {
+		c.assetBaseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithExportPagePaths creates an [ExportOption] to determine the URL path of each page template
+// which is exported when no routes are specified, instead of deriving it from the name of the
+// page template.
+func WithExportPagePaths(pagePath func(pageName string) string) ExportOption {
+	return func(c *exportConfig) {
+		c.pagePath = pagePath
+	}
+}
+
+// defaultPagePath derives the URL path of a page template from its name, e.g. "/docs/intro" for
+// "docs/intro.page.tmpl", and "/docs/" for "docs/index.page.tmpl".
+func defaultPagePath(pageName string) string {
+	name := strings.TrimSuffix(strings.TrimSuffix(pageName, templateFileExt), ".page")
+	if path.Base(name) == "index" {
+		return "/" + strings.TrimSuffix(strings.TrimSuffix(name, "index"), "/") + "/"
+	}
+	return "/" + name
+}
+
+// exportFilePath determines the path of the file in the static site for the URL path of a route:
+// routes without file extensions are exported as index.html files in their own directories, so
+// that they can be served by static file servers at their original URL paths.
+func exportFilePath(urlPath string) string {
+	cleaned := path.Clean("/" + urlPath)
+	switch {
+	case strings.HasSuffix(urlPath, "/") || cleaned == "/":
+		return path.Join(cleaned, "index.html")[1:]
+	case path.Ext(cleaned) != "":
+		return cleaned[1:]
+	default:
+		return path.Join(cleaned, "index.html")[1:]
+	}
+}
+
+// assetURLRewriter rewrites asset URLs in rendered pages.
+type assetURLRewriter struct {
+	prefixes []string
+	patterns []*regexp.Regexp
+}
+
+func newAssetURLRewriter(prefixes ...string) assetURLRewriter {
+	var rw assetURLRewriter
+	for _, prefix := range prefixes {
+		if prefix == "" || slices.Contains(rw.prefixes, prefix) {
+			continue
+		}
+		rw.prefixes = append(rw.prefixes, prefix)
+		// Only URLs at the start of attribute values, CSS url() arguments, and srcset candidates are
+		// rewritten
+		rw.patterns = append(
+			rw.patterns, regexp.MustCompile(`([\s"'(=,])`+regexp.QuoteMeta(prefix)),
+		)
+	}
+	return rw
+}
+
+// rewrite replaces the asset URL prefixes in the page with the base URL (which may be relative).
+func (rw assetURLRewriter) rewrite(page, baseURL string) string {
+	for i, prefix := range rw.prefixes {
+		rewritten := baseURL + "/" + strings.TrimPrefix(prefix, "/")
+		page = rw.patterns[i].ReplaceAllString(page, "${1}"+strings.ReplaceAll(rewritten, "$", "$$"))
+	}
+	return page
+}
+
+// ExportStaticSite renders the routes into a static site in the directory, and copies the assets
+// from the Embeds into the static site (see [WithExportAssets]). If no routes are specified, all
+// page templates which were required by [TemplateRenderer.MustHave] are exported with nil data, at
+// URL paths derived from their names (see [WithExportPagePaths]). Asset URLs in exported pages are
+// rewritten to be relative to each page (or to be under the base URL specified with
+// [WithExportAssetBaseURL]), so that the static site can be published under any path.
+func (tr TemplateRenderer) ExportStaticSite(
+	ctx context.Context, dir string, routes []ExportRoute, opts ...ExportOption,
+) error {
+	tr, err := tr.loaded()
+	if err != nil {
+		return err
+	}
+	c := exportConfig{
+		pagePath: defaultPagePath,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if len(routes) == 0 {
+		for _, pageName := range tr.RequiredPages() {
+			routes = append(routes, ExportRoute{Path: c.pagePath(pageName), Page: pageName})
+		}
+	}
+
+	rewriter := newAssetURLRewriter(c.appURLPrefix, c.staticURLPrefix, c.fontsURLPrefix)
+	for _, route := range routes {
+		if err := tr.exportRoute(ctx, dir, route, c, rewriter); err != nil {
+			return errors.Wrapf(err, "couldn't export route %s", route.Path)
+		}
+	}
+
+	return tr.embeds.exportAssets(dir, c)
+}
+
+func (tr TemplateRenderer) exportRoute(
+	ctx context.Context, dir string, route ExportRoute, c exportConfig, rewriter assetURLRewriter,
+) error {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, route.Path, nil)
+	if err != nil {
+		return errors.Wrap(err, "couldn't generate HTTP request to render page")
+	}
+	page, err := tr.RenderPage(r, route.Page, route.Data, route.Auth)
+	if err != nil {
+		return err
+	}
+
+	file := exportFilePath(route.Path)
+	baseURL := c.assetBaseURL
+	if baseURL == "" {
+		baseURL = "."
+		if depth := strings.Count(file, "/"); depth > 0 {
+			baseURL = strings.TrimSuffix(strings.Repeat("../", depth), "/")
+		}
+	}
+	return writeExportFile(dir, file, []byte(rewriter.rewrite(page, baseURL)))
+}
+
+// exportAssets copies the Embeds' AppFS, StaticFS, and FontsFS into the static site.
+func (e Embeds) exportAssets(dir string, c exportConfig) error {
+	if c.appURLPrefix != "" {
+		if err := exportFiles(dir, c.appURLPrefix, e.AppFS, e.GetAppHashedNamer("")); err != nil {
+			return errors.Wrap(err, "couldn't export app assets")
+		}
+	}
+	if c.staticURLPrefix != "" {
+		if err := exportFiles(
+			dir, c.staticURLPrefix, e.StaticFS, e.GetStaticHashedNamer(""),
+		); err != nil {
+			return errors.Wrap(err, "couldn't export static assets")
+		}
+	}
+	if c.fontsURLPrefix != "" {
+		if err := exportFiles(dir, c.fontsURLPrefix, e.FontsFS, nil); err != nil {
+			return errors.Wrap(err, "couldn't export fonts")
+		}
+	}
+	return nil
+}
+
+// exportFiles copies all files in the filesystem into the static site under the URL prefix, with
+// names determined by the hashed namer (if it's not nil).
+func exportFiles(dir, urlPrefix string, fsys fs.FS, hashedNamer func(string) string) error {
+	if fsys == nil {
+		return nil
+	}
+	files, err := listFiles(fsys, nil)
+	if err != nil {
+		return errors.Wrap(err, "couldn't list files")
+	}
+	for _, file := range files {
+		data, err := readFile(file, fsys)
+		if err != nil {
+			return errors.Wrapf(err, "couldn't read %s", file)
+		}
+		name := file
+		if hashedNamer != nil {
+			name = hashedNamer(file)
+		}
+		if err = writeExportFile(
+			dir, path.Join(strings.TrimPrefix(urlPrefix, "/"), name), data,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeExportFile(dir, file string, data []byte) error {
+	path := filepath.Clean(filepath.Join(dir, filepath.FromSlash(file)))
+	const dirPerm = 0o755 // owner rwx, group rx, public rx
+	if err := os.MkdirAll(filepath.Dir(path), dirPerm); err != nil {
+		return errors.Wrapf(err, "couldn't create parent directory for file %s", path)
+	}
+	const filePerm = 0o644 // owner rw, group r, public r
+	if err := os.WriteFile(path, data, filePerm); err != nil {
+		return errors.Wrapf(err, "couldn't write %s", path)
+	}
+	return nil
+}
@@ -15,6 +15,8 @@ const (
 	templateFileExt        = ".tmpl"
 	partialTemplateFileExt = ".partial" + templateFileExt
 	layoutTemplateFileExt  = ".layout" + templateFileExt
+	emailTemplateFileExt   = ".email" + templateFileExt
+	workerTemplateFileExt  = ".worker" + templateFileExt
 	templateSharedModule   = "shared"
 )
 
@@ -38,14 +40,19 @@ func filterNonpageTemplate(path string) bool {
 	return filterPartialTemplate(path) || filterLayoutTemplate(path)
 }
 
+func filterEmailTemplate(path string) bool {
+	return strings.HasSuffix(path, emailTemplateFileExt)
+}
+
+func filterWorkerTemplate(path string) bool {
+	return strings.HasSuffix(path, workerTemplateFileExt)
+}
+
 func filterPageTemplate(path string) bool {
 	// Usually a page ends with ".page.tmpl", but it may end with other things,
 	// e.g. ".webmanifest.tmpl", ".json.tmpl", etc.
-	return filterTemplate(path) && !filterNonpageTemplate(path)
-}
-
-func filterTemplateModule(path string) bool {
-	return path != templateSharedModule
+	return filterTemplate(path) && !filterNonpageTemplate(path) && !filterEmailTemplate(path) &&
+		!filterWorkerTemplate(path)
 }
 
 // Built Asset File Filters
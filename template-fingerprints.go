@@ -4,7 +4,6 @@ import (
 	"bytes"
 	_ "embed"
 	"encoding/json"
-	"net/http"
 
 	"github.com/pkg/errors"
 )
@@ -12,35 +11,22 @@ import (
 type fingerprints struct {
 	app  string
 	page map[string]string
+	// fragment maps each page to the fingerprints of the blocks and other named templates in the
+	// page's template set.
+	fragment map[string]map[string]string
 }
 
-func (f fingerprints) setAndCheckEtag(
-	w http.ResponseWriter, r *http.Request, templateName string, data any,
-) (noContent bool, err error) {
-	// Look up data-independent etag segments
-	templateEtagSegments, err := f.getEtagSegments(templateName)
-	if err != nil {
-		return false, err
-	}
-
+func computeDataFingerprint(data any) (string, error) {
 	// Encode data
 	var buf bytes.Buffer
 	// github.com/vmihailenco/msgpack has better performance, but we use the JSON encoder because
 	// the msgpack encoder can only sort the map keys of map[string]string and map[string]interface{}
 	// maps, and it's too much trouble to convert our maps into map[string]interface{}. If we can
 	// work around this limitation, we should use msgpack though.
-	if err = json.NewEncoder(&buf).Encode(data); err != nil {
-		return false, err
-	}
-	encoded := buf.Bytes()
-
-	noContent = setAndCheckEtag(
-		w, r, append(templateEtagSegments, computeFingerprint(encoded))...,
-	)
-	if noContent {
-		w.WriteHeader(http.StatusNotModified)
+	if err := json.NewEncoder(&buf).Encode(data); err != nil {
+		return "", err
 	}
-	return noContent, nil
+	return computeFingerprint(buf.Bytes()), nil
 }
 
 func (f fingerprints) getEtagSegments(templateName string) ([]string, error) {
@@ -66,3 +52,17 @@ func (f fingerprints) shouldHaveForPage(templateName string) error {
 	}
 	return nil
 }
+
+// fragmentEtagSegments creates a function to look up the data-independent etag segments of the
+// named block or other named template within the page.
+func (f fingerprints) fragmentEtagSegments(pageName string) func(string) ([]string, error) {
+	return func(blockName string) ([]string, error) {
+		fragmentFingerprint, ok := f.fragment[pageName][blockName]
+		if !ok {
+			return []string{f.app}, errors.Errorf(
+				"couldn't find fingerprint for block %s of page template %s", blockName, pageName,
+			)
+		}
+		return []string{f.app, fragmentFingerprint}, nil
+	}
+}
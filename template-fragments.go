@@ -0,0 +1,133 @@
+package godest
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// TemplateRenderer: fragment rendering
+
+// Fragment is like [TemplateRenderer.Page], except that it only renders the named block (or other
+// named template) from the page's template set, with the same [RenderData] as the page. For a
+// Turbo Frame, the block should render the entire <turbo-frame> element.
+func (tr TemplateRenderer) Fragment(
+	w http.ResponseWriter, r *http.Request,
+	status int, pageName, blockName string, templateData any, authData any,
+	headerOptions ...HeaderOption,
+) error {
+	tr, err := tr.loaded()
+	if err != nil {
+		return writeReloadError(w, err)
+	}
+	ok, err := tr.hasBlock(pageName, blockName)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.Errorf("page template %s has no block %s", pageName, blockName)
+	}
+	return tr.executePage(
+		w, r, status, pageName, blockName, templateData, authData, headerOptions...,
+	)
+}
+
+// CacheableFragment is like [TemplateRenderer.CacheablePage], except that it only renders the named
+// block from the page's template set (as with [TemplateRenderer.Fragment]). The etag is computed
+// from the fingerprint of only the template files reachable from the block, rather than from the
+// fingerprint of the whole page.
+func (tr TemplateRenderer) CacheableFragment(
+	w http.ResponseWriter, r *http.Request,
+	pageName, blockName string, templateData any, authData any,
+	headerOptions ...HeaderOption,
+) error {
+	tr, err := tr.loaded()
+	if err != nil {
+		return writeReloadError(w, err)
+	}
+	fingerprints, err := tr.getFingerprints()
+	if err != nil {
+		return err
+	}
+
+	if noContent, err := tr.setAndCheckPageEtag(
+		w, r, fingerprints.fragmentEtagSegments(pageName), blockName, templateData, authData,
+	); noContent || (err != nil) {
+		return err
+	}
+	return tr.Fragment(
+		w, r, http.StatusOK, pageName, blockName, templateData, authData, headerOptions...,
+	)
+}
+
+// FramePage renders only the block named by the request's Turbo-Frame header (as with
+// [TemplateRenderer.Fragment]) if the page's template set has such a block, and otherwise it
+// renders the whole page (as with [TemplateRenderer.Page]).
+func (tr TemplateRenderer) FramePage(
+	w http.ResponseWriter, r *http.Request,
+	status int, pageName string, templateData any, authData any,
+	headerOptions ...HeaderOption,
+) error {
+	WithVary(TurboFrameHeader)(w.Header())
+	blockName, err := tr.frameBlock(r, pageName)
+	if err != nil {
+		return err
+	}
+	if blockName == "" {
+		return tr.Page(w, r, status, pageName, templateData, authData, headerOptions...)
+	}
+	return tr.Fragment(w, r, status, pageName, blockName, templateData, authData, headerOptions...)
+}
+
+// CacheableFramePage is like [TemplateRenderer.FramePage], except that it renders the block with
+// [TemplateRenderer.CacheableFragment] or the page with [TemplateRenderer.CacheablePage].
+func (tr TemplateRenderer) CacheableFramePage(
+	w http.ResponseWriter, r *http.Request,
+	pageName string, templateData any, authData any,
+	headerOptions ...HeaderOption,
+) error {
+	WithVary(TurboFrameHeader)(w.Header())
+	blockName, err := tr.frameBlock(r, pageName)
+	if err != nil {
+		return err
+	}
+	if blockName == "" {
+		return tr.CacheablePage(w, r, pageName, templateData, authData, headerOptions...)
+	}
+	return tr.CacheableFragment(
+		w, r, pageName, blockName, templateData, authData, headerOptions...,
+	)
+}
+
+// frameBlock returns the name of the block of the page which should be rendered for the Turbo
+// Frame requested by the request, or an empty string if the whole page should be rendered.
+func (tr TemplateRenderer) frameBlock(r *http.Request, pageName string) (string, error) {
+	frame := GetTurboRequest(r).Frame
+	if frame == "" {
+		return "", nil
+	}
+	tr, err := tr.loaded()
+	if err != nil {
+		// The reload error will be reported when the page is rendered
+		return "", nil
+	}
+	ok, err := tr.hasBlock(pageName, frame)
+	if err != nil || !ok {
+		return "", err
+	}
+	return frame, nil
+}
+
+// hasBlock checks whether the page's template set has a block (or other named template) with the
+// name, excluding the templates named after template files.
+func (tr TemplateRenderer) hasBlock(pageName, blockName string) (bool, error) {
+	page, err := tr.getPage(pageName)
+	if err != nil {
+		return false, err
+	}
+	if filterTemplate(blockName) {
+		return false, nil
+	}
+	tmpl := page.Lookup(blockName)
+	return tmpl != nil && tmpl.Tree != nil, nil
+}
@@ -0,0 +1,314 @@
+package godest
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/language"
+	textmessage "golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// Template function library. Each group of functions is opt-in, by passing it to
+// [NewTemplateRenderer] (or another TemplateRenderer constructor) together with the app's own
+// template functions. None of the functions return pre-escaped content, so their results are
+// escaped by html/template like any other value.
+
+// Collections
+
+// CollectionFuncs returns template functions for making and inspecting maps and lists:
+//   - dict makes a map from alternating keys and values, e.g. `{{dict "Name" .Name "Size" 3}}`,
+//     which is useful for passing multiple values to a partial template.
+//   - list makes a list from its arguments, e.g. `{{list "a" "b" "c"}}`.
+//   - append makes a new list from a list and additional items, e.g. `{{append $items "d"}}`.
+//   - hasKey checks whether a map has a key, e.g. `{{if hasKey $dict "Name"}}`.
+//   - keys returns the sorted keys of a map with string keys, e.g. `{{range keys $dict}}`.
+func CollectionFuncs() template.FuncMap {
+	return template.FuncMap{
+		"dict":   dict,
+		"list":   list,
+		"append": appendList,
+		"hasKey": hasKey,
+		"keys":   mapKeys,
+	}
+}
+
+func dict(keysAndValues ...any) (map[string]any, error) {
+	if len(keysAndValues)%2 != 0 {
+		return nil, errors.Errorf("dict needs an even number of arguments, not %d", len(keysAndValues))
+	}
+	d := make(map[string]any, len(keysAndValues)/2)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key, ok := keysAndValues[i].(string)
+		if !ok {
+			return nil, errors.Errorf("dict key %v is not a string", keysAndValues[i])
+		}
+		d[key] = keysAndValues[i+1]
+	}
+	return d, nil
+}
+
+func list(items ...any) []any {
+	return items
+}
+
+func appendList(items any, additional ...any) ([]any, error) {
+	listed, err := toList(items)
+	if err != nil {
+		return nil, err
+	}
+	return append(slices.Clip(listed), additional...), nil
+}
+
+// toList converts a slice or array of any type into a list.
+func toList(items any) ([]any, error) {
+	if items == nil {
+		return nil, nil
+	}
+	v := reflect.ValueOf(items)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, errors.Errorf("%T is not a list", items)
+	}
+	listed := make([]any, v.Len())
+	for i := range listed {
+		listed[i] = v.Index(i).Interface()
+	}
+	return listed, nil
+}
+
+func hasKey(m any, key string) (bool, error) {
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return false, errors.Errorf("%T is not a map with string keys", m)
+	}
+	return v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key())).IsValid(), nil
+}
+
+func mapKeys(m any) ([]string, error) {
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return nil, errors.Errorf("%T is not a map with string keys", m)
+	}
+	keys := make([]string, 0, v.Len())
+	for _, key := range v.MapKeys() {
+		keys = append(keys, key.String())
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+// Defaults
+
+// DefaultFuncs returns template functions for handling empty values:
+//   - default returns the given value, or the default value if the given value is empty, e.g.
+//     `{{default "Anonymous" .Data.Name}}` or `{{.Data.Name | default "Anonymous"}}`.
+//   - empty checks whether a value is empty (nil, false, zero, or of zero length).
+//   - coalesce returns the first non-empty value, e.g. `{{coalesce .Data.Nickname .Data.Name}}`.
+//   - ternary returns the first value if the condition is true and the second value otherwise, e.g.
+//     `{{ternary "on" "off" .Data.Enabled}}`.
+func DefaultFuncs() template.FuncMap {
+	return template.FuncMap{
+		"default": func(defaultValue any, given ...any) any {
+			if len(given) == 0 || isEmpty(given[0]) {
+				return defaultValue
+			}
+			return given[0]
+		},
+		"empty": isEmpty,
+		"coalesce": func(values ...any) any {
+			for _, value := range values {
+				if !isEmpty(value) {
+					return value
+				}
+			}
+			return nil
+		},
+		"ternary": func(ifTrue, ifFalse any, condition bool) any {
+			if condition {
+				return ifTrue
+			}
+			return ifFalse
+		},
+	}
+}
+
+func isEmpty(value any) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	default:
+		return v.IsZero()
+	}
+}
+
+// Strings
+
+// StringFuncs returns template functions for manipulating strings. Functions which take a string
+// take it as their last argument, so that it can be piped in, e.g.
+// `{{.Data.Title | trimPrefix "Re: " | truncate 40}}`:
+//   - lower, upper, and trim change the case of a string or trim whitespace from it.
+//   - trimPrefix, trimSuffix, hasPrefix, hasSuffix, contains, replace, and split are like the
+//     corresponding functions in the strings package.
+//   - join joins the items of a list (converted to strings) with a separator.
+//   - truncate shortens a string to a maximum number of characters.
+func StringFuncs() template.FuncMap {
+	return template.FuncMap{
+		"lower":      strings.ToLower,
+		"upper":      strings.ToUpper,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+		"replace": func(old, replacement, s string) string {
+			return strings.ReplaceAll(s, old, replacement)
+		},
+		"split": func(sep, s string) []string { return strings.Split(s, sep) },
+		"join": func(sep string, items any) (string, error) {
+			listed, err := toList(items)
+			if err != nil {
+				return "", err
+			}
+			joined := make([]string, 0, len(listed))
+			for _, item := range listed {
+				joined = append(joined, fmt.Sprint(item))
+			}
+			return strings.Join(joined, sep), nil
+		},
+		"truncate": func(length int, s string) string {
+			if runes := []rune(s); len(runes) > length {
+				return string(runes[:max(length, 0)])
+			}
+			return s
+		},
+	}
+}
+
+// Dates and numbers
+
+// FormatFuncs returns template functions for formatting dates and numbers:
+//   - now returns the current time.
+//   - formatTime formats a time with a layout as in the time package, e.g.
+//     `{{.Data.Created | formatTime "2006-01-02"}}`.
+//   - isoTime formats a time in RFC 3339 format, e.g. for a <time datetime="..."> attribute.
+//   - unixTime returns a time as the number of seconds since the Unix epoch.
+//   - formatNumber, formatDecimal, and formatPercent format a number for the locale of the page,
+//     e.g. `{{formatNumber .Meta .Data.Count}}`, `{{formatDecimal .Meta 2 .Data.Price}}` (with two
+//     fractional digits), and `{{formatPercent .Meta .Data.Ratio}}`.
+func FormatFuncs() template.FuncMap {
+	return template.FuncMap{
+		"now":        time.Now,
+		"formatTime": func(layout string, t time.Time) string { return t.Format(layout) },
+		"isoTime":    func(t time.Time) string { return t.Format(time.RFC3339) },
+		"unixTime":   func(t time.Time) int64 { return t.Unix() },
+		"formatNumber": func(meta RenderDataMeta, n any) string {
+			return localePrinter(meta.Locale).Sprint(number.Decimal(n))
+		},
+		"formatDecimal": func(meta RenderDataMeta, digits int, n any) string {
+			return localePrinter(meta.Locale).Sprint(number.Decimal(n, number.Scale(digits)))
+		},
+		"formatPercent": func(meta RenderDataMeta, n any) string {
+			return localePrinter(meta.Locale).Sprint(number.Percent(n))
+		},
+	}
+}
+
+// localePrinter makes a printer for formatting values in the locale, or in an undetermined locale
+// if the locale is invalid.
+func localePrinter(locale string) *textmessage.Printer {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		tag = language.Und
+	}
+	return textmessage.NewPrinter(tag)
+}
+
+// URLs
+
+// URLFuncs returns template functions for building URLs:
+//   - appURL makes a URL for a path in the app, by prefixing the path with the app's BasePath and
+//     adding any alternating query parameter keys and values, e.g.
+//     `{{appURL .Meta "/posts" "page" 2}}`. Absolute URLs are left as they are.
+//   - pathEscape and queryEscape escape a string for use in a URL path segment or query.
+func URLFuncs() template.FuncMap {
+	return template.FuncMap{
+		"appURL":      appURL,
+		"pathEscape":  url.PathEscape,
+		"queryEscape": url.QueryEscape,
+	}
+}
+
+func appURL(meta RenderDataMeta, path string, query ...any) (string, error) {
+	if len(query)%2 != 0 {
+		return "", errors.Errorf("appURL needs an even number of query arguments, not %d", len(query))
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "couldn't parse URL %s", path)
+	}
+	if !u.IsAbs() && u.Host == "" && strings.HasPrefix(u.Path, "/") {
+		u.Path = strings.TrimSuffix(meta.BasePath, "/") + u.Path
+	}
+	if len(query) > 0 {
+		values := u.Query()
+		for i := 0; i < len(query); i += 2 {
+			values.Add(fmt.Sprint(query[i]), fmt.Sprint(query[i+1]))
+		}
+		u.RawQuery = values.Encode()
+	}
+	return u.String(), nil
+}
+
+// Forms
+
+// FormFuncs returns template functions for manipulating [FormValues], e.g. for making links which
+// change one query parameter of the current page:
+//   - formWith, formWithInstead, and formWithout wrap [FormValues.With],
+//     [FormValues.WithInstead], and [FormValues.Without], e.g.
+//     `{{$form := .Meta.Form | formWithInstead "page" "2"}}`.
+//   - formQuery encodes form values as a URL query string (with a leading "?" if it's not empty),
+//     e.g. `<a href="{{.Meta.Path}}{{.Meta.Form | formWithout "page" | formQuery}}">`.
+func FormFuncs() template.FuncMap {
+	return template.FuncMap{
+		"formWith": func(key string, value any, v FormValues) FormValues {
+			return v.With(key, fmt.Sprint(value))
+		},
+		"formWithInstead": func(key string, value any, v FormValues) FormValues {
+			return v.WithInstead(key, fmt.Sprint(value))
+		},
+		"formWithout": func(key string, v FormValues) FormValues {
+			return v.Without(key)
+		},
+		"formQuery": func(v FormValues) string {
+			if encoded := v.Encode(); encoded != "" {
+				return "?" + encoded
+			}
+			return ""
+		},
+	}
+}
+
+// Assets
+
+// NewHashedNamerFuncs returns template functions appHashed and staticHashed which wrap
+// [Embeds.GetAppHashedNamer] and [Embeds.GetStaticHashedNamer], e.g.
+// `<script src="{{appHashed "app.js"}}"></script>`.
+func (e Embeds) NewHashedNamerFuncs(appURLPrefix, staticURLPrefix string) template.FuncMap {
+	return template.FuncMap{
+		"appHashed":    e.GetAppHashedNamer(appURLPrefix),
+		"staticHashed": e.GetStaticHashedNamer(staticURLPrefix),
+	}
+}
@@ -0,0 +1,186 @@
+package godest
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"text/template/parse"
+
+	"github.com/pkg/errors"
+)
+
+// TemplateGraph is the call graph of the named templates in a template set, as determined by the
+// {{template}} actions (including those implied by {{block}} actions) and by invocations of
+// registered components in the templates' parse trees.
+type TemplateGraph struct {
+	// Files maps each template name to the path of the template file which defined it.
+	Files map[string]string
+	// Calls maps each template name to the sorted names of all templates which it directly invokes.
+	Calls map[string][]string
+}
+
+// newTemplateGraph builds the call graph of the templates associated with the template set. Each
+// invocation of a component is a call to the partial template of the component; invocations whose
+// component name isn't a string constant are treated as calls to the partial templates of all
+// components.
+func newTemplateGraph(
+	set *template.Template, components map[string]registeredComponent,
+) TemplateGraph {
+	g := TemplateGraph{
+		Files: make(map[string]string),
+		Calls: make(map[string][]string),
+	}
+	for _, tmpl := range set.Templates() {
+		if tmpl.Tree == nil || tmpl.Tree.Root == nil {
+			continue
+		}
+		g.Files[tmpl.Name()] = tmpl.Tree.ParseName
+
+		calls := make(map[string]struct{})
+		collectTemplateCalls(tmpl.Tree.Root, calls)
+		collectFuncCalls(tmpl.Tree.Root, "component", func(cmd *parse.CommandNode) {
+			collectComponentCalls(cmd, components, calls)
+		})
+		g.Calls[tmpl.Name()] = sortedKeys(calls)
+	}
+	return g
+}
+
+// collectTemplateCalls recursively adds the names of all templates invoked within the parse tree
+// node to the calls set.
+func collectTemplateCalls(node parse.Node, calls map[string]struct{}) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectTemplateCalls(child, calls)
+		}
+	case *parse.IfNode:
+		collectBranchTemplateCalls(&n.BranchNode, calls)
+	case *parse.RangeNode:
+		collectBranchTemplateCalls(&n.BranchNode, calls)
+	case *parse.WithNode:
+		collectBranchTemplateCalls(&n.BranchNode, calls)
+	case *parse.TemplateNode:
+		calls[n.Name] = struct{}{}
+	}
+}
+
+func collectBranchTemplateCalls(n *parse.BranchNode, calls map[string]struct{}) {
+	collectTemplateCalls(n.List, calls)
+	collectTemplateCalls(n.ElseList, calls)
+}
+
+// collectComponentCalls adds the names of the partial templates which may be invoked by the
+// invocation of a component to the calls set.
+func collectComponentCalls(
+	cmd *parse.CommandNode, components map[string]registeredComponent, calls map[string]struct{},
+) {
+	if len(cmd.Args) < 2 {
+		return
+	}
+	if nameNode, ok := cmd.Args[1].(*parse.StringNode); ok {
+		if rc, ok := components[nameNode.Text]; ok {
+			calls[rc.Partial] = struct{}{}
+		}
+		return
+	}
+	for _, rc := range components {
+		calls[rc.Partial] = struct{}{}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Reachable returns the sorted names of all templates which may be invoked, directly or
+// indirectly, when the named template is executed, including the named template itself. Templates
+// which are invoked but not defined are also included.
+func (g TemplateGraph) Reachable(templateName string) []string {
+	reached := make(map[string]struct{})
+	pending := []string{templateName}
+	for len(pending) > 0 {
+		name := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if _, ok := reached[name]; ok {
+			continue
+		}
+		reached[name] = struct{}{}
+		pending = append(pending, g.Calls[name]...)
+	}
+	return sortedKeys(reached)
+}
+
+// ReachableFiles returns the sorted paths of all template files which define the templates
+// returned by [TemplateGraph.Reachable].
+func (g TemplateGraph) ReachableFiles(templateName string) []string {
+	files := make(map[string]struct{})
+	for _, name := range g.Reachable(templateName) {
+		if file, ok := g.Files[name]; ok && file != "" {
+			files[file] = struct{}{}
+		}
+	}
+	return sortedKeys(files)
+}
+
+// Undefined returns the sorted names of all templates which are invoked but not defined.
+func (g TemplateGraph) Undefined() []string {
+	undefined := make(map[string]struct{})
+	for _, calls := range g.Calls {
+		for _, name := range calls {
+			if _, ok := g.Files[name]; !ok {
+				undefined[name] = struct{}{}
+			}
+		}
+	}
+	return sortedKeys(undefined)
+}
+
+// Dump writes a human-readable listing of the graph, for debugging.
+func (g TemplateGraph) Dump(w io.Writer) error {
+	for _, name := range sortedKeys(g.Calls) {
+		if _, err := fmt.Fprintf(w, "%s (%s)\n", name, g.Files[name]); err != nil {
+			return err
+		}
+		for _, call := range g.Calls[name] {
+			if _, err := fmt.Fprintf(w, "  -> %s\n", call); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// TemplateRenderer: template graphs
+
+// TemplateGraph returns the call graph of the templates available to the named page template,
+// including the templates defined by the page template file itself. If the page name is empty,
+// the call graph of all templates (without overrides from page template files) is returned.
+func (tr TemplateRenderer) TemplateGraph(pageName string) (TemplateGraph, error) {
+	tr, err := tr.loaded()
+	if err != nil {
+		return TemplateGraph{}, err
+	}
+	if pageName == "" {
+		all, err := tr.getAll()
+		if err != nil {
+			return TemplateGraph{}, err
+		}
+		return newTemplateGraph(all, tr.components.list()), nil
+	}
+
+	page, err := tr.getPage(pageName)
+	if err != nil {
+		return TemplateGraph{}, errors.Wrapf(err, "couldn't get template graph for %s", pageName)
+	}
+	return newTemplateGraph(page, tr.components.list()), nil
+}
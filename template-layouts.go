@@ -0,0 +1,172 @@
+package godest
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Template modules
+
+// templateModule identifies the module of a template file, which is the top-level directory of the
+// template file. Template files at the root of the filesystem belong to a module with an empty
+// name.
+func templateModule(path string) string {
+	module, _, ok := strings.Cut(path, "/")
+	if !ok {
+		return ""
+	}
+	return module
+}
+
+// filterModuleTemplate creates a filter for the template files visible to templates in the module,
+// which are all partial templates (from any module, so that templates can call partials from other
+// modules), the module's own layout templates, and all templates in the shared module (except for
+// email and service worker templates). Layout templates in other modules are not visible, so that
+// blocks defined by layouts in different modules (e.g. two "content" blocks) don't collide with
+// each other. Partials share a single namespace, so templates defined by partials must have unique
+// names across modules.
+func filterModuleTemplate(module string) func(path string) bool {
+	return func(path string) bool {
+		return (filterSharedTemplate(path) && !filterEmailTemplate(path) &&
+			!filterWorkerTemplate(path)) ||
+			filterPartialTemplate(path) ||
+			(filterLayoutTemplate(path) && templateModule(path) == module)
+	}
+}
+
+// Layout inheritance
+
+// extendsDirective matches a comment at the start of a template file which declares the layout
+// which the template extends, e.g. `{{/* extends "shared/base.layout.tmpl" */}}`.
+var extendsDirective = regexp.MustCompile(`^\s*{{-?\s*/\*\s*extends\s+("[^"]*")\s*\*/\s*-?}}`)
+
+// parseExtends returns the layout which the template file declares that it extends, or an empty
+// string if the template file doesn't declare a layout.
+func parseExtends(templatesFS fs.FS, path string) (layout string, err error) {
+	b, err := fs.ReadFile(templatesFS, path)
+	if err != nil {
+		return "", errors.Wrapf(err, "couldn't read template %s", path)
+	}
+	match := extendsDirective.FindSubmatch(b)
+	if match == nil {
+		return "", nil
+	}
+	if layout, err = strconv.Unquote(string(match[1])); err != nil {
+		return "", errors.Wrapf(err, "couldn't parse layout declared by template %s", path)
+	}
+	return layout, nil
+}
+
+// resolveLayouts returns the chain of layouts which the template file extends, starting from the
+// outermost layout. Each layout must be a layout template visible to the template.
+func resolveLayouts(templatesFS fs.FS, path string) (layouts []string, err error) {
+	visible := filterModuleTemplate(templateModule(path))
+	for current := path; ; {
+		layout, err := parseExtends(templatesFS, current)
+		if err != nil {
+			return nil, err
+		}
+		if layout == "" {
+			break
+		}
+		if !filterLayoutTemplate(layout) || !visible(layout) {
+			return nil, errors.Errorf(
+				"template %s can't extend %s, which is not a layout in module %s or in the %s module",
+				current, layout, templateModule(path), templateSharedModule,
+			)
+		}
+		if layout == path || slices.Contains(layouts, layout) {
+			return nil, errors.Errorf("layout %s extends itself through template %s", layout, current)
+		}
+		layouts = append(layouts, layout)
+		current = layout
+	}
+	slices.Reverse(layouts)
+	return layouts, nil
+}
+
+// parseWithLayouts parses the template file into the template set on top of the chain of layouts
+// which it extends, so that blocks defined by the template file override blocks defined by the
+// layouts it extends, which in turn override the default contents of blocks in the layouts they
+// extend. A template file which extends a layout is rendered by rendering that layout, so any
+// contents outside of its {{define}} and {{block}} actions are ignored.
+func parseWithLayouts(
+	set *template.Template, templatesFS fs.FS, path string,
+) (*template.Template, error) {
+	layouts, err := resolveLayouts(templatesFS, path)
+	if err != nil {
+		return nil, err
+	}
+	chain := append(layouts, path)
+	if set, err = parseFiles(set, readFileFS(templatesFS), chain...); err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(chain); i++ {
+		if _, err = set.New(chain[i]).Parse(
+			fmt.Sprintf("{{template %q .}}", chain[i-1]),
+		); err != nil {
+			return nil, errors.Wrapf(err, "couldn't make %s extend %s", chain[i], chain[i-1])
+		}
+	}
+	return set, nil
+}
+
+// instantiateModuleTemplates makes template sets for the template files matching the filter, each
+// with the templates visible from its module and the layouts which it extends. Template sets for
+// each module are parsed once and then cloned for each template file in the module.
+func instantiateModuleTemplates(
+	templatesFS fs.FS, root *template.Template, templateFilter func(string) bool,
+) (templates map[string]*template.Template, err error) {
+	templates = make(map[string]*template.Template)
+	templateFiles, err := listFiles(templatesFS, templateFilter)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't list templates")
+	}
+	modules := make(map[string]*template.Template)
+	for _, name := range templateFiles {
+		module := templateModule(name)
+		moduleSet, ok := modules[module]
+		if !ok {
+			if moduleSet, err = instantiateModule(templatesFS, root, module); err != nil {
+				return nil, err
+			}
+			modules[module] = moduleSet
+		}
+		set, err := moduleSet.Clone()
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't clone template set for module %s", module)
+		}
+		if templates[name], err = parseWithLayouts(set, templatesFS, name); err != nil {
+			return nil, errors.Wrapf(err, "couldn't make template set for %s", name)
+		}
+	}
+	return templates, nil
+}
+
+// instantiateModule makes a template set with all templates visible from the module.
+func instantiateModule(
+	templatesFS fs.FS, root *template.Template, module string,
+) (*template.Template, error) {
+	set, err := root.Clone()
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't clone root template set")
+	}
+	files, err := listFiles(templatesFS, filterModuleTemplate(module))
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't list templates visible from module %s", module)
+	}
+	if len(files) == 0 {
+		return set, nil
+	}
+	if set, err = parseFiles(set, readFileFS(templatesFS), files...); err != nil {
+		return nil, errors.Wrapf(err, "couldn't load templates visible from module %s", module)
+	}
+	return set, nil
+}
@@ -0,0 +1,282 @@
+package godest
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Render observations
+
+// RenderKind identifies the kind of template which was rendered.
+type RenderKind string
+
+const (
+	// PageRender is the rendering of a page template.
+	PageRender RenderKind = "page"
+	// FragmentRender is the rendering of a block (or other named template) from a page's template
+	// set.
+	FragmentRender RenderKind = "fragment"
+	// PartialRender is the rendering of a partial template.
+	PartialRender RenderKind = "partial"
+	// TurboStreamRender is the rendering of a Turbo Streams message, excluding the rendering of the
+	// partial and page templates in the message (which are observed separately).
+	TurboStreamRender RenderKind = "turbo-stream"
+	// EmailRender is the rendering of an email template.
+	EmailRender RenderKind = "email"
+	// ServiceWorkerRender is the rendering of a service worker template.
+	ServiceWorkerRender RenderKind = "service-worker"
+)
+
+// RenderObservation describes the rendering of a template.
+type RenderObservation struct {
+	Kind RenderKind
+	// Template is the name of the template. For fragments, it's the name of the page template and
+	// the name of the block, separated by "#".
+	Template string
+	Duration time.Duration
+	// Size is the number of bytes of output.
+	Size int
+	// Err is the error from rendering the template, if rendering failed.
+	Err error
+}
+
+// RenderMetrics records observations of template rendering, e.g. to export them to a monitoring
+// system. Implementations must be safe for concurrent use.
+type RenderMetrics interface {
+	ObserveRender(o RenderObservation)
+}
+
+// observeRender reports the rendering of the template to the TemplateRenderer's Metrics (if it has
+// any), and returns the time elapsed since the start of rendering.
+func (tr TemplateRenderer) observeRender(
+	kind RenderKind, templateName string, start time.Time, size int, err error,
+) time.Duration {
+	duration := time.Since(start)
+	if tr.Metrics != nil {
+		tr.Metrics.ObserveRender(RenderObservation{
+			Kind:     kind,
+			Template: templateName,
+			Duration: duration,
+			Size:     size,
+			Err:      err,
+		})
+	}
+	return duration
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (cw *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	cw.n += n
+	return n, err
+}
+
+// Render statistics
+
+// DefaultRenderDurationBuckets are the upper bounds of the histogram buckets for render durations
+// used by [NewRenderStats] if no buckets are specified.
+var DefaultRenderDurationBuckets = []time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// RenderStat holds aggregate statistics of the renderings of a template.
+type RenderStat struct {
+	Kind          RenderKind
+	Template      string
+	Count         uint64
+	Errors        uint64
+	TotalDuration time.Duration
+	MaxDuration   time.Duration
+	TotalSize     uint64
+	// BucketCounts holds the number of renderings with durations no greater than each of the
+	// RenderStats' bucket upper bounds (so the counts are cumulative).
+	BucketCounts []uint64
+}
+
+type renderStatKey struct {
+	kind         RenderKind
+	templateName string
+}
+
+// RenderStats is a [RenderMetrics] which aggregates render observations in memory for each
+// template, e.g. for exposition to Prometheus with [RenderStats.HandlePrometheus].
+type RenderStats struct {
+	buckets []time.Duration
+
+	mu    sync.Mutex
+	stats map[renderStatKey]*RenderStat
+}
+
+// NewRenderStats creates a RenderStats with histogram buckets for render durations with the
+// specified upper bounds, or with [DefaultRenderDurationBuckets] if no bounds are specified.
+func NewRenderStats(buckets ...time.Duration) *RenderStats {
+	if len(buckets) == 0 {
+		buckets = DefaultRenderDurationBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	return &RenderStats{
+		buckets: slices.Compact(buckets),
+		stats:   make(map[renderStatKey]*RenderStat),
+	}
+}
+
+// ObserveRender adds the observation to the statistics of its template.
+func (s *RenderStats) ObserveRender(o RenderObservation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := renderStatKey{kind: o.Kind, templateName: o.Template}
+	stat, ok := s.stats[key]
+	if !ok {
+		stat = &RenderStat{
+			Kind:         o.Kind,
+			Template:     o.Template,
+			BucketCounts: make([]uint64, len(s.buckets)),
+		}
+		s.stats[key] = stat
+	}
+	stat.Count++
+	if o.Err != nil {
+		stat.Errors++
+	}
+	stat.TotalDuration += o.Duration
+	stat.MaxDuration = max(stat.MaxDuration, o.Duration)
+	stat.TotalSize += uint64(max(o.Size, 0))
+	for i, bound := range s.buckets {
+		if o.Duration <= bound {
+			stat.BucketCounts[i]++
+		}
+	}
+}
+
+// Buckets returns the upper bounds of the histogram buckets for render durations.
+func (s *RenderStats) Buckets() []time.Duration {
+	return slices.Clone(s.buckets)
+}
+
+// Snapshot returns a copy of the statistics of all templates, sorted by kind and template name.
+func (s *RenderStats) Snapshot() []RenderStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := make([]RenderStat, 0, len(s.stats))
+	for _, stat := range s.stats {
+		copied := *stat
+		copied.BucketCounts = slices.Clone(stat.BucketCounts)
+		snapshot = append(snapshot, copied)
+	}
+	slices.SortFunc(snapshot, func(a, b RenderStat) int {
+		return cmp.Or(cmp.Compare(a.Kind, b.Kind), cmp.Compare(a.Template, b.Template))
+	})
+	return snapshot
+}
+
+// Prometheus exposition
+
+const (
+	renderDurationMetric = "godest_template_render_duration_seconds"
+	renderErrorsMetric   = "godest_template_render_errors_total"
+	renderSizeMetric     = "godest_template_render_output_bytes_total"
+)
+
+// prometheusLabelEscaper escapes label values in the Prometheus text exposition format.
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatRenderLabels(stat RenderStat, extra ...string) string {
+	labels := []string{
+		fmt.Sprintf(`kind="%s"`, prometheusLabelEscaper.Replace(string(stat.Kind))),
+		fmt.Sprintf(`template="%s"`, prometheusLabelEscaper.Replace(stat.Template)),
+	}
+	return "{" + strings.Join(append(labels, extra...), ",") + "}"
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+// WritePrometheus writes the statistics of all templates in the Prometheus text exposition format,
+// as a histogram of render durations and counters of render errors and output bytes.
+func (s *RenderStats) WritePrometheus(w io.Writer) error {
+	snapshot := s.Snapshot()
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "# HELP %s Time taken to render templates.\n", renderDurationMetric)
+	fmt.Fprintf(bw, "# TYPE %s histogram\n", renderDurationMetric)
+	for _, stat := range snapshot {
+		for i, bound := range s.buckets {
+			fmt.Fprintf(
+				bw, "%s_bucket%s %d\n", renderDurationMetric,
+				formatRenderLabels(stat, fmt.Sprintf(`le="%s"`, formatSeconds(bound))),
+				stat.BucketCounts[i],
+			)
+		}
+		fmt.Fprintf(
+			bw, "%s_bucket%s %d\n",
+			renderDurationMetric, formatRenderLabels(stat, `le="+Inf"`), stat.Count,
+		)
+		fmt.Fprintf(
+			bw, "%s_sum%s %s\n",
+			renderDurationMetric, formatRenderLabels(stat), formatSeconds(stat.TotalDuration),
+		)
+		fmt.Fprintf(bw, "%s_count%s %d\n", renderDurationMetric, formatRenderLabels(stat), stat.Count)
+	}
+
+	fmt.Fprintf(bw, "# HELP %s Number of failed template renders.\n", renderErrorsMetric)
+	fmt.Fprintf(bw, "# TYPE %s counter\n", renderErrorsMetric)
+	for _, stat := range snapshot {
+		fmt.Fprintf(bw, "%s%s %d\n", renderErrorsMetric, formatRenderLabels(stat), stat.Errors)
+	}
+
+	fmt.Fprintf(bw, "# HELP %s Number of bytes output by template renders.\n", renderSizeMetric)
+	fmt.Fprintf(bw, "# TYPE %s counter\n", renderSizeMetric)
+	for _, stat := range snapshot {
+		fmt.Fprintf(bw, "%s%s %d\n", renderSizeMetric, formatRenderLabels(stat), stat.TotalSize)
+	}
+
+	if err := bw.Flush(); err != nil {
+		return errors.Wrap(err, "couldn't write render statistics")
+	}
+	return nil
+}
+
+// HandlePrometheus serves the statistics of all templates in the Prometheus text exposition
+// format, e.g. for scraping by a Prometheus server.
+func (s *RenderStats) HandlePrometheus() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WithUncacheable()(w.Header())
+		if r.Method == http.MethodHead {
+			return
+		}
+		// The response can't be fixed if writing it fails partway
+		_ = s.WritePrometheus(w)
+	})
+}
@@ -0,0 +1,200 @@
+package godest
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/sargassum-world/godest/handling"
+)
+
+// Filesystem change detection
+
+// computeFSStamp summarizes the names, sizes, and modification times of all files in the
+// filesystem, so that changes to the filesystem can be detected without reading file contents.
+// Note that filesystems such as embed.FS report zero modification times, so changes in them can
+// only be detected through changes in file names and sizes.
+func computeFSStamp(fsys fs.FS) (string, error) {
+	if fsys == nil {
+		return "", nil
+	}
+
+	var stamps []string
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		stamps = append(
+			stamps, fmt.Sprintf("%s:%d:%d", path, info.Size(), info.ModTime().UnixNano()),
+		)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(stamps)
+	return strings.Join(stamps, "\n"), nil
+}
+
+// Hot reloading
+
+// templateReload is an immutable snapshot of the result of (re)loading all templates.
+type templateReload struct {
+	tr    TemplateRenderer
+	err   error
+	stamp string
+}
+
+// templateReloader holds the latest snapshot of a hot-reloading TemplateRenderer, so that copies of
+// the TemplateRenderer all observe each reload.
+type templateReloader struct {
+	current atomic.Pointer[templateReload]
+}
+
+// NewHotReloadTemplateRenderer initializes a TemplateRenderer for development which pre-loads and
+// pre-compiles the templates from the filesystem specified by the [Embeds] (like
+// [NewTemplateRenderer]), but which can also atomically reload all templates and recompute all
+// fingerprints when [TemplateRenderer.WatchTemplates] detects changes in the Embeds' TemplatesFS,
+// AppFS, or MessagesFS. The Embeds' filesystems should usually be on-disk filesystems (e.g. from
+// [os.DirFS]). If templates fail to load (or the filesystems can't be checked for changes), the
+// error is reported in rendered pages instead of being returned.
+func NewHotReloadTemplateRenderer(
+	e Embeds, inlines any, funcs ...template.FuncMap,
+) (tr TemplateRenderer, err error) {
+	tr.embeds = e
+	tr.funcs = funcs
+	tr.inlines = inlines
+	tr.components = newComponentRegistry()
+	tr.required = newRequiredTemplates()
+	tr.reloader = &templateReloader{}
+	tr.reload()
+	return tr, nil
+}
+
+// computeStamp summarizes the watched filesystems for change detection.
+func (tr TemplateRenderer) computeStamp() (string, error) {
+	templatesStamp, err := computeFSStamp(tr.embeds.TemplatesFS)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't check templates for changes")
+	}
+	appStamp, err := computeFSStamp(tr.embeds.AppFS)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't check app assets for changes")
+	}
+	messagesStamp, err := computeFSStamp(tr.embeds.MessagesFS)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't check message catalogs for changes")
+	}
+	return templatesStamp + "\n\n" + appStamp + "\n\n" + messagesStamp, nil
+}
+
+// reload reloads all templates if the watched filesystems have changed since the last reload.
+// Errors are recorded in the reload snapshot rather than returned, so that watching can continue
+// after transient errors (e.g. when a file is deleted during a walk of the filesystem, because an
+// editor atomically replaced it).
+func (tr TemplateRenderer) reload() {
+	prev := tr.reloader.current.Load()
+	stamp, err := tr.computeStamp()
+	if err != nil {
+		// The stamp is left empty, so that the templates are reloaded once the filesystems can be
+		// checked again
+		failed := &templateReload{err: err}
+		if prev != nil {
+			failed.tr = prev.tr
+		}
+		tr.reloader.current.Store(failed)
+		return
+	}
+	if prev != nil && prev.stamp == stamp {
+		return
+	}
+
+	loaded, err := TemplateRenderer{
+		embeds:     tr.embeds,
+		funcs:      tr.funcs,
+		inlines:    tr.inlines,
+		components: tr.components,
+		required:   tr.required,
+	}.preload()
+	tr.reloader.current.Store(&templateReload{
+		tr:    loaded,
+		err:   errors.Wrap(err, "couldn't reload templates"),
+		stamp: stamp,
+	})
+}
+
+// WatchTemplates polls the Embeds' TemplatesFS, AppFS, and MessagesFS at the specified interval and
+// reloads all templates whenever a change is detected, until the context is canceled. It can only
+// be used with a TemplateRenderer initialized by [NewHotReloadTemplateRenderer]. Rather than
+// subscribing to filesystem notifications, each poll walks the filesystems and stats every file,
+// which is cheap enough for the template and asset trees of a development server but may not be for
+// very large trees. Errors from loading templates or from checking the filesystems are reported in
+// rendered pages, and polling continues.
+func (tr TemplateRenderer) WatchTemplates(ctx context.Context, interval time.Duration) error {
+	if tr.reloader == nil {
+		return errors.New("template renderer was not initialized for hot reloading")
+	}
+	return handling.Repeat(ctx, interval, func() (done bool, err error) {
+		tr.reload()
+		return false, nil
+	})
+}
+
+// loaded returns the latest snapshot of a hot-reloading TemplateRenderer, or the TemplateRenderer
+// itself if it does not hot-reload. It returns an error if the latest reload failed.
+func (tr TemplateRenderer) loaded() (TemplateRenderer, error) {
+	if tr.reloader == nil {
+		return tr, nil
+	}
+	current := tr.reloader.current.Load()
+	if current.err != nil {
+		return tr, current.err
+	}
+	loaded := current.tr
+	loaded.BasePath = tr.BasePath
+	loaded.DefaultLocale = tr.DefaultLocale
+	loaded.Metrics = tr.Metrics
+	loaded.ServerTiming = tr.ServerTiming
+	return loaded, nil
+}
+
+// reloadErrorTemplate is the page shown in the browser when templates fail to (re)load.
+var reloadErrorTemplate = template.Must(template.New("reload-error").Parse(
+	`<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>Template error</title>
+  </head>
+  <body>
+    <h1>Couldn't load templates</h1>
+    <pre>{{.}}</pre>
+  </body>
+</html>
+`))
+
+// writeReloadError reports a template reloading error to the browser.
+func writeReloadError(w http.ResponseWriter, reloadErr error) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	WithUncacheable()(w.Header())
+	w.WriteHeader(http.StatusInternalServerError)
+	if err := reloadErrorTemplate.Execute(w, reloadErr.Error()); err != nil {
+		return errors.Wrap(err, "couldn't report template reloading error")
+	}
+	return reloadErr
+}
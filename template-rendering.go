@@ -11,9 +11,10 @@ import (
 	"bytes"
 	"html/template"
 	"io"
-	"io/fs"
 	"net/http"
 	"net/url"
+	texttemplate "text/template"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -34,6 +35,9 @@ type RenderDataMeta struct {
 	RequestURI string
 	BasePath   string
 	Form       FormValues
+	CSPNonce   string
+	Locale     string
+	Turbo      TurboRequest
 }
 
 type FormValues struct {
@@ -72,17 +76,38 @@ func (v FormValues) Without(key string) FormValues {
 // TemplateRenderer
 
 type TemplateRenderer struct {
-	BasePath string
-	embeds   Embeds
-	funcs    []template.FuncMap
-	inlines  any
+	BasePath      string
+	DefaultLocale string
+	// Metrics, if it's not nil, records observations of all template rendering.
+	Metrics RenderMetrics
+	// ServerTiming enables the Server-Timing header in rendered pages, with the time taken to
+	// compute the page's etag and to render the page.
+	ServerTiming bool
+
+	embeds  Embeds
+	funcs   []template.FuncMap
+	inlines any
 
 	// Pre-cached data:
 	allTemplates         *template.Template
 	partialTemplates     map[string]*template.Template
 	pageTemplates        map[string]*template.Template
 	turboStreamsTemplate *template.Template
+	htmlEmailTemplates   map[string]*template.Template
+	textEmailTemplates   map[string]*texttemplate.Template
+	workerTemplates      map[string]*texttemplate.Template
 	fingerprints         *fingerprints
+	messageCatalogs      *messageCatalogs
+
+	// Component support:
+	components        *componentRegistry
+	componentPartials *componentPartials
+
+	// Static site export support:
+	required *requiredTemplates
+
+	// Hot-reloading support:
+	reloader *templateReloader
 }
 
 // NewTemplateRenderer pre-loads and pre-compiles the templates from the filesystem specified by the
@@ -93,8 +118,18 @@ func NewTemplateRenderer(
 	tr.embeds = e
 	tr.funcs = funcs
 	tr.inlines = inlines
-	tr.fingerprints = &fingerprints{}
+	tr.components = newComponentRegistry()
+	tr.required = newRequiredTemplates()
+	return tr.preload()
+}
 
+// preload pre-loads and pre-compiles all templates, and pre-computes all fingerprints.
+func (tr TemplateRenderer) preload() (TemplateRenderer, error) {
+	var err error
+	tr.componentPartials = &componentPartials{}
+	if tr.messageCatalogs, err = tr.getMessageCatalogs(); err != nil {
+		return TemplateRenderer{}, err
+	}
 	if tr.allTemplates, err = tr.getAll(); err != nil {
 		return TemplateRenderer{}, err
 	}
@@ -104,9 +139,19 @@ func NewTemplateRenderer(
 	if tr.partialTemplates, err = tr.getPartials(); err != nil {
 		return TemplateRenderer{}, err
 	}
+	tr.componentPartials.partials = tr.partialTemplates
 	if tr.turboStreamsTemplate, err = tr.getTurboStreams(); err != nil {
 		return TemplateRenderer{}, err
 	}
+	if tr.htmlEmailTemplates, err = tr.getHTMLEmails(); err != nil {
+		return TemplateRenderer{}, err
+	}
+	if tr.textEmailTemplates, err = tr.getTextEmails(); err != nil {
+		return TemplateRenderer{}, err
+	}
+	if tr.workerTemplates, err = tr.getWorkers(); err != nil {
+		return TemplateRenderer{}, err
+	}
 	if tr.fingerprints, err = tr.getFingerprints(); err != nil {
 		return TemplateRenderer{}, err
 	}
@@ -122,6 +167,8 @@ func NewLazyTemplateRenderer(
 	tr.embeds = e
 	tr.funcs = funcs
 	tr.inlines = inlines
+	tr.components = newComponentRegistry()
+	tr.required = newRequiredTemplates()
 
 	return tr, nil
 }
@@ -130,6 +177,7 @@ func (tr TemplateRenderer) NewRenderData(r *http.Request, data any, auth any) (d
 	d = RenderData{
 		Meta: RenderDataMeta{
 			BasePath: tr.BasePath,
+			Locale:   tr.DefaultLocale,
 		},
 		Inlines: tr.inlines,
 		Data:    data,
@@ -139,6 +187,9 @@ func (tr TemplateRenderer) NewRenderData(r *http.Request, data any, auth any) (d
 		d.Meta.Path = r.URL.Path
 		d.Meta.RequestURI = r.URL.RequestURI()
 		d.Meta.Form = FormValues{r.Form}
+		d.Meta.CSPNonce = CSPNonce(r.Context())
+		d.Meta.Locale = tr.NegotiateLocale(r)
+		d.Meta.Turbo = GetTurboRequest(r)
 	}
 	return d
 }
@@ -148,24 +199,72 @@ func (tr TemplateRenderer) CacheablePage(
 	templateName string, templateData any, authData any,
 	headerOptions ...HeaderOption,
 ) error {
+	tr, err := tr.loaded()
+	if err != nil {
+		return writeReloadError(w, err)
+	}
 	fingerprints, err := tr.getFingerprints()
 	if err != nil {
 		return err
 	}
 
-	type EtagInputs struct {
-		Data any
-		Auth any
-	}
-	if noContent, err := fingerprints.setAndCheckEtag(w, r, templateName, EtagInputs{
-		Data: templateData,
-		Auth: authData,
-	}); noContent || (err != nil) {
+	if noContent, err := tr.setAndCheckPageEtag(
+		w, r, fingerprints.getEtagSegments, templateName, templateData, authData,
+	); noContent || (err != nil) {
 		return err
 	}
 	return tr.Page(w, r, http.StatusOK, templateName, templateData, authData, headerOptions...)
 }
 
+// pageEtagInputs holds the data-dependent and request-dependent inputs to the etag of a page.
+type pageEtagInputs struct {
+	Data any
+	Auth any
+	// Pages with CSP nonces can't be reused across requests, since each request has a new nonce
+	CSPNonce string `json:",omitempty"`
+	// Pages in different languages must have different etags
+	Locale string `json:",omitempty"`
+	// Pages rendered for Turbo Frames may differ from pages rendered for full-page visits
+	TurboFrame string `json:",omitempty"`
+}
+
+// setAndCheckPageEtag sets the etag of the page from the data-independent etag segments looked up
+// for the template and from the data-dependent and request-dependent inputs to the page, and then
+// checks the etag against the request.
+func (tr TemplateRenderer) setAndCheckPageEtag(
+	w http.ResponseWriter, r *http.Request,
+	getEtagSegments func(templateName string) ([]string, error),
+	templateName string, templateData any, authData any,
+) (noContent bool, err error) {
+	start := time.Now()
+	if tr.hasTranslations() {
+		WithVary("Accept-Language")(w.Header())
+	}
+	etagSegments, err := getEtagSegments(templateName)
+	if err != nil {
+		return false, err
+	}
+	dataFingerprint, err := computeDataFingerprint(pageEtagInputs{
+		Data:       templateData,
+		Auth:       authData,
+		CSPNonce:   CSPNonce(r.Context()),
+		Locale:     tr.NegotiateLocale(r),
+		TurboFrame: GetTurboRequest(r).Frame,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	noContent = setAndCheckEtag(w, r, append(etagSegments, dataFingerprint)...)
+	if tr.ServerTiming {
+		WithServerTiming("etag", time.Since(start), "")(w.Header())
+	}
+	if noContent {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return noContent, nil
+}
+
 func (tr TemplateRenderer) getFingerprints() (f *fingerprints, err error) {
 	f = tr.fingerprints
 	if f == nil {
@@ -173,9 +272,19 @@ func (tr TemplateRenderer) getFingerprints() (f *fingerprints, err error) {
 		if f.app, err = tr.embeds.computeAppFingerprint(); err != nil {
 			return nil, errors.Wrap(err, "couldn't compute fingerprint for app")
 		}
-		if f.page, err = tr.embeds.computePageFingerprints(); err != nil {
+		pages, err := tr.getPages()
+		if err != nil {
+			return nil, err
+		}
+		components := tr.components.list()
+		if f.page, err = computePageFingerprints(pages, components, tr.embeds.TemplatesFS); err != nil {
 			return nil, errors.Wrap(err, "couldn't compute fingerprint for page/module templates")
 		}
+		if f.fragment, err = computeFragmentFingerprints(
+			pages, components, tr.embeds.TemplatesFS,
+		); err != nil {
+			return nil, errors.Wrap(err, "couldn't compute fingerprints for page blocks")
+		}
 	}
 	return f, nil
 }
@@ -187,21 +296,55 @@ func (tr TemplateRenderer) Page(
 ) error {
 	// This is basically a reimplementation of the echo.Context.Render method, but without requiring
 	// an echo.Context to be provided
+	return tr.executePage(
+		w, r, status, templateName, templateName, templateData, authData, headerOptions...,
+	)
+}
+
+// executePage renders the named template (either the page template itself or a block or other
+// named template within the page's template set) from the page's template set.
+func (tr TemplateRenderer) executePage(
+	w http.ResponseWriter, r *http.Request,
+	status int, pageName, templateName string, templateData any, authData any,
+	headerOptions ...HeaderOption,
+) error {
+	tr, err := tr.loaded()
+	if err != nil {
+		return writeReloadError(w, err)
+	}
 	buf := new(bytes.Buffer)
-	tmpl, err := tr.getPage(templateName)
+	tmpl, err := tr.getPage(pageName)
 	if err != nil {
 		return err
 	}
 	if err = r.ParseForm(); err != nil {
 		return errors.Wrap(err, "couldn't parse URL query values and/or POST/PUT/PATCH request body")
 	}
-	if err = tmpl.ExecuteTemplate(
-		buf, templateName, tr.NewRenderData(r, templateData, authData),
-	); err != nil {
+	kind, observedName := PageRender, pageName
+	if templateName != pageName {
+		kind, observedName = FragmentRender, pageName+"#"+templateName
+	}
+	start := time.Now()
+	err = tmpl.ExecuteTemplate(buf, templateName, tr.NewRenderData(r, templateData, authData))
+	duration := tr.observeRender(kind, observedName, start, buf.Len(), err)
+	if err != nil {
+		if templateName != pageName {
+			return errors.Wrapf(
+				err, "couldn't execute template %s of page template %s", templateName, pageName,
+			)
+		}
 		return errors.Wrapf(err, "couldn't execute page template %s", templateName)
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if tr.hasTranslations() {
+		// Pages are rendered in the locale negotiated from the request, even when they're rendered
+		// without etags
+		WithVary("Accept-Language")(w.Header())
+	}
+	if tr.ServerTiming {
+		WithServerTiming("render", duration, observedName)(w.Header())
+	}
 	for _, headerOption := range headerOptions {
 		headerOption(w.Header())
 	}
@@ -227,11 +370,11 @@ func (tr TemplateRenderer) getPage(pageName string) (page *template.Template, er
 func (tr TemplateRenderer) getPages() (pages map[string]*template.Template, err error) {
 	pages = tr.pageTemplates
 	if pages == nil {
-		all, err := tr.getAll()
+		root, err := tr.getRoot()
 		if err != nil {
 			return nil, err
 		}
-		pages, err = instantiateTemplates(tr.embeds.TemplatesFS, all, filterPageTemplate)
+		pages, err = instantiateModuleTemplates(tr.embeds.TemplatesFS, root, filterPageTemplate)
 		if err != nil {
 			return nil, errors.Wrap(err, "couldn't instantiate page templates")
 		}
@@ -242,9 +385,8 @@ func (tr TemplateRenderer) getPages() (pages map[string]*template.Template, err
 func (tr TemplateRenderer) getAll() (all *template.Template, err error) {
 	all = tr.allTemplates
 	if all == nil {
-		all = template.New("App")
-		for _, f := range tr.funcs {
-			all = all.Funcs(f)
+		if all, err = tr.getRoot(); err != nil {
+			return nil, err
 		}
 		if all, err = parseFS(all, tr.embeds.TemplatesFS, "**/*"+templateFileExt); err != nil {
 			return nil, errors.Wrap(err, "couldn't load templates from filesystem")
@@ -253,25 +395,27 @@ func (tr TemplateRenderer) getAll() (all *template.Template, err error) {
 	return all, nil
 }
 
-func instantiateTemplates(
-	templatesFS fs.FS, allTemplates *template.Template, templateFilter func(string) bool,
-) (templates map[string]*template.Template, err error) {
-	templates = make(map[string]*template.Template)
-	templateFiles, err := listFiles(templatesFS, templateFilter)
+// getRoot makes an empty template set with all template functions, for parsing templates into.
+func (tr TemplateRenderer) getRoot() (root *template.Template, err error) {
+	root = template.New("App")
+	funcs, err := tr.getFuncs()
 	if err != nil {
-		return nil, errors.Wrap(err, "couldn't list templates")
+		return nil, err
 	}
-	for _, name := range templateFiles {
-		all, err := allTemplates.Clone()
-		if err != nil {
-			return nil, errors.Wrap(err, "couldn't clone root template set")
-		}
-		templates[name], err = parseFS(all, templatesFS, name)
-		if err != nil {
-			return nil, errors.Wrapf(err, "couldn't make template set for %s", name)
-		}
+	for _, f := range funcs {
+		root = root.Funcs(f)
 	}
-	return templates, nil
+	return root, nil
+}
+
+// getFuncs returns the template functions provided by the TemplateRenderer, followed by the
+// template functions which the TemplateRenderer was initialized with (which may override them).
+func (tr TemplateRenderer) getFuncs() (funcs []template.FuncMap, err error) {
+	catalogs, err := tr.getMessageCatalogs()
+	if err != nil {
+		return nil, err
+	}
+	return append([]template.FuncMap{catalogs.funcs(), tr.componentFuncs()}, tr.funcs...), nil
 }
 
 // TemplateRenderer: Turbo Streams support
@@ -285,6 +429,11 @@ func (tr TemplateRenderer) TurboStream(
 }
 
 func (tr TemplateRenderer) WriteTurboStream(w io.Writer, messages ...turbostreams.Message) error {
+	tr, err := tr.loaded()
+	if err != nil {
+		return err
+	}
+
 	type renderedStreamMessage struct {
 		Action   turbostreams.Action
 		Targets  string
@@ -323,7 +472,10 @@ func (tr TemplateRenderer) WriteTurboStream(w io.Writer, messages ...turbostream
 	if err != nil {
 		return err
 	}
-	if err := turboStreamsTemplate.Execute(buf, rendered); err != nil {
+	start := time.Now()
+	err = turboStreamsTemplate.Execute(buf, rendered)
+	tr.observeRender(TurboStreamRender, "", start, buf.Len(), err)
+	if err != nil {
 		return errors.Wrap(err, "couldn't execute Turbo Streams message template")
 	}
 
@@ -363,11 +515,19 @@ func instantiateTurboStreamsTemplate(
 func (tr TemplateRenderer) WritePartial(
 	w io.Writer, partialName string, partialData any,
 ) error {
+	tr, err := tr.loaded()
+	if err != nil {
+		return err
+	}
 	tmpl, err := tr.getPartial(partialName)
 	if err != nil {
 		return err
 	}
-	if err := tmpl.ExecuteTemplate(w, partialName, partialData); err != nil {
+	cw := &countingWriter{w: w}
+	start := time.Now()
+	err = tmpl.ExecuteTemplate(cw, partialName, partialData)
+	tr.observeRender(PartialRender, partialName, start, cw.n, err)
+	if err != nil {
 		return errors.Wrapf(err, "couldn't execute partial template %s", partialName)
 	}
 	return nil
@@ -390,11 +550,11 @@ func (tr TemplateRenderer) getPartial(partialName string) (partial *template.Tem
 func (tr TemplateRenderer) getPartials() (partials map[string]*template.Template, err error) {
 	partials = tr.partialTemplates
 	if partials == nil {
-		all, err := tr.getAll()
+		root, err := tr.getRoot()
 		if err != nil {
 			return nil, err
 		}
-		partials, err = instantiateTemplates(tr.embeds.TemplatesFS, all, filterPartialTemplate)
+		partials, err = instantiateModuleTemplates(tr.embeds.TemplatesFS, root, filterPartialTemplate)
 		if err != nil {
 			return nil, errors.Wrap(err, "couldn't instantiate partial templates")
 		}
@@ -405,25 +565,57 @@ func (tr TemplateRenderer) getPartials() (partials map[string]*template.Template
 func (tr TemplateRenderer) WritePage(
 	w io.Writer, pageName string, pageData any,
 ) error {
+	tr, err := tr.loaded()
+	if err != nil {
+		return err
+	}
 	tmpl, err := tr.getPage(pageName)
 	if err != nil {
 		return err
 	}
-	if err := tmpl.ExecuteTemplate(w, pageName, pageData); err != nil {
+
+	// Server-Timing headers can only be added to a response before the page is written into it
+	rw, ok := w.(http.ResponseWriter)
+	if !ok || !tr.ServerTiming {
+		cw := &countingWriter{w: w}
+		start := time.Now()
+		err = tmpl.ExecuteTemplate(cw, pageName, pageData)
+		tr.observeRender(PageRender, pageName, start, cw.n, err)
+		if err != nil {
+			return errors.Wrapf(err, "couldn't execute page template %s", pageName)
+		}
+		return nil
+	}
+
+	buf := new(bytes.Buffer)
+	start := time.Now()
+	err = tmpl.ExecuteTemplate(buf, pageName, pageData)
+	duration := tr.observeRender(PageRender, pageName, start, buf.Len(), err)
+	if err != nil {
 		return errors.Wrapf(err, "couldn't execute page template %s", pageName)
 	}
-	return nil
+	WithServerTiming("render", duration, pageName)(rw.Header())
+	_, err = rw.Write(buf.Bytes())
+	return err
 }
 
 // TemplateRenderer: template existence checks
 
 func (tr TemplateRenderer) MustHave(templateNames ...string) {
+	if _, err := tr.loaded(); err != nil {
+		// Templates which failed to hot-reload will instead be reported when rendering pages
+		return
+	}
 	if err := tr.ShouldHave(templateNames...); err != nil {
 		panic(err)
 	}
 }
 
 func (tr TemplateRenderer) ShouldHave(templateNames ...string) error {
+	tr, err := tr.loaded()
+	if err != nil {
+		return err
+	}
 	all, err := tr.getAll()
 	if err != nil {
 		return err
@@ -444,5 +636,6 @@ func (tr TemplateRenderer) ShouldHave(templateNames ...string) error {
 			}
 		}
 	}
+	tr.required.add(templateNames...)
 	return nil
 }
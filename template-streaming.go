@@ -0,0 +1,153 @@
+package godest
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/sargassum-world/godest/handling"
+)
+
+// Streaming writer
+
+const headEndTag = "</head>"
+
+// headFlushingWriter writes the response header upon the first write, and it flushes the response
+// as soon as the end of the HTML document's head has been written, so that the browser can start
+// loading stylesheets and scripts linked in the head before the rest of the page is rendered.
+type headFlushingWriter struct {
+	w             http.ResponseWriter
+	status        int
+	translated    bool
+	headerOptions []HeaderOption
+
+	wroteHeader bool
+	flushedHead bool
+	// tail holds the end of previously-written data, so that the end tag of the head can be found
+	// even when it's split across multiple writes.
+	tail []byte
+}
+
+func (hw *headFlushingWriter) writeHeader() {
+	hw.w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if hw.translated {
+		// The page is rendered in the locale negotiated from the request
+		WithVary("Accept-Language")(hw.w.Header())
+	}
+	for _, headerOption := range hw.headerOptions {
+		headerOption(hw.w.Header())
+	}
+	hw.w.WriteHeader(hw.status)
+	hw.wroteHeader = true
+}
+
+func (hw *headFlushingWriter) Write(p []byte) (n int, err error) {
+	if !hw.wroteHeader {
+		hw.writeHeader()
+	}
+	if n, err = hw.w.Write(p); err != nil || hw.flushedHead {
+		return n, err
+	}
+
+	scanned := bytes.ToLower(append(hw.tail, p...))
+	if !bytes.Contains(scanned, []byte(headEndTag)) {
+		if len(scanned) >= len(headEndTag) {
+			scanned = scanned[len(scanned)-len(headEndTag)+1:]
+		}
+		hw.tail = scanned
+		return n, nil
+	}
+
+	hw.flushedHead = true
+	hw.tail = nil
+	// Some ResponseWriters can't be flushed, in which case the page is simply sent without an early
+	// flush
+	if err = handling.Except(
+		http.NewResponseController(hw.w).Flush(), http.ErrNotSupported,
+	); err != nil {
+		return n, errors.Wrap(err, "couldn't flush the head of the page")
+	}
+	return n, nil
+}
+
+// finish writes the response header if nothing was written, e.g. for a page with empty output.
+func (hw *headFlushingWriter) finish() {
+	if !hw.wroteHeader {
+		hw.writeHeader()
+	}
+}
+
+// TemplateRenderer: streaming page rendering
+
+// StreamPage is like [TemplateRenderer.Page], except that it executes the page template directly
+// into the response instead of buffering the entire page first, and it flushes the response as
+// soon as the page's head (e.g. as written by a layout template) has been rendered. This way, the
+// browser can start loading stylesheets and scripts before slower parts of the page are rendered.
+// If template execution fails before anything is written, the response is left untouched so that
+// the error can be handled normally; otherwise, the response will be incomplete.
+func (tr TemplateRenderer) StreamPage(
+	w http.ResponseWriter, r *http.Request,
+	status int, templateName string, templateData any, authData any,
+	headerOptions ...HeaderOption,
+) error {
+	tr, err := tr.loaded()
+	if err != nil {
+		return writeReloadError(w, err)
+	}
+	tmpl, err := tr.getPage(templateName)
+	if err != nil {
+		return err
+	}
+	if err = r.ParseForm(); err != nil {
+		return errors.Wrap(err, "couldn't parse URL query values and/or POST/PUT/PATCH request body")
+	}
+
+	hw := &headFlushingWriter{
+		w:             w,
+		status:        status,
+		translated:    tr.hasTranslations(),
+		headerOptions: headerOptions,
+	}
+	// Server-Timing headers can't be added to streamed pages, since rendering finishes only after
+	// the response header was sent
+	cw := &countingWriter{w: hw}
+	start := time.Now()
+	err = tmpl.ExecuteTemplate(cw, templateName, tr.NewRenderData(r, templateData, authData))
+	tr.observeRender(PageRender, templateName, start, cw.n, err)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't execute page template %s", templateName)
+	}
+	hw.finish()
+	return nil
+}
+
+// StreamCacheablePage is like [TemplateRenderer.CacheablePage], except that it renders the page
+// with [TemplateRenderer.StreamPage]. Because the Etag is computed from the page's template
+// fingerprints and data rather than from the rendered page, the Etag is checked before rendering
+// begins, and an unchanged page is answered with a 304 Not Modified response without being
+// rendered at all.
+func (tr TemplateRenderer) StreamCacheablePage(
+	w http.ResponseWriter, r *http.Request,
+	templateName string, templateData any, authData any,
+	headerOptions ...HeaderOption,
+) error {
+	tr, err := tr.loaded()
+	if err != nil {
+		return writeReloadError(w, err)
+	}
+	fingerprints, err := tr.getFingerprints()
+	if err != nil {
+		return err
+	}
+
+	if noContent, err := tr.setAndCheckPageEtag(
+		w, r, fingerprints.getEtagSegments, templateName, templateData, authData,
+	); noContent || (err != nil) {
+		return err
+	}
+	return tr.StreamPage(
+		w, r, http.StatusOK, templateName, templateData, authData, headerOptions...,
+	)
+}
@@ -0,0 +1,303 @@
+package godest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"golang.org/x/text/feature/plural"
+	"golang.org/x/text/language"
+
+	"github.com/sargassum-world/godest/session"
+)
+
+// Message catalogs
+
+const messageCatalogFileExt = ".json"
+
+func filterMessageCatalog(filePath string) bool {
+	return path.Ext(filePath) == messageCatalogFileExt
+}
+
+// pluralFormNames maps CLDR plural categories to the keys used for them in message catalogs.
+var pluralFormNames = map[plural.Form]string{
+	plural.Other: "other",
+	plural.Zero:  "zero",
+	plural.One:   "one",
+	plural.Two:   "two",
+	plural.Few:   "few",
+	plural.Many:  "many",
+}
+
+// message holds the variants of a translated message, keyed by plural category. A message without
+// plural variants only has an "other" variant.
+type message map[string]string
+
+func (m *message) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*m = message{pluralFormNames[plural.Other]: text}
+		return nil
+	}
+	variants := make(map[string]string)
+	if err := json.Unmarshal(data, &variants); err != nil {
+		return errors.New("message must be either a string or an object of plural variants")
+	}
+	if _, ok := variants[pluralFormNames[plural.Other]]; !ok {
+		return errors.Errorf(
+			"plural variants of message must include an %q variant", pluralFormNames[plural.Other],
+		)
+	}
+	*m = variants
+	return nil
+}
+
+// variant returns the variant of the message for the plural category or, if there is no such
+// variant, the "other" variant.
+func (m message) variant(form plural.Form) (format string, ok bool) {
+	if format, ok = m[pluralFormNames[form]]; ok {
+		return format, true
+	}
+	format, ok = m[pluralFormNames[plural.Other]]
+	return format, ok
+}
+
+// messageCatalogs holds the message catalogs of all locales which the app has translations for.
+type messageCatalogs struct {
+	locales  []string
+	tags     []language.Tag
+	catalogs map[string]map[string]message
+	matcher  language.Matcher
+}
+
+// loadMessageCatalogs loads message catalogs from JSON files named by their locales (e.g.
+// "en.json", "de-CH.json") in the filesystem. Each file maps message keys either to a message
+// string or to an object of message strings keyed by plural category ("zero", "one", "two",
+// "few", "many", and "other").
+func loadMessageCatalogs(fsys fs.FS) (c *messageCatalogs, err error) {
+	c = &messageCatalogs{
+		catalogs: make(map[string]map[string]message),
+	}
+	if fsys == nil {
+		return c, nil
+	}
+	files, err := listFiles(fsys, filterMessageCatalog)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't list message catalogs")
+	}
+	for _, file := range files {
+		tag, err := language.Parse(strings.TrimSuffix(path.Base(file), messageCatalogFileExt))
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't determine locale of message catalog %s", file)
+		}
+		locale := tag.String()
+		if _, ok := c.catalogs[locale]; ok {
+			return nil, errors.Errorf("found multiple message catalogs for locale %s", locale)
+		}
+		data, err := readFile(file, fsys)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't read message catalog %s", file)
+		}
+		messages := make(map[string]message)
+		if err = json.Unmarshal(data, &messages); err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse message catalog %s", file)
+		}
+		c.locales = append(c.locales, locale)
+		c.tags = append(c.tags, tag)
+		c.catalogs[locale] = messages
+	}
+	c.matcher = language.NewMatcher(c.tags)
+	return c, nil
+}
+
+// negotiate chooses the supported locale which best matches the preferred locales (in order of
+// preference), or the default locale if no supported locale matches.
+func (c *messageCatalogs) negotiate(defaultLocale string, preferred ...language.Tag) string {
+	if len(c.locales) == 0 {
+		return defaultLocale
+	}
+	if _, index, confidence := c.matcher.Match(preferred...); confidence != language.No {
+		return c.locales[index]
+	}
+	if defaultLocale != "" {
+		return defaultLocale
+	}
+	return c.locales[0]
+}
+
+// lookup finds the message in the catalog of the locale or, failing that, in the catalogs of the
+// locale's parents (e.g. "de" for "de-CH").
+func (c *messageCatalogs) lookup(locale, key string) (m message, tag language.Tag, ok bool) {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return nil, language.Und, false
+	}
+	for t := tag; ; t = t.Parent() {
+		if m, ok = c.catalogs[t.String()][key]; ok {
+			return m, tag, true
+		}
+		if t == language.Und {
+			return nil, tag, false
+		}
+	}
+}
+
+// translate formats the message with the arguments, using fmt-style formatting verbs. If there is
+// no translation for the message, the key is returned without formatting.
+func (c *messageCatalogs) translate(locale, key string, args ...any) string {
+	m, _, ok := c.lookup(locale, key)
+	if !ok {
+		return key
+	}
+	format, ok := m.variant(plural.Other)
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// translatePlural formats the variant of the message for the plural category of the count, with
+// the count and then the arguments, using fmt-style formatting verbs. If there is no variant for
+// the plural category, the "other" variant is used instead. If there is no translation for the
+// message, the key is returned without formatting.
+func (c *messageCatalogs) translatePlural(locale, key string, count int, args ...any) string {
+	m, tag, ok := c.lookup(locale, key)
+	if !ok {
+		return key
+	}
+	n := count
+	if n < 0 {
+		n = -n
+	}
+	format, ok := m.variant(plural.Cardinal.MatchPlural(tag, n, 0, 0, 0, 0))
+	if !ok {
+		return key
+	}
+	return fmt.Sprintf(format, append([]any{count}, args...)...)
+}
+
+// funcs returns template functions for translating messages into the locale of the page. The
+// translate function takes the [RenderDataMeta] of the page, the message key, and any formatting
+// arguments, e.g. `{{translate .Meta "greeting" .Data.Name}}`. The plural function additionally
+// takes a count which chooses the plural variant of the message and which is passed as the first
+// formatting argument, e.g. `{{plural .Meta "unread-messages" .Data.Unread}}`.
+func (c *messageCatalogs) funcs() template.FuncMap {
+	return template.FuncMap{
+		"translate": func(meta RenderDataMeta, key string, args ...any) string {
+			return c.translate(meta.Locale, key, args...)
+		},
+		"plural": func(meta RenderDataMeta, key string, count int, args ...any) string {
+			return c.translatePlural(meta.Locale, key, count, args...)
+		},
+	}
+}
+
+// Locale preferences
+
+type localeContextKey struct{}
+
+// PreferredLocale returns the locale which was explicitly chosen for the request (e.g. by the user
+// in their session) and added to the request context by [LocaleMiddleware], or an empty string if
+// there is no such locale.
+func PreferredLocale(ctx context.Context) string {
+	locale, ok := ctx.Value(localeContextKey{}).(string)
+	if !ok {
+		return ""
+	}
+	return locale
+}
+
+// LocalePreference looks up the locale which was explicitly chosen for the request, returning an
+// empty string if no locale was chosen.
+type LocalePreference func(r *http.Request) (string, error)
+
+// SessionLocale creates a [LocalePreference] which looks up the locale from a string value in the
+// request's session.
+func SessionLocale(ss *session.Store, key string) LocalePreference {
+	return func(r *http.Request) (string, error) {
+		sess, err := ss.Get(r)
+		if err != nil {
+			return "", err
+		}
+		locale, _ := sess.Values[key].(string)
+		return locale, nil
+	}
+}
+
+// LocaleMiddleware returns an http middleware which adds the locale from the first preference
+// which has a locale for the request to the request's context, where it can be looked up with
+// [PreferredLocale]. Preferences which fail are skipped, since the locale can still be negotiated
+// from the request's Accept-Language header.
+func LocaleMiddleware(preferences ...LocalePreference) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, preference := range preferences {
+				if locale, err := preference(r); err == nil && locale != "" {
+					r = r.WithContext(context.WithValue(r.Context(), localeContextKey{}, locale))
+					break
+				}
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// EchoLocaleMiddleware returns the [LocaleMiddleware] as an echo.MiddlewareFunc.
+func EchoLocaleMiddleware(preferences ...LocalePreference) echo.MiddlewareFunc {
+	return echo.WrapMiddleware(LocaleMiddleware(preferences...))
+}
+
+// TemplateRenderer: locale negotiation
+
+func (tr TemplateRenderer) getMessageCatalogs() (catalogs *messageCatalogs, err error) {
+	catalogs = tr.messageCatalogs
+	if catalogs == nil {
+		if catalogs, err = loadMessageCatalogs(tr.embeds.MessagesFS); err != nil {
+			return nil, errors.Wrap(err, "couldn't load message catalogs from filesystem")
+		}
+	}
+	return catalogs, nil
+}
+
+// NegotiateLocale chooses the locale for rendering templates for the request, from among the
+// locales of the message catalogs in the Embeds' MessagesFS. The locale added to the request
+// context by [LocaleMiddleware] takes precedence over the locales in the Accept-Language header.
+// If no locale matches, the TemplateRenderer's DefaultLocale is chosen.
+func (tr TemplateRenderer) NegotiateLocale(r *http.Request) string {
+	tr, err := tr.loaded()
+	if err != nil {
+		return tr.DefaultLocale
+	}
+	catalogs, err := tr.getMessageCatalogs()
+	if err != nil {
+		return tr.DefaultLocale
+	}
+
+	var preferred []language.Tag
+	if tag, err := language.Parse(PreferredLocale(r.Context())); err == nil {
+		preferred = append(preferred, tag)
+	}
+	if accepted, _, err := language.ParseAcceptLanguage(
+		strings.Join(r.Header.Values("Accept-Language"), ","),
+	); err == nil {
+		preferred = append(preferred, accepted...)
+	}
+	return catalogs.negotiate(tr.DefaultLocale, preferred...)
+}
+
+// hasTranslations checks whether the TemplateRenderer has any message catalogs, in which case
+// responses vary by locale.
+func (tr TemplateRenderer) hasTranslations() bool {
+	catalogs, err := tr.getMessageCatalogs()
+	return err == nil && len(catalogs.locales) > 0
+}
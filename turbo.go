@@ -0,0 +1,128 @@
+package godest
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/sargassum-world/godest/turbostreams"
+)
+
+// Turbo request headers
+
+const (
+	// TurboFrameHeader is the request header in which Turbo identifies the frame which a request was
+	// made for.
+	TurboFrameHeader = "Turbo-Frame"
+	// TurboVisitHeader is the request header in which the action of a Turbo Drive visit (e.g.
+	// "advance" or "replace") is identified.
+	TurboVisitHeader = "Turbo-Visit"
+	// TurboRequestIDHeader is the request header in which Turbo identifies each request it makes,
+	// e.g. so that Turbo Streams broadcast in response to the request can be ignored by the client
+	// which made the request.
+	TurboRequestIDHeader = "X-Turbo-Request-Id"
+)
+
+// TurboResponseType is the type of response which a Turbo request should be answered with.
+type TurboResponseType int
+
+const (
+	// TurboPageResponse is a full page.
+	TurboPageResponse TurboResponseType = iota
+	// TurboFrameResponse is the fragment of the page for the requested Turbo Frame.
+	TurboFrameResponse
+	// TurboStreamResponse is a Turbo Streams message.
+	TurboStreamResponse
+)
+
+// TurboRequest describes how Turbo made a request.
+type TurboRequest struct {
+	// Frame is the ID of the Turbo Frame which the request was made for, if any.
+	Frame string
+	// Visit is the action of the Turbo Drive visit which made the request, if any.
+	Visit string
+	// RequestID is the ID which Turbo assigned to the request, if any.
+	RequestID string
+	// Stream is whether the request accepts a Turbo Streams response.
+	Stream bool
+}
+
+// ParseTurboRequest determines how Turbo made a request from the request's headers.
+func ParseTurboRequest(h http.Header) TurboRequest {
+	return TurboRequest{
+		Frame:     h.Get(TurboFrameHeader),
+		Visit:     h.Get(TurboVisitHeader),
+		RequestID: h.Get(TurboRequestIDHeader),
+		Stream:    turbostreams.Accepted(h),
+	}
+}
+
+// ResponseType chooses the type of response for the request: a Turbo Streams message if the
+// request accepts one, or else the fragment of a page for the requested Turbo Frame, or else a
+// full page.
+func (t TurboRequest) ResponseType() TurboResponseType {
+	switch {
+	case t.Stream:
+		return TurboStreamResponse
+	case t.Frame != "":
+		return TurboFrameResponse
+	default:
+		return TurboPageResponse
+	}
+}
+
+// turboVaryHeaders are the request headers which determine the type of response for a Turbo
+// request.
+var turboVaryHeaders = []string{TurboFrameHeader, "Accept"}
+
+type turboRequestContextKey struct{}
+
+// GetTurboRequest returns the [TurboRequest] which was added to the request's context by
+// [TurboMiddleware], or else it parses the [TurboRequest] from the request's headers.
+func GetTurboRequest(r *http.Request) TurboRequest {
+	if t, ok := r.Context().Value(turboRequestContextKey{}).(TurboRequest); ok {
+		return t
+	}
+	return ParseTurboRequest(r.Header)
+}
+
+// TurboMiddleware returns an http middleware which parses the [TurboRequest] of each request into
+// the request's context (where it can be looked up with [GetTurboRequest], and from which it's
+// added to [RenderDataMeta]), and which adds the headers determining the type of response for a
+// Turbo request to the response's Vary header.
+func TurboMiddleware() func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = r.WithContext(
+				context.WithValue(r.Context(), turboRequestContextKey{}, ParseTurboRequest(r.Header)),
+			)
+			WithVary(turboVaryHeaders...)(w.Header())
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// EchoTurboMiddleware returns the [TurboMiddleware] as an echo.MiddlewareFunc.
+func EchoTurboMiddleware() echo.MiddlewareFunc {
+	return echo.WrapMiddleware(TurboMiddleware())
+}
+
+// TemplateRenderer: Turbo responses
+
+// TurboPage answers the request with the type of response chosen by [TurboRequest.ResponseType]:
+// with the Turbo Streams messages (as with [TemplateRenderer.TurboStream]) if the request accepts
+// them and any messages are provided, or else with the fragment for the requested Turbo Frame or
+// the full page (as with [TemplateRenderer.FramePage]).
+func (tr TemplateRenderer) TurboPage(
+	w http.ResponseWriter, r *http.Request,
+	status int, pageName string, templateData any, authData any,
+	messages []turbostreams.Message,
+	headerOptions ...HeaderOption,
+) error {
+	WithVary(turboVaryHeaders...)(w.Header())
+	if GetTurboRequest(r).ResponseType() == TurboStreamResponse && len(messages) > 0 {
+		return tr.TurboStream(w, messages...)
+	}
+	return tr.FramePage(w, r, status, pageName, templateData, authData, headerOptions...)
+}
@@ -3,6 +3,8 @@ package turbostreams
 import (
 	"context"
 	"encoding/json"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -27,6 +29,26 @@ type Channel struct {
 	h          *pubsub.Hub[[]Message]
 	subscriber subscriber
 	sessionID  string
+	// broker is used for the positions of messages and for replaying messages from history, if the
+	// Channel was created by a ChannelFactory from NewChannelFactory
+	broker *Broker
+
+	// mu protects the fields below, which keep track of the positions of messages sent to the
+	// subscriber, so that messages replayed from history aren't sent again when they're also
+	// received live (or vice versa)
+	mu                 sync.Mutex
+	epoch              string
+	firstLiveOffset    uint64
+	lastReplayedOffset uint64
+}
+
+// newStreamPosition converts the position of a message in the history of the Hub for use with
+// Action Cable.
+func newStreamPosition(position pubsub.StreamPosition) actioncable.StreamPosition {
+	return actioncable.StreamPosition{
+		Epoch:  position.Epoch,
+		Offset: position.Offset,
+	}
 }
 
 // parseStreamName parses the Turbo Streams stream name from the Action Cable subscription
@@ -76,14 +98,30 @@ func (c *Channel) Subscribe(ctx context.Context, sub *actioncable.Subscription)
 		)
 	}
 
-	finished := c.subscriber(
-		ctx, c.streamName, c.sessionID, func(ctx context.Context, rendered string) error {
-			return errors.Wrap(
-				handling.Except(sub.SendText(ctx, rendered), context.Canceled),
-				"couldn't send turbo streams messages over action cable",
-			)
-		},
-	)
+	var finished <-chan struct{}
+	if c.broker == nil {
+		finished = c.subscriber(
+			ctx, c.streamName, c.sessionID, func(ctx context.Context, rendered string) error {
+				return errors.Wrap(
+					handling.Except(sub.SendText(ctx, rendered), context.Canceled),
+					"couldn't send turbo streams messages over action cable",
+				)
+			},
+		)
+	} else {
+		c.mu.Lock()
+		c.epoch, c.firstLiveOffset, c.lastReplayedOffset = "", 0, 0
+		c.mu.Unlock()
+		finished = c.broker.SubscribeWithPosition(
+			ctx, c.streamName, c.sessionID,
+			func(ctx context.Context, rendered string, position pubsub.StreamPosition) error {
+				return errors.Wrap(
+					handling.Except(c.sendLive(ctx, sub, rendered, position), context.Canceled),
+					"couldn't send turbo streams messages over action cable",
+				)
+			},
+		)
+	}
 	go func() {
 		<-finished
 		sub.Close()
@@ -91,17 +129,100 @@ func (c *Channel) Subscribe(ctx context.Context, sub *actioncable.Subscription)
 	return nil
 }
 
+// sendLive sends a message which was broadcast on the channel's stream, unless it was already
+// replayed from history.
+func (c *Channel) sendLive(
+	ctx context.Context, sub *actioncable.Subscription, rendered string,
+	position pubsub.StreamPosition,
+) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if position.Epoch != "" {
+		switch {
+		case position.Epoch != c.epoch:
+			c.epoch, c.firstLiveOffset, c.lastReplayedOffset = position.Epoch, position.Offset, 0
+		case position.Offset <= c.lastReplayedOffset:
+			return nil
+		case c.firstLiveOffset == 0 || position.Offset < c.firstLiveOffset:
+			c.firstLiveOffset = position.Offset
+		}
+	}
+	return sub.SendTextAt(ctx, rendered, c.streamName, newStreamPosition(position))
+}
+
+// History handles an Action Cable history request from the client by replaying the messages on
+// the channel's stream which the client missed, from the [pubsub.History] of the channel's Hub:
+// the messages after the position requested for the channel's stream name (which is the stream ID
+// of the channel's messages), or else the messages since the requested time. Messages which were
+// already received live by the subscription aren't sent again. Positions are only valid within the
+// epoch of the History which recorded them; when the app runs as multiple processes with their own
+// epochs (see [pubsub.Hub.SetHistory]), a client which reconnects to a different process has its
+// request for the messages after a position rejected, and must instead request the messages since
+// a time.
+func (c *Channel) History(
+	ctx context.Context, sub *actioncable.Subscription, request actioncable.HistoryRequest,
+) error {
+	history := c.h.History()
+	if c.broker == nil || history == nil {
+		return errors.Wrapf(pubsub.ErrHistoryUnavailable, "no history for stream %s", c.streamName)
+	}
+	var entries []pubsub.HistoryEntry[[]Message]
+	var err error
+	if position, ok := request.Streams[c.streamName]; ok {
+		entries, err = history.After(ctx, c.streamName, pubsub.StreamPosition{
+			Epoch:  position.Epoch,
+			Offset: position.Offset,
+		})
+	} else if request.Since > 0 {
+		entries, err = history.Since(ctx, c.streamName, time.Unix(request.Since, 0))
+	}
+	if err != nil {
+		return errors.Wrapf(err, "couldn't look up history of stream %s", c.streamName)
+	}
+
+	// We hold the mutex while replaying messages so that live messages are sent after them, and so
+	// that we can skip any live messages which were replayed
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return errors.Wrap(
+		c.broker.Replay(
+			ctx, c.streamName, c.sessionID, entries,
+			func(ctx context.Context, rendered string, position pubsub.StreamPosition) error {
+				if position.Epoch == c.epoch && c.firstLiveOffset > 0 &&
+					position.Offset >= c.firstLiveOffset {
+					// All subsequent messages were or will be received live
+					return nil
+				}
+				c.epoch = position.Epoch
+				c.lastReplayedOffset = max(c.lastReplayedOffset, position.Offset)
+				return sub.SendTextAt(ctx, rendered, c.streamName, newStreamPosition(position))
+			},
+		),
+		"couldn't replay turbo streams messages over action cable",
+	)
+}
+
 // Perform handles an Action Cable action command from the client.
 func (c *Channel) Perform(data string) error {
 	return errors.New("turbo streams channel cannot perform any actions")
 }
 
 // NewChannelFactory creates an [actioncable.ChannelFactory] for Turbo Streams to create channels
-// for different Turbo Streams streams as needed.
+// for different Turbo Streams streams as needed. The channels can be served over WebSockets with an
+// [actioncable.Conn] or over Server-Sent Events with an [actioncable.SSEConn]. If the broker's Hub
+// has a [pubsub.History], the channels send the positions of messages to clients using the
+// Action Cable extended protocol, and they replay missed messages when those clients request them.
 func NewChannelFactory(
 	b *Broker, sessionID string, checkers ...actioncable.IdentifierChecker,
 ) actioncable.ChannelFactory {
 	return func(identifier string) (actioncable.Channel, error) {
-		return NewChannel(identifier, b.Hub(), b.Subscribe, sessionID, checkers)
+		channel, err := NewChannel(identifier, b.Hub(), b.Subscribe, sessionID, checkers)
+		if err != nil {
+			return nil, err
+		}
+		channel.broker = b
+		return channel, nil
 	}
 }
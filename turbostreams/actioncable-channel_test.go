@@ -0,0 +1,190 @@
+package turbostreams
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/sargassum-world/godest/actioncable"
+	"github.com/sargassum-world/godest/actioncable/actioncabletest"
+	"github.com/sargassum-world/godest/pubsub"
+)
+
+const testStreamName = "/stream"
+
+// capturingChannel is a Channel which also provides its subscription to the test, so that the
+// test can deliver messages to it as if they had been broadcast.
+type capturingChannel struct {
+	*Channel
+	subs chan<- *actioncable.Subscription
+}
+
+func (c capturingChannel) Subscribe(ctx context.Context, sub *actioncable.Subscription) error {
+	if err := c.Channel.Subscribe(ctx, sub); err != nil {
+		return err
+	}
+	c.subs <- sub
+	return nil
+}
+
+// channelStep either delivers the message at the offset as a live message, or requests the
+// messages after the offset from history.
+type channelStep struct {
+	live   bool
+	offset uint64
+}
+
+func TestChannelReplayOverlappingLive(t *testing.T) {
+	const numEntries = 4
+	cases := []struct {
+		name  string
+		steps []channelStep
+		want  []uint64
+	}{
+		{
+			name:  "replay only",
+			steps: []channelStep{{offset: 1}},
+			want:  []uint64{2, 3, 4},
+		},
+		{
+			name:  "live after replay",
+			steps: []channelStep{{offset: 0}, {live: true, offset: 3}, {live: true, offset: 4}},
+			want:  []uint64{1, 2, 3, 4},
+		},
+		{
+			name:  "replay after live",
+			steps: []channelStep{{live: true, offset: 3}, {live: true, offset: 4}, {offset: 0}},
+			want:  []uint64{3, 4, 1, 2},
+		},
+		{
+			name:  "replay after live of next message",
+			steps: []channelStep{{live: true, offset: 2}, {offset: 1}, {live: true, offset: 3}},
+			want:  []uint64{2, 3},
+		},
+		{
+			name: "live during replay",
+			steps: []channelStep{
+				{live: true, offset: 3}, {offset: 0}, {live: true, offset: 2}, {live: true, offset: 4},
+			},
+			want: []uint64{3, 1, 2, 4},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			channel, sub, client, entries := newTestChannel(ctx, t, numEntries)
+			epoch := entries[0].Position.Epoch
+			for _, step := range c.steps {
+				if !step.live {
+					if err := channel.History(ctx, sub, actioncable.HistoryRequest{
+						Streams: map[string]actioncable.StreamPosition{
+							testStreamName: {Epoch: epoch, Offset: step.offset},
+						},
+					}); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				entry := entries[step.offset-1]
+				if err := channel.sendLive(
+					ctx, sub, entry.Message[0].Target, entry.Position,
+				); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := sub.SendText(ctx, "done"); err != nil {
+				t.Fatal(err)
+			}
+
+			var received []string
+			for {
+				text, err := client.ExpectData(ctx, sub.Identifier())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if text == "done" {
+					break
+				}
+				received = append(received, text)
+			}
+			want := make([]string, len(c.want))
+			for i, offset := range c.want {
+				want[i] = fmt.Sprintf("message %d", offset)
+			}
+			if !slices.Equal(received, want) {
+				t.Errorf("received %q, want %q", received, want)
+			}
+		})
+	}
+}
+
+// newTestChannel creates a Channel subscribed by a client over the extended protocol, with the
+// specified number of messages already in the history of the channel's Hub.
+func newTestChannel(
+	ctx context.Context, t *testing.T, numEntries int,
+) (*Channel, *actioncable.Subscription, *actioncabletest.Client, []pubsub.HistoryEntry[[]Message]) {
+	t.Helper()
+
+	b := NewBroker(echo.New().Logger)
+	b.SUB(testStreamName, EmptyHandler)
+	b.MSG(testStreamName, func(c *Context) error {
+		_, err := c.MsgWriter().Write([]byte(c.Published()[0].Target))
+		return err
+	})
+	bctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		_ = b.Serve(bctx)
+	}()
+	history := pubsub.NewMemoryHistory[[]Message](numEntries, 0)
+	b.Hub().SetHistory(history)
+	entries := make([]pubsub.HistoryEntry[[]Message], numEntries)
+	for i := range entries {
+		entry, err := history.Append(
+			ctx, testStreamName, []Message{{Target: fmt.Sprintf("message %d", i+1)}},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[i] = entry
+	}
+
+	channels := make(chan *Channel, 1)
+	subs := make(chan *actioncable.Subscription, 1)
+	newChannel := NewChannelFactory(b, "")
+	s := actioncabletest.NewServer(actioncabletest.NewChannelsHandlerMaker(
+		map[string]actioncable.ChannelFactory{
+			ChannelName: func(identifier string) (actioncable.Channel, error) {
+				channel, err := newChannel(identifier)
+				if err != nil {
+					return nil, err
+				}
+				channels <- channel.(*Channel)
+				return capturingChannel{Channel: channel.(*Channel), subs: subs}, nil
+			},
+		},
+	))
+	t.Cleanup(s.Close)
+	client, err := actioncabletest.Dial(
+		ctx, s.WebSocketURL(), actioncable.ActionCableV1ExtJSONSubprotocol,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	if err = client.ExpectWelcome(ctx); err != nil {
+		t.Fatal(err)
+	}
+	identifier := fmt.Sprintf(`{"channel":%q,"name":%q}`, ChannelName, testStreamName)
+	if err = client.SubscribeAndConfirm(ctx, identifier); err != nil {
+		t.Fatal(err)
+	}
+	return <-channels, <-subs, client, entries
+}
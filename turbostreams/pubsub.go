@@ -7,6 +7,7 @@ import (
 
 	"github.com/pkg/errors"
 
+	"github.com/sargassum-world/godest/marshaling"
 	"github.com/sargassum-world/godest/pubsub"
 )
 
@@ -156,7 +157,22 @@ func (b *Broker) Subscribe(
 	ctx context.Context, streamName, sessionID string,
 	msgConsumer func(ctx context.Context, rendered string) error,
 ) (finished <-chan struct{}) {
-	return b.broker.Subscribe(
+	return b.SubscribeWithPosition(
+		ctx, streamName, sessionID,
+		func(ctx context.Context, rendered string, _ pubsub.StreamPosition) error {
+			return msgConsumer(ctx, rendered)
+		},
+	)
+}
+
+// SubscribeWithPosition adds a subscription like [Broker.Subscribe], but the message consumer
+// callback function is also given the position of the broadcast messages in the [pubsub.History]
+// of the broker's Hub (or the zero position, if the Hub has no History).
+func (b *Broker) SubscribeWithPosition(
+	ctx context.Context, streamName, sessionID string,
+	msgConsumer func(ctx context.Context, rendered string, position pubsub.StreamPosition) error,
+) (finished <-chan struct{}) {
+	return b.broker.SubscribeWithPosition(
 		ctx, streamName,
 		func(c *pubsub.BrokerContext[*Context, Message]) *Context {
 			return &Context{
@@ -164,17 +180,38 @@ func (b *Broker) Subscribe(
 				sessionID:     sessionID,
 			}
 		},
-		func(ctx context.Context, messages []Message) error {
+		func(ctx context.Context, messages []Message, position pubsub.StreamPosition) error {
 			rendered, err := b.triggerMsg(ctx, streamName, sessionID, messages)
 			if err != nil {
 				b.logger.Error(errors.Wrapf(err, "msg handler on stream %s failed", streamName))
 				return err
 			}
-			return msgConsumer(ctx, rendered)
+			return msgConsumer(ctx, rendered, position)
 		},
 	)
 }
 
+// Replay renders the messages of the history entries (e.g. from the [pubsub.History] of the
+// broker's Hub) for the stream name with the MSG handler for the stream name, in the same way as
+// for messages broadcast to subscriptions added by [Broker.Subscribe], and passes the results to
+// the message consumer callback function in order. This way, a subscriber which missed messages
+// (e.g. while its connection was interrupted) can receive them after it subscribes again.
+func (b *Broker) Replay(
+	ctx context.Context, streamName, sessionID string, entries []pubsub.HistoryEntry[[]Message],
+	msgConsumer func(ctx context.Context, rendered string, position pubsub.StreamPosition) error,
+) error {
+	for _, entry := range entries {
+		rendered, err := b.triggerMsg(ctx, streamName, sessionID, entry.Message)
+		if err != nil {
+			return errors.Wrapf(err, "couldn't replay message at offset %d", entry.Position.Offset)
+		}
+		if err = msgConsumer(ctx, rendered, entry.Position); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Serve launches and cancels PUB handlers based on the appearance and disappearance of
 // subscriptions for the PUB handlers' corresponding stream names. The PUB handler for a stream name
 // is started in a goroutine when a new subscription is added to the broker (or to the broker's Hub)
@@ -191,6 +228,16 @@ func (b *Broker) Serve(ctx context.Context) error {
 	})
 }
 
+// ServeBackplane forwards broadcasts between the broker's [Hub] and the Hubs of other processes
+// subscribed to the backplane (e.g. other replicas of the app), blocking until the context is
+// canceled or the backplane fails. Messages are marshaled as JSON, so the Data of each forwarded
+// [Message] is rendered by other processes from its JSON representation (e.g. structs become maps
+// whose values can still be accessed by key, but whose methods can't be called). Refer to
+// [pubsub.Hub.ServeBackplane] for details.
+func (b *Broker) ServeBackplane(ctx context.Context, backplane pubsub.Backplane) error {
+	return b.broker.ServeBackplane(ctx, backplane, marshaling.JSON{})
+}
+
 // Router is the subset of [Broker] methods for adding handlers to routes.
 type Router interface {
 	pubsub.Router[*Context]